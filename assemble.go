@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// maxAssemblies is how many unfinished runs of pipes are held at most, dropping the least recently posted one beyond
+const maxAssemblies = 64
+
+// assembly holds the models posted by the batches of a Sesam pipe run until its last batch, merging the models
+// spread across batches by '_id'
+type assembly struct {
+	models []map[string]json.RawMessage
+	index  map[string]int // of models by '_id'
+	posted time.Time      // of the last batch
+}
+
+// assemble returns the models of the batch to convert, none until the last batch of a run as told by the Sesam
+// query parameters 'is_first' and 'is_last' (default true) of the request. The models of the batches of a run of the
+// pipe of query parameter 'pipe_id' are held until its last batch, when they are returned merged: the inner entities
+// of a model in field jField are appended to those of the model of the same '_id' in earlier batches. Runs not posted
+// to for longer than option 'assembly_timeout' are dropped, as is a run failing to merge.
+func (s *Server) assemble(r *http.Request, jField string, batch []map[string]json.RawMessage) ([]map[string]json.RawMessage, error) {
+	first, last, pipe, err := runOf(r)
+	if err != nil {
+		return nil, err
+	}
+
+	s.assembling.Lock()
+	defer s.assembling.Unlock()
+	now := time.Now()
+	s.expire(r, now)
+	a := s.assemblies[pipe]
+	if first && a != nil {
+		s.log(r).Warnf("discarding %d models of an unfinished run of the pipe", len(a.models))
+		delete(s.assemblies, pipe)
+		a = nil
+	}
+	if a == nil {
+		if last {
+			return batch, nil
+		}
+		a = &assembly{index: map[string]int{}}
+	}
+	if err = a.merge(jField, batch); err != nil {
+		delete(s.assemblies, pipe)
+		return nil, err
+	}
+	a.posted = now
+	if !last {
+		if s.assemblies[pipe] == nil {
+			s.evict(r)
+		}
+		s.assemblies[pipe] = a
+		s.log(r).Logkv(logINFO, "holding models until the last batch of the pipe", "models", len(a.models))
+		return nil, nil
+	}
+	delete(s.assemblies, pipe)
+	return a.models, nil
+}
+
+// assembles tells whether the models of the batch of the request are to be assembled with those of other batches of
+// its run, being held until the last batch or completing a run held
+func (s *Server) assembles(r *http.Request) (bool, error) {
+	first, last, pipe, err := runOf(r)
+	if err != nil || !last {
+		return !last, err
+	}
+	s.assembling.Lock()
+	defer s.assembling.Unlock()
+	s.expire(r, time.Now())
+	a := s.assemblies[pipe]
+	if first && a != nil {
+		s.log(r).Warnf("discarding %d models of an unfinished run of the pipe", len(a.models))
+		delete(s.assemblies, pipe)
+		a = nil
+	}
+	return a != nil, nil
+}
+
+// runOf returns the Sesam query parameters 'is_first', 'is_last' and 'pipe_id' of the request
+func runOf(r *http.Request) (first bool, last bool, pipe string, err error) {
+	query := r.URL.Query()
+	first, last = false, true
+	if val := query.Get("is_first"); len(val) != 0 {
+		if first, err = strconv.ParseBool(val); err != nil {
+			return first, last, pipe, newProblem(http.StatusBadRequest, problemMalformedRequest, "expected query parameter 'is_first' to be a boolean, but got '%s'", val)
+		}
+	}
+	if val := query.Get("is_last"); len(val) != 0 {
+		if last, err = strconv.ParseBool(val); err != nil {
+			return first, last, pipe, newProblem(http.StatusBadRequest, problemMalformedRequest, "expected query parameter 'is_last' to be a boolean, but got '%s'", val)
+		}
+	}
+	pipe = query.Get("pipe_id")
+	if !last && len(pipe) == 0 {
+		return first, last, pipe, newProblem(http.StatusBadRequest, problemMalformedRequest, "expected query parameter 'pipe_id' of a batch with 'is_last=false'")
+	}
+	return first, last, pipe, nil
+}
+
+// merge adds the models of the batch to the assembly, appending the inner entities in field jField of a model to
+// those of the model of the same '_id'
+func (a *assembly) merge(jField string, batch []map[string]json.RawMessage) error {
+	for index, model := range batch {
+		var id string
+		if json.Unmarshal(model["_id"], &id) != nil || len(id) == 0 {
+			a.models = append(a.models, model)
+			continue
+		}
+		i, exist := a.index[id]
+		if !exist {
+			a.index[id] = len(a.models)
+			a.models = append(a.models, model)
+			continue
+		}
+		merged := a.models[i]
+		var entities, more []json.RawMessage
+		if err := json.Unmarshal(merged[jField], &entities); err != nil && merged[jField] != nil {
+			return newProblem(http.StatusBadRequest, problemConversion, "expected field '%s' of the model of an earlier batch to be an array of entities", jField).at(index, id)
+		}
+		if err := json.Unmarshal(model[jField], &more); err != nil && model[jField] != nil {
+			return newProblem(http.StatusBadRequest, problemConversion, "expected field '%s' to be an array of entities", jField).at(index, id)
+		}
+		for k, v := range model {
+			merged[k] = v
+		}
+		var err error
+		if merged[jField], err = json.Marshal(append(entities, more...)); err != nil {
+			return newProblem(http.StatusInternalServerError, problemInternal, "%s", err).at(index, id)
+		}
+	}
+	return nil
+}
+
+// expire drops the runs not posted to within the assembly timeout
+func (s *Server) expire(r *http.Request, now time.Time) {
+	timeout := s.optionsOf(r).assembly
+	for pipe, a := range s.assemblies {
+		if timeout > 0 && now.Sub(a.posted) > timeout {
+			s.log(r).Warnf("dropping %d models of the run of pipe '%s' not posted to for %s", len(a.models), pipe, now.Sub(a.posted))
+			delete(s.assemblies, pipe)
+		}
+	}
+}
+
+// evict drops the least recently posted runs making room for another one
+func (s *Server) evict(r *http.Request) {
+	for len(s.assemblies) >= maxAssemblies {
+		oldest := ""
+		for pipe, a := range s.assemblies {
+			if len(oldest) == 0 || a.posted.Before(s.assemblies[oldest].posted) {
+				oldest = pipe
+			}
+		}
+		s.log(r).Warnf("dropping %d models of the run of pipe '%s', holding too many runs", len(s.assemblies[oldest].models), oldest)
+		delete(s.assemblies, oldest)
+	}
+}
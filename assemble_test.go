@@ -0,0 +1,91 @@
+package main_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "sesam-cimrdf"
+)
+
+var _ = Describe("Microservice Sesam HTTP transform", func() {
+
+	var server *Server
+
+	Post := func(url string, body string) []map[string]interface{} {
+		response := httptest.NewRecorder()
+		request, _ := http.NewRequest("POST", url, strings.NewReader(body))
+		server.ServeHTTP(response, request)
+		Expect(response.Code).To(Equal(200), response.Body.String())
+		var result []map[string]interface{}
+		Expect(json.Unmarshal(response.Body.Bytes(), &result)).To(Succeed())
+		return result
+	}
+
+	BeforeEach(func() {
+		server = NewTestServer(Options{"log": ioutil.Discard, "seed": "ginkgo",
+			"pipes": map[string]interface{}{"lines": map[string]interface{}{"xml": "rdf", "partial": true}}})
+	})
+
+	It("assembles a model spread across the batches of a run", func() {
+		substation := `{"$ids": ["urn:uuid:00000000-0000-0000-0000-000000000001", "~:Substation:00000000-0000-0000-0000-000000000001"], "_id": "urn:uuid:00000000-0000-0000-0000-000000000001", "rdf:type": "~:cim:Substation"}`
+		line := `{"$ids": ["urn:uuid:00000000-0000-0000-0000-000000000002", "~:Line:00000000-0000-0000-0000-000000000002"], "_id": "urn:uuid:00000000-0000-0000-0000-000000000002", "rdf:type": "~:cim:Line"}`
+		Expect(Post("/convert?pipe_id=grid&is_first=true&is_last=false", `[{"_id": "m", "cim:Model.all": [`+substation+`]}]`)).To(BeEmpty())
+		Expect(Post("/convert?pipe_id=other", `[{"_id": "n", "cim:Model.all": []}]`)).To(HaveLen(1))
+		models := Post("/convert?pipe_id=grid&is_last=true", `[{"_id": "m", "cim:Model.all": [`+line+`]}, {"_id": "o", "cim:Model.all": []}]`)
+		Expect(models).To(HaveLen(2))
+		Expect(models[0]["xml"]).To(ContainSubstring("cim:Substation"))
+		Expect(models[0]["xml"]).To(ContainSubstring("cim:Line"))
+
+		Expect(Post("/convert?pipe_id=grid&is_last=false", `[{"_id": "m", "cim:Model.all": [`+substation+`]}]`)).To(BeEmpty())
+		models = Post("/convert?pipe_id=grid&is_first=true&is_last=true", `[{"_id": "m", "cim:Model.all": [`+line+`]}]`)
+		Expect(models[0]["xml"]).NotTo(ContainSubstring("cim:Substation"))
+	})
+
+	It("serves requests with the options of their pipe", func() {
+		Expect(Post("/convert?pipe_id=lines", `[{"_id": "m", "cim:Model.all": []}]`)[0]).To(HaveKey("rdf"))
+		Expect(Post("/convert?pipe_id=grid", `[{"_id": "m", "cim:Model.all": []}]`)[0]).To(HaveKey("xml"))
+		Expect(Post("/_id?pipe_id=lines", `[5, {"_id": "a"}]`)).To(HaveLen(2))
+	})
+
+	It("rejects malformed batching parameters, and held batches without a pipe", func() {
+		for _, url := range []string{"/convert?is_last=maybe", "/convert?is_last=false"} {
+			response := httptest.NewRecorder()
+			request, _ := http.NewRequest("POST", url, strings.NewReader(`[]`))
+			server.ServeHTTP(response, request)
+			Expect(response.Code).To(Equal(400))
+		}
+	})
+
+	It("fails and drops a run of models not having an array of entities", func() {
+		Expect(Post("/convert?pipe_id=grid&is_last=false", `[{"_id": "m", "cim:Model.all": 5}]`)).To(BeEmpty())
+		response := httptest.NewRecorder()
+		request, _ := http.NewRequest("POST", "/convert?pipe_id=grid&is_last=true", strings.NewReader(`[{"_id": "m", "cim:Model.all": []}]`))
+		server.ServeHTTP(response, request)
+		Expect(response.Code).To(Equal(400))
+		Expect(response.Body.String()).To(ContainSubstring(`"code":"conversion_failed"`))
+		Expect(response.Body.String()).To(ContainSubstring(`"_id":"m"`))
+		Expect(Post("/convert?pipe_id=grid&is_last=true", `[{"_id": "m", "cim:Model.all": []}]`)).To(HaveLen(1))
+	})
+
+	It("drops runs not posted to within the timeout, and the oldest runs beyond the limit", func() {
+		server = NewTestServer(Options{"log": ioutil.Discard, "seed": "ginkgo", "assembly_timeout": "50ms"})
+		Expect(Post("/convert?pipe_id=grid&is_last=false", `[{"_id": "m", "cim:Model.all": []}]`)).To(BeEmpty())
+		time.Sleep(100 * time.Millisecond)
+		Expect(Post("/convert?pipe_id=grid&is_last=true", `[{"_id": "n", "cim:Model.all": []}]`)).To(HaveLen(1))
+
+		server = NewTestServer(Options{"log": ioutil.Discard, "seed": "ginkgo"})
+		for i := 0; i <= 64; i++ {
+			Expect(Post("/convert?is_last=false&pipe_id=p"+strconv.Itoa(i), `[{"_id": "m", "cim:Model.all": []}]`)).To(BeEmpty())
+		}
+		Expect(Post("/convert?pipe_id=p0", `[{"_id": "n", "cim:Model.all": []}]`)).To(HaveLen(1))
+		Expect(Post("/convert?pipe_id=p64", `[{"_id": "n", "cim:Model.all": []}]`)).To(HaveLen(2))
+	})
+})
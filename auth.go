@@ -0,0 +1,324 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// route groups of the per-route claim restrictions
+const (
+	groupMint     string = "mint"
+	groupConvert  string = "convert"
+	groupValidate string = "validate"
+	groupSource   string = "source"
+)
+
+// clockSkew is the leeway of verifying the times of JWT claims
+const clockSkew = time.Minute
+
+// authenticator verifies JWT bearer tokens (RFC 7519) signed with HS256 by a shared secret,
+// or with RS256 or ES256 by a key of a local JWKS (RFC 7517) file
+type authenticator struct {
+	secret   []byte
+	keys     []jwk
+	audience string
+	issuer   string
+	claims   map[string]map[string]string // route group -> claim -> required value
+}
+
+// jwk is a public key of a JWKS
+type jwk struct {
+	kid string
+	alg string // RS256 or ES256
+	key crypto.PublicKey
+}
+
+// authenticatorOf returns the authenticator of options 'jwt_secret', 'jwks' (path of JWKS file), 'audience',
+// 'issuer' and 'claims', or nil when neither a secret nor a JWKS is given (authentication disabled)
+func authenticatorOf(opt Options) (*authenticator, error) {
+	a := &authenticator{claims: map[string]map[string]string{}}
+	if val, exist := opt["jwt_secret"]; exist && val != nil {
+		a.secret = []byte(fmt.Sprintf("%v", val))
+	}
+	if val, exist := opt["jwks"]; exist && val != nil && len(fmt.Sprintf("%v", val)) != 0 {
+		keys, err := loadJWKS(fmt.Sprintf("%v", val))
+		if err != nil {
+			return nil, err
+		}
+		a.keys = keys
+	}
+	if len(a.secret) == 0 && len(a.keys) == 0 {
+		return nil, nil
+	}
+	if val, exist := opt["audience"]; exist && val != nil {
+		a.audience = fmt.Sprintf("%v", val)
+	}
+	if val, exist := opt["issuer"]; exist && val != nil {
+		a.issuer = fmt.Sprintf("%v", val)
+	}
+	switch claims := opt["claims"].(type) {
+	case nil:
+	case string: // e.g. "mint:scope=uuid.mint,convert:scope=cim.convert"
+		for _, part := range strings.Split(claims, ",") {
+			part = strings.Trim(part, " ")
+			if len(part) == 0 {
+				continue
+			}
+			i, j := strings.Index(part, ":"), strings.Index(part, "=")
+			if i <= 0 || j < i+2 {
+				return nil, fmt.Errorf("expected option 'claims' to list 'group:claim=value', but got '%s'", part)
+			}
+			a.require(part[:i], part[i+1:j], part[j+1:])
+		}
+	case map[string]interface{}: // e.g. {"mint": {"scope": "uuid.mint"}}
+		for group, val := range claims {
+			required, ok := val.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("expected option 'claims' of '%s' to be an object of claim values, but got %T", group, val)
+			}
+			for claim, v := range required {
+				a.require(group, claim, fmt.Sprintf("%v", v))
+			}
+		}
+	case map[string]map[string]string:
+		for group, required := range claims {
+			for claim, v := range required {
+				a.require(group, claim, v)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("expected option 'claims' to be a string or object, but got %T", claims)
+	}
+	for group := range a.claims {
+		if group != groupMint && group != groupConvert && group != groupValidate && group != groupSource {
+			return nil, fmt.Errorf("expected option 'claims' of route groups %s, but got '%s'", strings.Join(routeGroups, ", "), group)
+		}
+	}
+	return a, nil
+}
+
+func (a *authenticator) require(group string, claim string, value string) {
+	if a.claims[group] == nil {
+		a.claims[group] = map[string]string{}
+	}
+	a.claims[group][claim] = value
+}
+
+// loadJWKS returns the RSA and P-256 EC public keys of a JWKS file
+func loadJWKS(path string) ([]jwk, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error loading JWKS: %s", err)
+	}
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Alg string `json:"alg"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			Crv string `json:"crv"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	if err = json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("expected JWKS '%s' to be a JSON object of 'keys', but got error: %s", path, err)
+	}
+	var keys []jwk
+	for i, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		switch k.Kty {
+		case "RSA":
+			n, err1 := base64.RawURLEncoding.DecodeString(k.N)
+			e, err2 := base64.RawURLEncoding.DecodeString(k.E)
+			if err1 != nil || err2 != nil || len(n) == 0 || len(e) == 0 {
+				return nil, fmt.Errorf("expected RSA key %d of JWKS '%s' to have base64url 'n' and 'e'", i, path)
+			}
+			key := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+			keys = append(keys, jwk{kid: k.Kid, alg: "RS256", key: key})
+		case "EC":
+			if k.Crv != "P-256" {
+				continue
+			}
+			x, err1 := base64.RawURLEncoding.DecodeString(k.X)
+			y, err2 := base64.RawURLEncoding.DecodeString(k.Y)
+			if err1 != nil || err2 != nil {
+				return nil, fmt.Errorf("expected EC key %d of JWKS '%s' to have base64url 'x' and 'y'", i, path)
+			}
+			key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+			if !key.Curve.IsOnCurve(key.X, key.Y) {
+				return nil, fmt.Errorf("expected EC key %d of JWKS '%s' to be on curve P-256", i, path)
+			}
+			keys = append(keys, jwk{kid: k.Kid, alg: "ES256", key: key})
+		}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("expected JWKS '%s' to have RS256 or ES256 signing keys", path)
+	}
+	return keys, nil
+}
+
+// verify returns the claims of a token with a valid signature, expiry, audience and issuer
+func (a *authenticator) verify(token string, now time.Time) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	data, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil || json.Unmarshal(data, &header) != nil {
+		return nil, fmt.Errorf("malformed token header")
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("malformed token signature")
+	}
+	signed := []byte(parts[0] + "." + parts[1])
+	digest := sha256.Sum256(signed)
+	valid := false
+	switch header.Alg {
+	case "HS256":
+		if len(a.secret) != 0 {
+			mac := hmac.New(sha256.New, a.secret)
+			mac.Write(signed)
+			valid = hmac.Equal(mac.Sum(nil), signature)
+		}
+	case "RS256", "ES256":
+		for _, k := range a.keys {
+			if k.alg != header.Alg || (header.Kid != "" && k.kid != "" && k.kid != header.Kid) {
+				continue
+			}
+			switch key := k.key.(type) {
+			case *rsa.PublicKey:
+				valid = rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature) == nil
+			case *ecdsa.PublicKey:
+				valid = len(signature) == 64 &&
+					ecdsa.Verify(key, digest[:], new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:]))
+			}
+			if valid {
+				break
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unsupported token algorithm '%s'", header.Alg)
+	}
+	if !valid {
+		return nil, fmt.Errorf("invalid token signature")
+	}
+
+	var claims map[string]interface{}
+	data, err = base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("malformed token claims")
+	}
+	dec := json.NewDecoder(strings.NewReader(string(data)))
+	dec.UseNumber()
+	if err = dec.Decode(&claims); err != nil {
+		return nil, fmt.Errorf("malformed token claims")
+	}
+	exp, ok := claims["exp"].(json.Number)
+	if !ok {
+		return nil, fmt.Errorf("token without expiry")
+	}
+	if t, err := exp.Float64(); err != nil || now.After(time.Unix(int64(t), 0).Add(clockSkew)) {
+		return nil, fmt.Errorf("token expired")
+	}
+	if nbf, ok := claims["nbf"].(json.Number); ok {
+		if t, err := nbf.Float64(); err != nil || now.Add(clockSkew).Before(time.Unix(int64(t), 0)) {
+			return nil, fmt.Errorf("token not yet valid")
+		}
+	}
+	if a.audience != "" && !contains(claims["aud"], a.audience) {
+		return nil, fmt.Errorf("token audience is not '%s'", a.audience)
+	}
+	if a.issuer != "" && claims["iss"] != a.issuer {
+		return nil, fmt.Errorf("token issuer is not '%s'", a.issuer)
+	}
+	return claims, nil
+}
+
+// permits tells whether the claims hold the required claim values of the route group
+func (a *authenticator) permits(group string, claims map[string]interface{}) bool {
+	for claim, value := range a.claims[group] {
+		if !contains(claims[claim], value) {
+			return false
+		}
+	}
+	return true
+}
+
+// contains tells whether the claim value is the value, or has it as a JSON array member
+// or as a space-separated member (such as OAuth 2.0 'scope')
+func contains(claim interface{}, value string) bool {
+	switch v := claim.(type) {
+	case string:
+		for _, member := range strings.Fields(v) {
+			if member == value {
+				return true
+			}
+		}
+		return v == value
+	case []interface{}:
+		for _, member := range v {
+			if fmt.Sprintf("%v", member) == value {
+				return true
+			}
+		}
+	case json.Number:
+		return v.String() == value
+	case bool:
+		return fmt.Sprintf("%v", v) == value
+	}
+	return false
+}
+
+// authorize wraps the handle of a route of the group to require a valid bearer token with the claims of
+// the group, responding 401 Unauthorized or 403 Forbidden otherwise
+func (s *Server) authorize(group string, handle httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		a := s.optionsOf(r).auth
+		if a == nil {
+			handle(w, r, p)
+			return
+		}
+		header := r.Header.Get("Authorization")
+		if len(header) < 7 || !strings.EqualFold(header[:7], "Bearer ") {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="cimrdf"`)
+			writeProblem(w, newProblem(http.StatusUnauthorized, problemUnauthorized, "missing bearer token"))
+			return
+		}
+		claims, err := a.verify(strings.Trim(header[7:], " "), time.Now())
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="cimrdf", error="invalid_token", error_description="%s"`, err))
+			s.fail(w, r, newProblem(http.StatusUnauthorized, problemUnauthorized, "unauthorized request to '%s': %s", r.URL.Path, err))
+			return
+		}
+		if !a.permits(group, claims) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="cimrdf", error="insufficient_scope"`)
+			s.fail(w, r, newProblem(http.StatusForbidden, problemForbidden, "token of '%v' lacks the claims of '%s' requests", claims["sub"], group))
+			return
+		}
+		handle(w, r, p)
+	}
+}
@@ -0,0 +1,167 @@
+package main_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "sesam-cimrdf"
+)
+
+// NewToken returns a JWT of the claims, signed with HS256 by the secret, or with ES256 by the key
+func NewToken(claims map[string]interface{}, secret string, key *ecdsa.PrivateKey) string {
+	alg := "HS256"
+	if key != nil {
+		alg = "ES256"
+	}
+	header, _ := json.Marshal(map[string]string{"alg": alg, "typ": "JWT", "kid": "k1"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	var signature []byte
+	if key == nil {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(signed))
+		signature = mac.Sum(nil)
+	} else {
+		digest := sha256.Sum256([]byte(signed))
+		r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+		Expect(err).To(BeNil())
+		signature = append(Pad32(r.Bytes()), Pad32(s.Bytes())...)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// Pad32 left-pads big-endian bytes to 32 bytes, as of P-256 coordinates and signatures
+func Pad32(b []byte) []byte {
+	return append(make([]byte, 32-len(b)), b...)
+}
+
+var _ = Describe("Microservice JWT authorization", func() {
+
+	var (
+		server   *Server
+		response *httptest.ResponseRecorder
+		claims   map[string]interface{}
+	)
+
+	Post := func(path string, token string) {
+		request, _ := http.NewRequest("POST", path, strings.NewReader(`[]`))
+		if token != "" {
+			request.Header.Set("Authorization", "Bearer "+token)
+		}
+		server.ServeHTTP(response, request)
+	}
+
+	BeforeEach(func() {
+		response = httptest.NewRecorder()
+		claims = map[string]interface{}{"sub": "pipe", "exp": time.Now().Add(time.Hour).Unix(), "aud": []string{"cimrdf"}, "iss": "sesam", "scope": "uuid.mint"}
+	})
+
+	Describe("with a shared secret", func() {
+
+		BeforeEach(func() {
+			server = NewTestServer(Options{"log": ioutil.Discard, "seed": "ginkgo",
+				"jwt_secret": "s3cret", "audience": "cimrdf", "issuer": "sesam", "claims": "mint:scope=uuid.mint,convert:scope=cim.convert"})
+		})
+
+		It("accepts valid tokens", func() {
+			Post("/_id", NewToken(claims, "s3cret", nil))
+			Expect(response.Code).To(Equal(200))
+		})
+
+		It("requires a token", func() {
+			Post("/_id", "")
+			Expect(response.Code).To(Equal(401))
+			Expect(response.Header().Get("WWW-Authenticate")).To(HavePrefix("Bearer"))
+		})
+
+		It("rejects tokens of another secret", func() {
+			Post("/_id", NewToken(claims, "guess", nil))
+			Expect(response.Code).To(Equal(401))
+		})
+
+		It("rejects expired tokens", func() {
+			claims["exp"] = time.Now().Add(-time.Hour).Unix()
+			Post("/_id", NewToken(claims, "s3cret", nil))
+			Expect(response.Code).To(Equal(401))
+		})
+
+		It("rejects tokens of another audience or issuer", func() {
+			claims["aud"] = "other"
+			Post("/_id", NewToken(claims, "s3cret", nil))
+			Expect(response.Code).To(Equal(401))
+			response = httptest.NewRecorder()
+			claims["aud"], claims["iss"] = "cimrdf", "other"
+			Post("/_id", NewToken(claims, "s3cret", nil))
+			Expect(response.Code).To(Equal(401))
+		})
+
+		It("restricts routes by claims", func() {
+			Post("/convert", NewToken(claims, "s3cret", nil))
+			Expect(response.Code).To(Equal(403))
+			response = httptest.NewRecorder()
+			claims["scope"] = "uuid.mint cim.convert"
+			Post("/convert", NewToken(claims, "s3cret", nil))
+			Expect(response.Code).To(Equal(200))
+		})
+
+		It("leaves health unauthenticated", func() {
+			request, _ := http.NewRequest("GET", "/health", nil)
+			server.ServeHTTP(response, request)
+			Expect(response.Code).To(Equal(200))
+		})
+	})
+
+	Describe("with a JWKS", func() {
+
+		var (
+			dir string
+			key *ecdsa.PrivateKey
+		)
+
+		BeforeEach(func() {
+			key, _ = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+			x, y := Pad32(key.X.Bytes()), Pad32(key.Y.Bytes())
+			jwks, _ := json.Marshal(map[string]interface{}{"keys": []map[string]string{{
+				"kty": "EC", "crv": "P-256", "kid": "k1", "use": "sig",
+				"x": base64.RawURLEncoding.EncodeToString(x), "y": base64.RawURLEncoding.EncodeToString(y),
+			}}})
+			dir, _ = ioutil.TempDir("", "jwks")
+			ioutil.WriteFile(filepath.Join(dir, "jwks.json"), jwks, 0644)
+			server = NewTestServer(Options{"log": ioutil.Discard, "seed": "ginkgo", "jwks": filepath.Join(dir, "jwks.json")})
+		})
+		AfterEach(func() {
+			os.RemoveAll(dir)
+		})
+
+		It("accepts ES256 tokens of its keys", func() {
+			Post("/_id", NewToken(claims, "", key))
+			Expect(response.Code).To(Equal(200))
+		})
+
+		It("rejects ES256 tokens of other keys", func() {
+			other, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+			Post("/_id", NewToken(claims, "", other))
+			Expect(response.Code).To(Equal(401))
+		})
+
+		It("rejects HS256 tokens without a secret", func() {
+			Post("/_id", NewToken(claims, "", nil))
+			Expect(response.Code).To(Equal(401))
+		})
+	})
+})
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+)
+
+// batchReader reads the entities or models of a batch one by one, either as the elements of a JSON array or as the
+// lines of NDJSON (newline-delimited JSON, skipping blank lines)
+type batchReader struct {
+	ndjson bool
+	dec    *json.Decoder
+	lines  *bufio.Reader
+	line   []byte
+	err    error // of reading the lines, other than io.EOF
+}
+
+func newBatchReader(r io.Reader, ndjson bool) *batchReader {
+	if ndjson {
+		return &batchReader{ndjson: true, lines: bufio.NewReader(r)}
+	}
+	return &batchReader{dec: json.NewDecoder(r)}
+}
+
+// open reads the opening bracket '[' of a JSON array
+func (br *batchReader) open() *problem {
+	if br.ndjson {
+		return nil
+	}
+	t, err := br.dec.Token()
+	if err == io.EOF {
+		return newProblem(http.StatusBadRequest, problemMissingArray, "missing JSON array")
+	}
+	if err != nil {
+		return newProblem(http.StatusBadRequest, problemMalformedRequest, "%s", err)
+	}
+	if t != json.Delim('[') {
+		return newProblem(http.StatusBadRequest, problemMissingArray, "expected JSON array opening bracket '[', but found '%v'", t)
+	}
+	return nil
+}
+
+// more reports whether there is another element to decode
+func (br *batchReader) more() bool {
+	if !br.ndjson {
+		return br.dec.More()
+	}
+	for br.err == nil {
+		line, err := br.lines.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			br.err = err
+			return false
+		}
+		if br.line = bytes.TrimSpace(line); len(br.line) != 0 {
+			return true
+		}
+		if err == io.EOF {
+			return false
+		}
+	}
+	return false
+}
+
+// decode decodes the next element
+func (br *batchReader) decode(v interface{}) error {
+	if !br.ndjson {
+		return br.dec.Decode(v)
+	}
+	return json.Unmarshal(br.line, v)
+}
+
+// recoverable reports whether reading can go on after the error of decode, for every NDJSON line on its own,
+// but in a JSON array only past elements of the wrong type
+func (br *batchReader) recoverable(err error) bool {
+	_, wrongType := err.(*json.UnmarshalTypeError)
+	return br.ndjson || wrongType
+}
+
+// close reads the closing bracket ']' of a JSON array, or tells the error of reading the lines
+func (br *batchReader) close() *problem {
+	if br.ndjson {
+		if br.err != nil {
+			return newProblem(http.StatusBadRequest, problemMalformedRequest, "error reading NDJSON: %s", br.err)
+		}
+		return nil
+	}
+	if _, err := br.dec.Token(); err != nil {
+		return newProblem(http.StatusBadRequest, problemMalformedRequest, "expected JSON array closing bracket ']', but got error: %s", err)
+	}
+	return nil
+}
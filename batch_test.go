@@ -0,0 +1,120 @@
+package main_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "sesam-cimrdf"
+)
+
+// NewNDJSON returns the objects of the NDJSON lines
+func NewNDJSON(data string) []map[string]interface{} {
+	var objects []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(data), "\n") {
+		var object map[string]interface{}
+		Expect(json.Unmarshal([]byte(line), &object)).To(Succeed(), line)
+		objects = append(objects, object)
+	}
+	return objects
+}
+
+var _ = Describe("Microservice NDJSON batches", func() {
+
+	var server *Server
+
+	Post := func(url string, body string, contentType string, accept string) *httptest.ResponseRecorder {
+		response := httptest.NewRecorder()
+		request, _ := http.NewRequest("POST", url, strings.NewReader(body))
+		request.Header.Set("Content-Type", contentType)
+		request.Header.Set("Accept", accept)
+		server.ServeHTTP(response, request)
+		return response
+	}
+
+	BeforeEach(func() {
+		server = NewTestServer(Options{"log": ioutil.Discard, "seed": "ginkgo"})
+	})
+
+	It("mints NDJSON lines to NDJSON lines", func() {
+		response := Post("/_id", "{\"_id\": \"a\"}\n\n{\"_id\": \"b\"}", "application/x-ndjson", "application/x-ndjson")
+		Expect(response.Code).To(Equal(200))
+		Expect(response.Header().Get("Content-Type")).To(Equal("application/x-ndjson"))
+		entities := NewNDJSON(response.Body.String())
+		Expect(entities).To(HaveLen(2))
+
+		response = Post("/_id", `[{"_id": "a"}, {"_id": "b"}]`, "application/json", "application/json")
+		var expected []map[string]interface{}
+		Expect(json.Unmarshal(response.Body.Bytes(), &expected)).To(Succeed())
+		Expect(entities).To(Equal(expected))
+	})
+
+	It("skips malformed lines in partial failure mode", func() {
+		response := Post("/_id?partial=true", "{\"_id\": \"a\"}\n{\"_id\": \n{\"_id\": \"b\"}\n", "application/x-ndjson", "application/x-ndjson")
+		Expect(response.Code).To(Equal(200))
+		entities := NewNDJSON(response.Body.String())
+		Expect(entities).To(HaveLen(3))
+		Expect(entities[1]["_mint_error"]).To(HaveKeyWithValue("code", "malformed_request"))
+		Expect(entities[2]["_id"]).To(HaveLen(36))
+
+		response = Post("/_id", "{\"_id\": \"a\"}\n{\"_id\": \n", "application/x-ndjson", "application/x-ndjson")
+		Expect(NewNDJSON(response.Body.String())).To(HaveLen(1))
+		Expect(response.Result().Trailer.Get("X-Problem")).To(ContainSubstring("malformed_request"))
+	})
+
+	It("converts NDJSON lines", func() {
+		response := Post("/convert", "{\"_id\": \"m0\", \"cim:Model.all\": []}\n{\"_id\": \"m1\", \"cim:Model.all\": []}\n", "application/x-ndjson", "application/x-ndjson")
+		Expect(response.Code).To(Equal(200))
+		models := NewNDJSON(response.Body.String())
+		Expect(models).To(HaveLen(2))
+		Expect(models[1]).To(HaveKeyWithValue("_id", "m1"))
+	})
+
+	It("converts NDJSON lines before the request body is complete", func() {
+		ts := httptest.NewServer(server)
+		defer ts.Close()
+		pr, pw := io.Pipe()
+		go pw.Write([]byte("{\"_id\": \"m0\", \"cim:Model.all\": []}\n"))
+		request, _ := http.NewRequest("POST", ts.URL+"/convert", pr)
+		request.Header.Set("Content-Type", "application/x-ndjson")
+		request.Header.Set("Accept", "application/x-ndjson")
+		resp, err := http.DefaultClient.Do(request)
+		Expect(err).To(BeNil())
+		defer resp.Body.Close()
+
+		dec := json.NewDecoder(resp.Body)
+		var first map[string]interface{}
+		Expect(dec.Decode(&first)).To(Succeed())
+		Expect(first).To(HaveKeyWithValue("_id", "m0"))
+
+		go func() {
+			pw.Write([]byte("{\"_id\": \"m1\", \"cim:Model.all\": []}\n"))
+			pw.Close()
+		}()
+		var second map[string]interface{}
+		Expect(dec.Decode(&second)).To(Succeed())
+		Expect(second).To(HaveKeyWithValue("_id", "m1"))
+	})
+
+	It("converts NDJSON lines on its own", func() {
+		var buf bytes.Buffer
+		rw := NewInputOutput("{\"_id\": \"m0\", \"json\": []}\n{\"_id\": \"m1\", \"json\": []}\n", "", &buf)
+		Expect(Convert(rw, &Options{"json": "json", "ndjson": true}, 0)).To(Succeed())
+		models := NewNDJSON(buf.String())
+		Expect(models).To(HaveLen(2))
+		Expect(models[0]).To(HaveKey("xml"))
+
+		buf.Reset()
+		rw = NewInputOutput("{\"_id\": \"m0\", \"json\": []}\n5\n", "", &buf)
+		err := Convert(rw, &Options{"json": "json", "ndjson": true}, 0)
+		Expect(err).NotTo(BeNil())
+		Expect(err.Error()).To(ContainSubstring("expected JSON object"))
+	})
+})
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultConfigFile is the optional JSON configuration file included into the Docker image
+const defaultConfigFile string = "/.config.json"
+
+// kinds of configuration settings, deciding how values are validated and how environment variables
+// and command line flags are parsed
+const (
+	settingString = iota
+	settingBool
+	settingDuration
+	settingLevel
+	settingList   // comma-separated string, or JSON array of strings
+	settingObject // JSON object, also as text of environment variables
+	settingAny    // validated by the option itself
+)
+
+// configSetting is an option of the microservice, given in the JSON config file by its key,
+// or by an environment variable or command line flag (without either when empty)
+type configSetting struct {
+	key   string
+	env   string
+	flag  string
+	kind  int
+	usage string
+}
+
+var configSettings = []configSetting{
+	{"seed", "UUID_SEED", "seed", settingString, "seed `string` of the UUID namespace of minting"},
+	{"uuid", "UUID", "uuid", settingString, "UUID namespace of minting, instead of a seed"},
+	{"seeds", "UUID_SEEDS", "", settingObject, "JSON object of named seed strings, picked per minting request"},
+	{"seed_version", "UUID_SEED_VERSION", "seed-version", settingString, "version name of the seed of minting"},
+	{"seed_versions", "UUID_SEED_VERSIONS", "", settingObject, "JSON object of earlier seed strings by version name"},
+	{"level", "LOG_LEVEL", "level", settingLevel, "log level, one of " + strings.Join(logLevel, ", ")},
+	{"routes", "ROUTES", "routes", settingList, "comma-separated route groups enabled, of " + strings.Join(routeGroups, ", ")},
+	{"names", "NAMESPACES", "", settingObject, "JSON object of namespace prefixes and IRIs of conversion"},
+	{"json", "", "json", settingString, "model field of the JSON array of CIM entities"},
+	{"xml", "", "xml", settingString, "model field of the converted RDF/XML"},
+	{"ns", "", "ns", settingString, "model field, or option, of the map of namespaces"},
+	{"digest", "", "digest", settingString, "model field of the digest of converted models"},
+	{"version", "", "version", settingBool, "set md:Model.version to the digest"},
+	{"report", "", "report", settingString, "model field of the SHACL validation report"},
+	{"difference", "", "difference", settingBool, "convert deleted entities to difference models"},
+	{"filter", "", "", settingObject, "filter of converted entities"},
+	{"ndjson", "", "ndjson", settingBool, "read and write NDJSON instead of a JSON array when converting on its own"},
+	{"partial", "PARTIAL_FAILURES", "partial", settingBool, "mint entities independently, attaching failures as '_mint_error'"},
+	{"keep", "KEEP_FIELDS", "keep", settingList, "comma-separated Sesam internal fields kept in output"},
+	{"strip", "STRIP_FIELDS", "strip", settingList, "comma-separated Sesam internal fields stripped from output"},
+	{"lookup", "LOOKUP_FILE", "lookup", settingString, "`file` of the reverse lookup store of minted UUIDs"},
+	{"lookup_retention", "LOOKUP_RETENTION", "lookup-retention", settingDuration, "duration of keeping minted UUIDs in the lookup store, 0 for ever"},
+	{"entities", "ENTITIES_FILE", "entities", settingString, "`file` of the journal of minted entities and converted models served by GET /entities"},
+	{"entities_retention", "ENTITIES_RETENTION", "entities-retention", settingDuration, "duration of keeping minted entities and converted models in the journal, 0 for ever"},
+	{"pipes", "PIPES", "", settingObject, "JSON object of options overridden per Sesam pipe_id"},
+	{"assembly_timeout", "ASSEMBLY_TIMEOUT", "assembly-timeout", settingDuration, "maximum duration of holding the models of an unfinished pipe run, 0 for ever"},
+	{"mapping", "MAPPING_FILE", "mapping", settingString, "JSON `file` mapping source keys to CIM properties"},
+	{"shapes", "SHACL_SHAPES", "shapes", settingList, "comma-separated SHACL shape files or directories"},
+	{"max_request_size", "MAX_REQUEST_SIZE", "max-request-size", settingAny, "maximum request size, e.g. 32MB or /convert=256MB,*=32MB"},
+	{"max_concurrent", "MAX_CONCURRENT_REQUESTS", "max-concurrent", settingAny, "maximum concurrent requests per route or route group, e.g. 8 or /uuid=4,convert=2,*=8"},
+	{"max_inflight_bytes", "MAX_INFLIGHT_BYTES", "max-inflight-bytes", settingAny, "maximum total request body size in flight, e.g. 1GB"},
+	{"max_queue", "MAX_QUEUE", "max-queue", settingAny, "maximum requests waiting for their turn, beyond which 429 is returned"},
+	{"queue_timeout", "QUEUE_TIMEOUT", "queue-timeout", settingDuration, "maximum duration of waiting for a turn, beyond which 503 is returned"},
+	{"jwt_secret", "JWT_SECRET", "", settingString, "HS256 secret of JWT bearer tokens"},
+	{"jwks", "JWT_JWKS_FILE", "jwks", settingString, "JWKS `file` of RS256 and ES256 keys of JWT bearer tokens"},
+	{"audience", "JWT_AUDIENCE", "jwt-audience", settingString, "required JWT audience"},
+	{"issuer", "JWT_ISSUER", "jwt-issuer", settingString, "required JWT issuer"},
+	{"claims", "JWT_CLAIMS", "jwt-claims", settingAny, "required JWT claims per route group, e.g. mint:scope=uuid.mint"},
+	{"listen", "LISTEN_ADDRESS", "listen", settingString, "listen address `host:port`"},
+	{"read_timeout", "READ_TIMEOUT", "read-timeout", settingDuration, "maximum duration of reading a request, 0 for none"},
+	{"write_timeout", "WRITE_TIMEOUT", "write-timeout", settingDuration, "maximum duration of writing a response, 0 for none"},
+	{"idle_timeout", "IDLE_TIMEOUT", "idle-timeout", settingDuration, "maximum duration of idle keep-alive connections"},
+	{"shutdown_timeout", "SHUTDOWN_TIMEOUT", "shutdown-timeout", settingDuration, "maximum duration of finishing requests on shutdown"},
+	{"tls_cert", "TLS_CERT_FILE", "tls-cert", settingString, "PEM certificate `file` of HTTPS"},
+	{"tls_key", "TLS_KEY_FILE", "tls-key", settingString, "PEM private key `file` of HTTPS"},
+	{"tls_client_ca", "TLS_CLIENT_CA_FILE", "tls-client-ca", settingString, "PEM CA bundle `file` requiring client certificates"},
+}
+
+// settingValue is a command line flag remembering whether it was given
+type settingValue struct {
+	value   string
+	set     bool
+	boolean bool
+}
+
+func (v *settingValue) String() string { return v.value }
+
+func (v *settingValue) Set(s string) error {
+	v.value, v.set = s, true
+	return nil
+}
+
+func (v *settingValue) IsBoolFlag() bool { return v.boolean }
+
+// LoadConfig returns the options of the JSON config file, overridden by environment variables, overridden
+// in turn by the command line flags of args. The config file is given by flag -config or environment
+// CONFIG_FILE, or else is the optional /.config.json. The options are validated.
+func LoadConfig(args []string) (Options, error) {
+	opt, _, err := loadConfig(args)
+	return opt, err
+}
+
+// loadConfig returns the options of LoadConfig and the path of the configuration file, if any
+func loadConfig(args []string) (Options, string, error) {
+	flags := flag.NewFlagSet("sesam-cimrdf", flag.ContinueOnError)
+	path := flags.String("config", "", "JSON configuration `file` (CONFIG_FILE, default "+defaultConfigFile+" when present)")
+	values := make(map[string]*settingValue, len(configSettings))
+	for _, setting := range configSettings {
+		if len(setting.flag) == 0 {
+			continue
+		}
+		v := &settingValue{boolean: setting.kind == settingBool}
+		usage := setting.usage
+		if len(setting.env) != 0 {
+			usage += " (" + setting.env + ")"
+		}
+		flags.Var(v, setting.flag, usage)
+		values[setting.key] = v
+	}
+	if err := flags.Parse(args); err != nil {
+		return nil, "", err
+	}
+
+	opt := Options{}
+	file := *path
+	if len(file) == 0 {
+		file = strings.Trim(os.Getenv("CONFIG_FILE"), " ")
+	}
+	if len(file) == 0 {
+		if _, err := os.Stat(defaultConfigFile); err == nil {
+			file = defaultConfigFile
+		}
+	}
+	if len(file) != 0 {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, "", fmt.Errorf("error loading configuration: %s", err)
+		}
+		if len(strings.TrimSpace(string(data))) != 0 {
+			if err = json.Unmarshal(data, &opt); err != nil {
+				return nil, "", fmt.Errorf("expected configuration '%s' to be a JSON object, but got error: %s", file, err)
+			}
+		}
+	}
+
+	for _, setting := range configSettings {
+		if len(setting.env) == 0 {
+			continue
+		}
+		if val := strings.Trim(os.Getenv(setting.env), " "); len(val) != 0 {
+			if err := opt.setText(setting, val); err != nil {
+				return nil, "", fmt.Errorf("expected environment '%s' %s", setting.env, err)
+			}
+		}
+	}
+	for _, setting := range configSettings {
+		if v, exist := values[setting.key]; exist && v.set {
+			if err := opt.setText(setting, v.value); err != nil {
+				return nil, "", fmt.Errorf("expected flag '-%s' %s", setting.flag, err)
+			}
+		}
+	}
+	if err := ValidateConfig(opt); err != nil {
+		return nil, "", err
+	}
+	return opt, file, nil
+}
+
+// setText sets the option of the setting from the text of an environment variable or command line flag
+func (opt Options) setText(setting configSetting, text string) error {
+	switch setting.kind {
+	case settingBool:
+		b, err := strconv.ParseBool(text)
+		if err != nil {
+			return fmt.Errorf("to be a boolean, but got '%s'", text)
+		}
+		opt[setting.key] = b
+	case settingObject:
+		var object map[string]interface{}
+		if err := json.Unmarshal([]byte(text), &object); err != nil {
+			return fmt.Errorf("to be a JSON object, but got error: %s", err)
+		}
+		opt[setting.key] = object
+	default:
+		opt[setting.key] = text
+	}
+	return nil
+}
+
+// ValidateConfig returns an error listing the unknown options and the options with invalid values
+func ValidateConfig(opt Options) error {
+	settings := make(map[string]configSetting, len(configSettings))
+	for _, setting := range configSettings {
+		settings[setting.key] = setting
+	}
+	var problems []string
+	for key, val := range opt {
+		setting, known := settings[key]
+		if !known {
+			problems = append(problems, fmt.Sprintf("unknown option '%s'", key))
+			continue
+		}
+		if err := setting.validate(val); err != nil {
+			problems = append(problems, fmt.Sprintf("option '%s' %s", key, err))
+		}
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	sort.Strings(problems)
+	return fmt.Errorf("invalid configuration: %s", strings.Join(problems, "; "))
+}
+
+func (setting configSetting) validate(val interface{}) error {
+	switch setting.kind {
+	case settingString:
+		if _, ok := val.(string); !ok {
+			return fmt.Errorf("expected to be a string, but got %T", val)
+		}
+	case settingBool:
+		if _, ok := val.(bool); !ok {
+			return fmt.Errorf("expected to be a boolean, but got %T", val)
+		}
+	case settingDuration:
+		if _, err := durationOf(val); err != nil {
+			return err
+		}
+	case settingLevel:
+		name, ok := val.(string)
+		if !ok || levelOf(name, -1) < 0 {
+			return fmt.Errorf("expected to be one of %s, but got '%v'", strings.Join(logLevel, ", "), val)
+		}
+	case settingList:
+		list, err := fieldList(Options{setting.key: val}, setting.key)
+		if err != nil {
+			return err
+		}
+		if setting.key == "routes" {
+			for _, group := range list {
+				known := false
+				for _, g := range routeGroups {
+					known = known || g == group
+				}
+				if !known {
+					return fmt.Errorf("expected route groups of %s, but got '%s'", strings.Join(routeGroups, ", "), group)
+				}
+			}
+		}
+	case settingObject:
+		object, ok := val.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("expected to be a JSON object, but got %T", val)
+		}
+		if setting.key == "names" {
+			for prefix, iri := range object {
+				if _, ok := iri.(string); !ok {
+					return fmt.Errorf("expected the IRI of namespace '%s' to be a string, but got %T", prefix, iri)
+				}
+			}
+		}
+		if setting.key == "seeds" || setting.key == "seed_versions" {
+			for name, seed := range object {
+				if _, ok := seed.(string); !ok {
+					return fmt.Errorf("expected the seed of '%s' to be a string, but got %T", name, seed)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// durationOf returns the duration of a string such as "30s", or of a number of seconds
+func durationOf(val interface{}) (time.Duration, error) {
+	switch v := val.(type) {
+	case string:
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return 0, fmt.Errorf("expected a duration such as '30s', but got '%s'", v)
+		}
+		return d, nil
+	case float64:
+		return time.Duration(v * float64(time.Second)), nil
+	case int:
+		return time.Duration(v) * time.Second, nil
+	case time.Duration:
+		return v, nil
+	}
+	return 0, fmt.Errorf("expected a duration such as '30s', but got %T", val)
+}
+
+// namesOf returns the namespaces of option 'names' as the map of strings expected by Convert
+func namesOf(opt Options) map[string]string {
+	switch names := opt["names"].(type) {
+	case map[string]string:
+		return names
+	case map[string]interface{}:
+		ns := make(map[string]string, len(names))
+		for prefix, iri := range names {
+			ns[prefix] = fmt.Sprintf("%v", iri)
+		}
+		return ns
+	}
+	return defaultNamespaces
+}
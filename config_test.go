@@ -0,0 +1,121 @@
+package main_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "sesam-cimrdf"
+)
+
+var _ = Describe("Microservice configuration", func() {
+
+	var (
+		dir  string
+		file string
+	)
+
+	BeforeEach(func() {
+		dir, _ = ioutil.TempDir("", "config")
+		file = filepath.Join(dir, "config.json")
+		ioutil.WriteFile(file, []byte(`{
+			"seed": "from-file",
+			"level": "WARN",
+			"routes": ["mint", "validate"],
+			"names": {"cim": "http://iec.ch/TC57/CIM100#"},
+			"read_timeout": 10
+		}`), 0644)
+	})
+	AfterEach(func() {
+		os.Unsetenv("UUID_SEED")
+		os.Unsetenv("LOG_LEVEL")
+		os.RemoveAll(dir)
+	})
+
+	It("loads the config file", func() {
+		opt, err := LoadConfig([]string{"-config", file})
+		Expect(err).To(BeNil())
+		Expect(opt).To(HaveKeyWithValue("seed", "from-file"))
+		Expect(opt).To(HaveKeyWithValue("level", "WARN"))
+		Expect(opt).To(HaveKey("names"))
+	})
+
+	It("overrides the file by environment, and environment by flags", func() {
+		os.Setenv("UUID_SEED", "from-env")
+		os.Setenv("LOG_LEVEL", "DEBUG")
+		opt, err := LoadConfig([]string{"-config", file, "-level", "info", "-difference"})
+		Expect(err).To(BeNil())
+		Expect(opt).To(HaveKeyWithValue("seed", "from-env"))
+		Expect(opt).To(HaveKeyWithValue("level", "info"))
+		Expect(opt).To(HaveKeyWithValue("difference", true))
+	})
+
+	It("takes the config file from the environment", func() {
+		os.Setenv("CONFIG_FILE", file)
+		defer os.Unsetenv("CONFIG_FILE")
+		opt, err := LoadConfig(nil)
+		Expect(err).To(BeNil())
+		Expect(opt).To(HaveKeyWithValue("seed", "from-file"))
+	})
+
+	It("rejects unknown and invalid options", func() {
+		ioutil.WriteFile(file, []byte(`{"sed": "typo", "level": "LOUD", "routes": "mint,print", "write_timeout": "soon"}`), 0644)
+		_, err := LoadConfig([]string{"-config", file})
+		Expect(err).NotTo(BeNil())
+		Expect(err.Error()).To(ContainSubstring("unknown option 'sed'"))
+		Expect(err.Error()).To(ContainSubstring("option 'level'"))
+		Expect(err.Error()).To(ContainSubstring("option 'routes'"))
+		Expect(err.Error()).To(ContainSubstring("option 'write_timeout'"))
+	})
+
+	It("loads the shapes listed in the config file", func() {
+		shapes := filepath.Join(dir, "shapes")
+		os.Mkdir(shapes, 0755)
+		ioutil.WriteFile(filepath.Join(shapes, "substation.ttl"), []byte(shapesTurtle), 0644)
+		ioutil.WriteFile(filepath.Join(dir, "region.ttl"), []byte(shapesTurtle), 0644)
+		for _, list := range []string{`[" ` + shapes + ` ", "` + filepath.Join(dir, "region.ttl") + `"]`, `"` + shapes + ` , ` + filepath.Join(dir, "region.ttl") + `"`} {
+			ioutil.WriteFile(file, []byte(`{"seed": "from-file", "shapes": `+list+`}`), 0644)
+			opt, err := LoadConfig([]string{"-config", file})
+			Expect(err).To(BeNil())
+			opt["log"] = ioutil.Discard
+			_, err = NewOptions(&opt)
+			Expect(err).To(BeNil())
+		}
+	})
+
+	It("rejects malformed config files", func() {
+		ioutil.WriteFile(file, []byte(`{"seed": `), 0644)
+		_, err := LoadConfig([]string{"-config", file})
+		Expect(err).NotTo(BeNil())
+	})
+
+	It("accepts an empty config file", func() {
+		ioutil.WriteFile(file, []byte(""), 0644)
+		opt, err := LoadConfig([]string{"-config", file})
+		Expect(err).To(BeNil())
+		Expect(opt).To(BeEmpty())
+	})
+
+	It("enables only the configured routes", func() {
+		opt, err := LoadConfig([]string{"-config", file})
+		Expect(err).To(BeNil())
+		opt["log"] = ioutil.Discard
+		server := NewTestServer(opt)
+
+		response := httptest.NewRecorder()
+		request, _ := http.NewRequest("POST", "/convert", strings.NewReader(`[]`))
+		server.ServeHTTP(response, request)
+		Expect(response.Code).To(Equal(404))
+
+		response = httptest.NewRecorder()
+		request, _ = http.NewRequest("POST", "/_id", strings.NewReader(`[]`))
+		server.ServeHTTP(response, request)
+		Expect(response.Code).To(Equal(200))
+	})
+})
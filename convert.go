@@ -5,10 +5,9 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
-	"os"
-
-	// 	"net/http"
+	"net/http"
 	"strings"
+	"time"
 
 	"github.com/google/uuid"
 	// 	"github.com/julienschmidt/httprouter"
@@ -18,305 +17,308 @@ const (
 	headerXML string = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>`
 	headerRDF string = `<rdf:RDF xmlns:cim="http://iec.ch/TC57/2017/CIM-schema-cim100#" xmlns:md="http://iec.ch/TC57/61970-552/ModelDescription/1#" xmlns:nek="http://nek.no/NK57/CIM/CIM100-Extension/1/0#" xmlns:entsoe="http://entsoe.eu/CIM/SchemaExtension/3/2#" xmlns:iev="http://iec.ch/TC1/60050-6xx/Electropedia/1#" xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">`
 	footerRDF string = `</rdf:RDF>`
-	lenURN    int    = 45 // length of string "urn:uuid:00000000-0000-0000-0000-000000000000"
-	posUUID   int    = 9  // length of string "urn:uuid:"
+	// headerDifferenceRDF is headerRDF also declaring the namespace of IEC 61970-552 difference models
+	headerDifferenceRDF string = `<rdf:RDF xmlns:cim="http://iec.ch/TC57/2017/CIM-schema-cim100#" xmlns:md="http://iec.ch/TC57/61970-552/ModelDescription/1#" xmlns:dm="http://iec.ch/TC57/61970-552/DifferenceModel/1#" xmlns:nek="http://nek.no/NK57/CIM/CIM100-Extension/1/0#" xmlns:entsoe="http://entsoe.eu/CIM/SchemaExtension/3/2#" xmlns:iev="http://iec.ch/TC1/60050-6xx/Electropedia/1#" xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">`
+	lenURN              int    = 45 // length of string "urn:uuid:00000000-0000-0000-0000-000000000000"
+	posUUID             int    = 9  // length of string "urn:uuid:"
 )
 
 var (
 	skipKeys map[string]bool = map[string]bool{"rdf:type": true}
 )
 
+// modelID returns the '_id' of a model, if any
+func modelID(model map[string]json.RawMessage) interface{} {
+	var id interface{}
+	if data, exist := model["_id"]; exist {
+		json.Unmarshal(data, &id)
+	}
+	return id
+}
+
 // Convert transforms JSON to CIM RDF/XML
 // func Convert(dec *json.Decoder, w *bufio.Writer, cfg *Options, sz int) error {
 func Convert(rw *bufio.ReadWriter, config *Options, sz int) error {
 
-	szDefault := 3 * 1024 * 1024 // 3MB
-	if sz < szDefault {
-		sz = szDefault
-	}
-	result := bytes.NewBuffer(make([]byte, sz)) // this will actually be the model XML-string; needs a Reset() because it is filled with 0x00 bytes
-	result.Reset()
-	defer func() {
-		result.Reset() // empty after use as a precaution
-	}()
-
-	batch := json.NewDecoder(*rw)
-	var err error
-	t, err := batch.Token() // read opening bracket '['
+	c, err := newConverter(*config, sz)
 	if err != nil {
-		return fmt.Errorf("%s", err)
+		return err
 	}
-	if t.(json.Delim) != '[' {
-		return fmt.Errorf("expected JSON array opening bracket '[', but found '%s'", t)
+	defer c.close()
+
+	batch := newBatchReader(rw, c.ndjson)
+	if p := batch.open(); p != nil {
+		return p
 	}
 
-	if _, err = rw.WriteRune('['); err != nil { // for the outer batch
-		return fmt.Errorf("error writing response: %s", err)
+	if !c.ndjson {
+		if _, err = rw.WriteRune('['); err != nil { // for the outer batch
+			return fmt.Errorf("error writing response: %s", err)
+		}
 	}
 
-	cfg := *config
-	if jsonField, exist := cfg["json"]; exist {
+	if c.enabled {
 
-		// nswarn := false
 		total := 0
-		for batch.More() {
+		for batch.more() {
 			var model map[string]json.RawMessage
-			if err := batch.Decode(&model); err != nil {
-				if strings.Contains(err.Error(), "map[string]interface") {
-					return fmt.Errorf("expected JSON object inside array, but got error instead")
+			if err := batch.decode(&model); err != nil {
+				if _, ok := err.(*json.UnmarshalTypeError); ok {
+					return newProblem(http.StatusBadRequest, problemNotAnObject, "expected JSON object, but got error instead").at(total, nil)
 				}
-				return fmt.Errorf("expected JSON object inside array, but got error: %s", err)
+				return newProblem(http.StatusBadRequest, problemMalformedRequest, "expected JSON object, but got error: %s", err).at(total, nil)
 			}
 
-			// tmp, _ := json.Marshal(model)
-			// fmt.Printf("model: %v\n", string(tmp))
+			strictModel, _, err := c.convert(model)
+			if err != nil {
+				return newProblem(http.StatusBadRequest, problemConversion, "%s", err).at(total, modelID(model))
+			}
 
-			xField := "xml"
-			if val, exist := cfg["xml"]; exist {
-				xField = fmt.Sprintf("%v", val)
+			// TODO: make a testing-only flag here to make model not possible to marshal, for testing HTTP 503 below
+			var data []byte
+			if total != 0 && !c.ndjson {
+				if _, err = rw.WriteRune(','); err != nil { // for the outer batch
+					return fmt.Errorf("error writing response: %s", err)
+				}
+			}
+			// TODO: make another testing-only flag here to make strictModel not possible to marshal, for testing HTTP 503 below
+			if data, err = json.Marshal(strictModel); err != nil {
+				return fmt.Errorf("%s", err)
 			}
-			// fmt.Printf("xField: %s\n", xField)
 
-			nsField := "ns"
-			if val, exist := cfg["ns"]; exist {
-				nsField = fmt.Sprintf("%v", val)
+			if c.ndjson {
+				data = append(data, '\n')
+			}
+			if _, err = rw.Write(data); err != nil { // for the outer batch
+				return fmt.Errorf("error writing response: %s", err)
 			}
+			rw.Flush()
+			total++
+		}
+	}
+	rw.Flush()
 
-			var ns map[string]string
-			nField := fmt.Sprintf("%v", nsField)
-			if val, exist := model[nField]; exist {
-				if err = json.Unmarshal(val, &ns); err != nil {
-					return fmt.Errorf("expected the map of namespaces '%s' to be a JSON object with string values, but got error: %s", nField, err)
-				}
-			} else if val, exist := cfg[nField]; exist {
+	if p := batch.close(); p != nil {
+		return p
+	}
 
-				switch nsValue := val.(type) {
-				case map[string]string:
-					ns = nsValue
-				default:
-				}
+	// TODO: test-case with a failing rw-ReadWriter (simulating client peer closed connection etc) returning error for testing HTTP 503 below
+	if c.ndjson {
+		return nil
+	}
+	if _, err = rw.WriteRune(']'); err != nil { // for the outer batch
+		return fmt.Errorf("error writing response: %s", err)
+	}
+	return nil
+}
 
-			}
+// converter holds the conversion options of Convert
+type converter struct {
+	cfg         Options
+	enabled     bool   // the field holding the JSON array of CIM entities of a model is configured
+	jField      string // model field of the JSON array of CIM entities
+	xField      string // model field of the resulting RDF/XML
+	nField      string // model field, or option, of the map of namespaces
+	mapping     Mapping
+	filter      *Filter
+	internal    internalKeys
+	difference  bool
+	ndjson      bool // Convert reads and writes NDJSON lines instead of a JSON array
+	digestField string
+	versioning  bool
+	shapes      *Shapes
+	reportField string
+	log         *logger
+	result      *bytes.Buffer
+}
 
-			strictModel := make(map[string]interface{}, len(model))
-			jField := fmt.Sprintf("%v", jsonField)
-			if val, exist := model[jField]; exist {
+// newConverter returns a converter of the options, with a result buffer of at least sz bytes
+func newConverter(cfg Options, sz int) (*converter, error) {
+	c := &converter{cfg: cfg, xField: "xml", nField: "ns", reportField: "report", log: loggerOf(cfg)}
+	var err error
+	if c.mapping, err = mappingOf(cfg); err != nil {
+		return nil, err
+	}
+	if c.filter, err = filterOf(cfg); err != nil {
+		return nil, err
+	}
+	if c.internal, err = internalKeysOf(cfg); err != nil {
+		return nil, err
+	}
+	if c.shapes, err = shapesOf(cfg); err != nil {
+		return nil, err
+	}
+	if val, exist := cfg["json"]; exist {
+		c.enabled = true
+		c.jField = fmt.Sprintf("%v", val)
+	}
+	if val, exist := cfg["xml"]; exist {
+		c.xField = fmt.Sprintf("%v", val)
+	}
+	if val, exist := cfg["ns"]; exist {
+		c.nField = fmt.Sprintf("%v", val)
+	}
+	if val, exist := cfg["difference"]; exist {
+		c.difference = fmt.Sprintf("%v", val) == "true"
+	}
+	if val, exist := cfg["ndjson"]; exist {
+		c.ndjson = fmt.Sprintf("%v", val) == "true"
+	}
+	if val, exist := cfg["digest"]; exist && val != nil {
+		c.digestField = fmt.Sprintf("%v", val)
+	}
+	if val, exist := cfg["version"]; exist {
+		c.versioning = fmt.Sprintf("%v", val) == "true"
+	}
+	if val, exist := cfg["report"]; exist {
+		c.reportField = fmt.Sprintf("%v", val)
+	}
 
-				//
+	szDefault := 3 * 1024 * 1024 // 3MB, beyond which the buffer grows as models need, whatever the size of the input
+	if sz <= 0 || sz > szDefault {
+		sz = szDefault
+	}
+	c.result = bytes.NewBuffer(make([]byte, sz)) // this will actually be the model XML-string; needs a Reset() because it is filled with 0x00 bytes
+	c.result.Reset()
+	return c, nil
+}
 
-				// result.WriteString(fmt.Sprintf("\"%s\":", xField))
+// close empties the result buffer after use as a precaution
+func (c *converter) close() {
+	c.result.Reset()
+}
 
-				dec := json.NewDecoder(bytes.NewReader(val))
-				t, err := dec.Token() // read opening bracket '['
-				if err != nil {
-					return fmt.Errorf("%s", err)
-				}
-				if t.(json.Delim) != '[' {
-					return fmt.Errorf("expected JSON array opening bracket '[', but found '%s'", t)
-				}
+// convert returns the model with its JSON array of CIM entities replaced by RDF/XML, and the graph of the model
+// (nil when the model has no such array)
+func (c *converter) convert(model map[string]json.RawMessage) (map[string]interface{}, *graph, error) {
+	defer since(metricConvertSeconds, time.Now())
+	var err error
+	c.result.Reset() // each model has its own XML-string
 
-				//
-				//
-				//
-
-				xCount := 0
-				for dec.More() {
-					var entity map[string]json.RawMessage
-					if err := dec.Decode(&entity); err != nil {
-						if strings.Contains(err.Error(), "map[string]json.RawMessage") {
-							return fmt.Errorf("expected JSON object inside array, but got error instead")
-						}
-						return fmt.Errorf("expected JSON object inside array, but got error: %s", err)
-					}
-					if len(entity) == 0 {
-						continue
-					}
-
-					name, class, id, err := identity(&entity)
-					if err != nil {
-						// return err
-						fmt.Fprintf(os.Stderr, "%s\n", err)
-						continue // skipping bad errors
-					}
-
-					if xCount == 0 {
-						// result.WriteRune('"')
-						result.WriteString(headerXML)
-						result.WriteRune('\n')
-						result.WriteString(headerRDF)
-						result.WriteRune('\n')
-					}
-					result.WriteString(fmt.Sprintf("  <%s:%s rdf:about=\"_%s\">\n", name, class, id[posUUID:]))
-
-					local := bytes.NewBufferString("")
-					// var data []byte
-					// strictEntity := make(map[string]json.RawMessage, len(entity))
-					for k, v := range entity {
-						if skip, exists := skipKeys[k]; exists {
-							if skip {
-								continue
-							}
-						}
-						if parts := strings.Split(k, ":"); len(parts) == 2 {
-							prefix := parts[0]
-							attr := parts[1]
-							// FXIME: use val for rdf:resource etc... i.e need to expand/substitute in v
-							// if val, exists := ns[prefix]; exists {
-							if _, exists := ns[prefix]; exists {
-
-								var value interface{}
-								if err = json.Unmarshal(v, &value); err != nil {
-
-									fmt.Printf("-----ERROR-----  '%s' for: %v\n", err, v)
-
-								}
-
-								switch attrValue := value.(type) {
-								case nil:
-									continue
-								case string:
-									pieces := strings.Split(attrValue, ":")
-									if len(pieces) == 3 && pieces[0] == "~" {
-										localRef := pieces[2]
-										localNS := pieces[1]
-										if len(strings.Split(localRef, "-")) == 5 {
-											result.WriteString(fmt.Sprintf("    <%s:%s rdf:resource=\"#_%s\"/>\n", prefix, attr, localRef))
-										} else if ref, exists := ns[localNS]; exists {
-											result.WriteString(fmt.Sprintf("    <%s:%s rdf:resource=\"%s%s\"/>\n", prefix, attr, ref, localRef))
-										} else {
-											result.WriteString(fmt.Sprintf("    <%s:%s>%v</%s:%s>\n", prefix, attr, value, prefix, attr))
-										}
-									} else {
-										result.WriteString(fmt.Sprintf("    <%s:%s>%s</%s:%s>\n", prefix, attr, attrValue, prefix, attr))
-									}
-								case map[string]interface{}:
-									localID := uuid.NewSHA1(uuid.Nil, []byte(fmt.Sprintf("%s:%s:%s", id, prefix, attr))).String()
-									result.WriteString(fmt.Sprintf("    <%s:%s rdf:resource=\"#_%s\"/>\n", prefix, attr, localID))
-									localCount := 0
-									subName := name
-									subKey := ""
-									for localKey, localVal := range attrValue {
-										subName = name
-										subKey = localKey
-										nameSubs := strings.Split(localKey, ":")
-										if len(nameSubs) == 2 {
-											subName = nameSubs[0]
-											subKey = nameSubs[1]
-										}
-										if localCount == 0 {
-											local.WriteString(fmt.Sprintf("    <%s:%s rdf:about=\"_%s\">\n", subName, subKey, localID))
-										}
-										subs := strings.Split(localKey, ".")
-										attrSubs := strings.Split(attr, ".")
-										if len(subs) == 2 && len(attrSubs) == 2 {
-											if subs[0] == attrSubs[1] {
-												result.WriteString(fmt.Sprintf("        <%s:%s>%v</%s:%s>\n", subName, attrSubs, localVal, subName, attrSubs))
-											}
-										}
-										localCount++
-									}
-									if localCount != 0 {
-										local.WriteString(fmt.Sprintf("    </%s:%s>\n", subName, subKey))
-									}
-								case []interface{}:
-									continue
-								default:
-									result.WriteString(fmt.Sprintf("    <%s:%s>%v</%s:%s>\n", prefix, attr, attrValue, prefix, attr))
-								}
-
-							}
-						}
-					}
-					// TODO: make another testing-only flag here to make strictEntity not possible to marshal, for testing HTTP 503 below
-					// if data, err = json.Marshal(strictEntity); err != nil {
-					// 	return fmt.Errorf("%s", err)
-					// }
-
-					// fmt.Printf("data: %v\n", string(data))
-
-					// if _, err = result.Write(data); err != nil { // for the outer batch
-					// 	return fmt.Errorf("error writing response: %s", err)
-					// }
-
-					result.WriteString(fmt.Sprintf("  </%s:%s>\n", name, class))
-					if local.Len() == 0 {
-						result.WriteString(local.String())
-					}
-
-					//
-					//
-					//
-
-					xCount++
-				}
+	var ns map[string]string
+	if val, exist := model[c.nField]; exist {
+		if err = json.Unmarshal(val, &ns); err != nil {
+			return nil, nil, fmt.Errorf("expected the map of namespaces '%s' to be a JSON object with string values, but got error: %s", c.nField, err)
+		}
+	} else if val, exist := c.cfg[c.nField]; exist {
 
-				if xCount != 0 {
-					result.WriteString(footerRDF)
-				}
+		switch nsValue := val.(type) {
+		case map[string]string:
+			ns = nsValue
+		default:
+		}
 
-				if _, err = dec.Token(); err != nil { // read closing bracket ']'
-					return fmt.Errorf("expected JSON array closing bracket ']', but got error: %s", err)
-				}
-				// result.WriteRune('"')
+	}
 
-				delete(model, jField)
-				strictModel[xField] = result.String()
+	log := c.log.With("model", modelURN(model))
+	strictModel := make(map[string]interface{}, len(model))
+	var g *graph
+	if val, exist := model[c.jField]; exist {
 
-				for k, v := range model {
-					var any interface{}
-					json.Unmarshal(v, &any)
-					strictModel[k] = any
-				}
+		dec := json.NewDecoder(bytes.NewReader(val))
+		t, err := dec.Token() // read opening bracket '['
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s", err)
+		}
+		if d, ok := t.(json.Delim); !ok || d != '[' {
+			return nil, nil, fmt.Errorf("expected field '%s' to be a JSON array, but found '%v'", c.jField, t)
+		}
 
-				//
+		var resources []*resource
+		for dec.More() {
+			var entity map[string]json.RawMessage
+			if err := dec.Decode(&entity); err != nil {
+				if strings.Contains(err.Error(), "map[string]json.RawMessage") {
+					return nil, nil, fmt.Errorf("expected JSON object inside array, but got error instead")
+				}
+				return nil, nil, fmt.Errorf("expected JSON object inside array, but got error: %s", err)
+			}
+			if len(entity) == 0 {
+				continue
+			}
 
+			name, class, id, err := identity(&entity, log)
+			if err != nil {
+				metrics.add(metricSkipped, "", 1)
+				continue // skipping bad errors, as logged by identity
+			}
+			metrics.add(metricEntities, labels("operation", "convert"), 1)
+			if c.mapping != nil {
+				c.mapping.apply(entity, name, class)
 			}
+			resources = append(resources, &resource{entity: entity, name: name, class: class, id: id})
+		}
 
-			// TODO: make a testing-only flag here to make model not possible to marshal, for testing HTTP 503 below
-			var data []byte
-			// if data, err = json.Marshal(model); err != nil {
-			// 	return fmt.Errorf("%s", err)
-			// }
-			if total != 0 {
-				if _, err = rw.WriteRune(','); err != nil { // for the outer batch
-					return fmt.Errorf("error writing response: %s", err)
+		var forward, reverse []*resource
+		for _, res := range c.filter.selection(resources) {
+			if deleted(res.entity) {
+				if c.difference {
+					reverse = append(reverse, res)
 				}
+				continue
 			}
-			for k := range strictModel {
-				if k != "_id" && k[0] == '_' {
-
-					delete(strictModel, k)
+			forward = append(forward, res)
+		}
 
-				}
+		g = &graph{ns: ns, difference: c.difference}
+		if c.difference {
+			g.about = modelURN(model)
+		}
+		for _, res := range forward {
+			g.forward = append(g.forward, statementsOf(res, ns, c.filter)...)
+		}
+		for _, res := range reverse {
+			g.reverse = append(g.reverse, statementsOf(res, ns, c.filter)...)
+		}
+		if len(c.digestField) != 0 || c.versioning {
+			sum := g.digest()
+			if len(c.digestField) != 0 {
+				strictModel[c.digestField] = sum
 			}
-			// TODO: make another testing-only flag here to make strictModel not possible to marshal, for testing HTTP 503 below
-			if data, err = json.Marshal(strictModel); err != nil {
-				return fmt.Errorf("%s", err)
+			if c.versioning {
+				g.version(sum)
 			}
+		}
+		if c.shapes != nil {
+			strictModel[c.reportField] = c.shapes.Validate(g.triples())
+		}
+		g.writeRDFXML(c.result)
 
-			// fmt.Printf("data: %v\n", string(data))
-
-			if _, err = rw.Write(data); err != nil { // for the outer batch
-				return fmt.Errorf("error writing response: %s", err)
-			}
-			rw.Flush()
-			total++
+		if _, err = dec.Token(); err != nil { // read closing bracket ']'
+			return nil, nil, fmt.Errorf("expected JSON array closing bracket ']', but got error: %s", err)
 		}
-	}
-	rw.Flush()
 
-	if _, err = batch.Token(); err != nil { // read closing bracket ']'
-		return fmt.Errorf("expected JSON array closing bracket ']', but got error: %s", err)
+		delete(model, c.jField)
+		strictModel[c.xField] = c.result.String()
+
+		for k, v := range model {
+			var any interface{}
+			json.Unmarshal(v, &any)
+			strictModel[k] = any
+		}
 	}
 
-	// TODO: test-case with a failing rw-ReadWriter (simulating client peer closed connection etc) returning error for testing HTTP 503 below
-	if _, err = rw.WriteRune(']'); err != nil { // for the outer batch
-		return fmt.Errorf("error writing response: %s", err)
+	for k := range strictModel {
+		if !c.internal.retain(k) {
+			delete(strictModel, k)
+		}
 	}
-	return nil
+	return strictModel, g, nil
 }
 
-func identity(entity *map[string]json.RawMessage) (name string, class string, id string, err error) {
+// identity returns the namespace name and class of the 'rdf:type' and the urn:uuid-scheme '_id' of a CIM entity,
+// logging why the entity lacks a valid identity
+func identity(entity *map[string]json.RawMessage, log *logger) (name string, class string, id string, err error) {
+	defer func() {
+		if err != nil {
+			log := log.With("_id", id)
+			if log.Enabled(logDEBUG) {
+				data, _ := json.Marshal(*entity)
+				log = log.With("entity", json.RawMessage(data))
+			}
+			log.Errorf("skipping entity: %s", err)
+		}
+	}()
 	var ids []string
 	if val, exist := (*entity)["$ids"]; exist {
 		if err = json.Unmarshal(val, &ids); err != nil {
@@ -342,18 +344,6 @@ func identity(entity *map[string]json.RawMessage) (name string, class string, id
 	}
 	if !strings.HasPrefix(id, "urn:uuid:") || len(id) != lenURN || len(strings.Split(id[posUUID:], "-")) != 5 {
 		err = fmt.Errorf("expected '_id' to be a valid RFC 4122 urn:uuid-scheme value")
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "%s\n", err)
-			// fmt.Printf("\n\n\n")
-			e := *entity
-			for k, v := range e {
-				var in interface{}
-				json.Unmarshal(v, &in)
-				fmt.Fprintf(os.Stderr, "\"%s\": %v\n", k, string(v))
-				// fmt.Printf("\"%s\": %v\n", k, string(v))
-			}
-			// fmt.Printf("\n\n\n")
-		}
 		return name, class, id, err
 	}
 	var (
@@ -389,17 +379,26 @@ func identity(entity *map[string]json.RawMessage) (name string, class string, id
 	if !hasRDFTYPE {
 		err = fmt.Errorf("expected 'rdf:type' to contain the class (NI) namespace identifier '~:<namespace>:%s'", class)
 	}
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "%s\n", err)
-		// fmt.Printf("\n\n\n")
-		e := *entity
-		for k, v := range e {
-			var in interface{}
-			json.Unmarshal(v, &in)
-			fmt.Fprintf(os.Stderr, "\"%s\": %v\n", k, string(v))
-			// fmt.Printf("\"%s\": %v\n", k, string(v))
-		}
-		// fmt.Printf("\n\n\n")
-	}
 	return name, class, id, err
 }
+
+// deleted reports whether the entity is marked as deleted by Sesam
+func deleted(entity map[string]json.RawMessage) bool {
+	var isDeleted bool
+	if val, exist := entity["_deleted"]; exist {
+		json.Unmarshal(val, &isDeleted)
+	}
+	return isDeleted
+}
+
+// modelURN returns the urn:uuid-scheme identifier of the model, derived from its '_id' unless already one
+func modelURN(model map[string]json.RawMessage) string {
+	var id string
+	if val, exist := model["_id"]; exist {
+		json.Unmarshal(val, &id)
+	}
+	if strings.HasPrefix(id, "urn:uuid:") && len(id) == lenURN {
+		return id
+	}
+	return uuid.NewSHA1(uuid.Nil, []byte(id)).URN()
+}
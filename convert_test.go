@@ -88,7 +88,7 @@ const (
 	}`
 	NL        string = "\n"
 	headerXML string = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>`
-	headerRDF string = `<rdf:RDF xmlns:cim="http://iec.ch/TC57/2017/CIM-schema-cim100#" xmlns:md="http://iec.ch/TC57/61970-552/ModelDescription/1#" "xmlns:nek": "http://nek.no/NK57/CIM/CIM100-Extension/1/0#" "xmlns:entsoe": "http://entsoe.eu/CIM/SchemaExtension/3/2#" "xmlns:iev": "http://iec.ch/TC1/60050-6xx/Electropedia/1#" xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">`
+	headerRDF string = `<rdf:RDF xmlns:cim="http://iec.ch/TC57/2017/CIM-schema-cim100#" xmlns:md="http://iec.ch/TC57/61970-552/ModelDescription/1#" xmlns:nek="http://nek.no/NK57/CIM/CIM100-Extension/1/0#" xmlns:entsoe="http://entsoe.eu/CIM/SchemaExtension/3/2#" xmlns:iev="http://iec.ch/TC1/60050-6xx/Electropedia/1#" xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">`
 	footerRDF string = `</rdf:RDF>`
 )
 
@@ -212,6 +212,22 @@ var _ = Describe("Microservice CIM JSON-to-RDF/XML conversions", func() {
 			})
 		})
 
+		Context("with CIM entities not in an array", func() {
+			BeforeEach(func() {
+				input = `[{"_id": "m0", "json": 5}]`
+				rw = NewInputOutput(input, "", &buf)
+				err = Convert(rw, &defaults, sz)
+				rw.Flush()
+			})
+			AfterEach(func() {
+				buf.Reset()
+			})
+			It("fails", func() {
+				By("an error telling the field")
+				Expect(err).To(MatchError(ContainSubstring("expected field 'json' to be a JSON array, but found '5'")))
+			})
+		})
+
 		Context("with a single CIM component", func() {
 			BeforeEach(func() {
 				input = `[{` + namespaces + `
@@ -230,7 +246,7 @@ var _ = Describe("Microservice CIM JSON-to-RDF/XML conversions", func() {
 					}]`
 				content = fmt.Sprintf("%s\n%s\n", headerXML, headerRDF)
 				content += `
-				  <cim:Class rdf:ID="_00000000-0000-0000-0000-000000000000">
+				  <cim:Class rdf:about="_00000000-0000-0000-0000-000000000000">
 				  <cim:Class.property>value</cim:Class.property>
 					</cim:Class>
 					`
@@ -282,10 +298,10 @@ var _ = Describe("Microservice CIM JSON-to-RDF/XML conversions", func() {
 					}]`
 				content = fmt.Sprintf("%s\n%s\n", headerXML, headerRDF)
 				content += `
-				  <cim:Class rdf:ID="_00000000-0000-0000-0000-000000000000">
+				  <cim:Class rdf:about="_00000000-0000-0000-0000-000000000000">
+				  <cim:Class.Other rdf:resource="#_00000000-1100-0000-0011-000000000000"/>
 				  <cim:Class.property>value</cim:Class.property>
 				  <cim:Class.ref rdf:resource="http://iec.ch/TC57/2017/CIM-schema-cim100#Values.item"/>
-				  <cim:Class.Other rdf:resource="#_00000000-1100-0000-0011-000000000000"/>
 					</cim:Class>
 					`
 				content += fmt.Sprintf("%s\n", footerRDF)
@@ -348,15 +364,15 @@ var _ = Describe("Microservice CIM JSON-to-RDF/XML conversions", func() {
 					}]`
 				content = fmt.Sprintf("%s\n%s\n", headerXML, headerRDF)
 				content += `
-				  <cim:Class rdf:ID="_00000000-0000-0000-0000-000000000000">
+				  <cim:Class rdf:about="_00000000-0000-0000-0000-000000000000">
+				  <cim:Class.Other rdf:resource="#_00000000-1100-0000-0011-000000000000"/>
 				  <cim:Class.property>value</cim:Class.property>
 				  <cim:Class.ref rdf:resource="http://iec.ch/TC57/2017/CIM-schema-cim100#Values.item"/>
-				  <cim:Class.Other rdf:resource="#_00000000-1100-0000-0011-000000000000"/>
 					</cim:Class>
-				  <cim:AltClass rdf:ID="_00000000-1100-0000-0011-000000000000">
+				  <cim:AltClass rdf:about="_00000000-1100-0000-0011-000000000000">
+				  <cim:AltClass.Other rdf:resource="#_00000000-0000-0000-0000-000000000000"/>
 				  <cim:AltClass.property>value</cim:AltClass.property>
 				  <cim:AltClass.ref rdf:resource="http://iec.ch/TC57/2017/CIM-schema-cim100#Values.more"/>
-				  <cim:AltClass.Other rdf:resource="#_00000000-0000-0000-0000-000000000000"/>
 					</cim:AltClass>
 					`
 				content += fmt.Sprintf("%s\n", footerRDF)
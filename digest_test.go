@@ -0,0 +1,96 @@
+package main_test
+
+import (
+	"bytes"
+	"encoding/json"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "sesam-cimrdf"
+)
+
+func NewDigest(input string, opt Options) string {
+	var buf bytes.Buffer
+	rw := NewInputOutput(input, "", &buf)
+	err := Convert(rw, &opt, 0)
+	rw.Flush()
+	Expect(err).To(BeNil())
+	var models []map[string]interface{}
+	Expect(json.Unmarshal(buf.Bytes(), &models)).To(Succeed())
+	Expect(models).To(HaveLen(1))
+	Expect(models[0]).To(HaveKey("digest"))
+	return models[0]["digest"].(string)
+}
+
+var _ = Describe("Microservice canonical model digest", func() {
+
+	var (
+		opt     Options = Options{"json": "json", "digest": "digest"}
+		ordered string  = `[{` + namespaces + `
+			,"json":[
+				{
+					"$ids": ["urn:uuid:00000000-0000-0000-0000-000000000001", "~:Substation:00000000-0000-0000-0000-000000000001"],
+					"_id": "urn:uuid:00000000-0000-0000-0000-000000000001",
+					"cim:IdentifiedObject.name": "first",
+					"cim:Substation.Region": "~:SubGeographicalRegion:00000000-0000-0000-0000-000000000002",
+					"rdf:type": "~:cim:Substation"
+				}
+			]
+		}]`
+		reordered string = `[{` + namespaces + `
+			,"json":[
+				{
+					"rdf:type": "~:cim:Substation",
+					"cim:Substation.Region": "~:SubGeographicalRegion:00000000-0000-0000-0000-000000000002",
+					"cim:IdentifiedObject.name": "first",
+					"_id": "urn:uuid:00000000-0000-0000-0000-000000000001",
+					"$ids": ["urn:uuid:00000000-0000-0000-0000-000000000001", "~:Substation:00000000-0000-0000-0000-000000000001"]
+				}
+			]
+		}]`
+		changed string = `[{` + namespaces + `
+			,"json":[
+				{
+					"$ids": ["urn:uuid:00000000-0000-0000-0000-000000000001", "~:Substation:00000000-0000-0000-0000-000000000001"],
+					"_id": "urn:uuid:00000000-0000-0000-0000-000000000001",
+					"cim:IdentifiedObject.name": "second",
+					"cim:Substation.Region": "~:SubGeographicalRegion:00000000-0000-0000-0000-000000000002",
+					"rdf:type": "~:cim:Substation"
+				}
+			]
+		}]`
+		header string = `[{` + namespaces + `
+			,"json":[
+				{
+					"$ids": ["urn:uuid:00000000-0000-0000-0000-0000000000ff", "~:FullModel:00000000-0000-0000-0000-0000000000ff"],
+					"_id": "urn:uuid:00000000-0000-0000-0000-0000000000ff",
+					"md:Model.version": "old",
+					"rdf:type": "~:md:FullModel"
+				}
+			]
+		}]`
+	)
+
+	It("is identical for identical triples regardless of property order", func() {
+		Expect(NewDigest(ordered, opt)).To(Equal(NewDigest(reordered, opt)))
+		Expect(NewDigest(ordered, opt)).To(HaveLen(64))
+	})
+
+	It("differs for changed triples", func() {
+		Expect(NewDigest(ordered, opt)).NotTo(Equal(NewDigest(changed, opt)))
+	})
+
+	It("derives the model version", func() {
+		var buf bytes.Buffer
+		rw := NewInputOutput(header, "", &buf)
+		Expect(Convert(rw, &Options{"json": "json", "digest": "digest", "version": true}, 0)).To(Succeed())
+		rw.Flush()
+		digest := NewDigest(header, opt)
+		inner, err := NewInner(buf.String(), "xml")
+		Expect(err).To(BeNil())
+		Expect(inner[0]).To(ContainSubstring(`<md:Model.version>` + digest + `</md:Model.version>`))
+		Expect(inner[0]).NotTo(ContainSubstring(`old`))
+	})
+
+})
@@ -0,0 +1,117 @@
+package main_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "sesam-cimrdf"
+)
+
+var _ = Describe("Microservice underscore-prefixed Sesam fields", func() {
+
+	var (
+		input string = `[{"_id":"model","_updated":42,"_deleted":false,"_hash":"abc",` + namespaces + `
+			,"json":[
+				{
+					"$ids": ["urn:uuid:00000000-0000-0000-0000-000000000001", "~:Substation:00000000-0000-0000-0000-000000000001"],
+					"_id": "urn:uuid:00000000-0000-0000-0000-000000000001",
+					"cim:IdentifiedObject.name": "kept",
+					"rdf:type": "~:cim:Substation"
+				},
+				{
+					"$ids": ["urn:uuid:00000000-0000-0000-0000-000000000002", "~:Substation:00000000-0000-0000-0000-000000000002"],
+					"_id": "urn:uuid:00000000-0000-0000-0000-000000000002",
+					"_deleted": true,
+					"cim:IdentifiedObject.name": "removed",
+					"rdf:type": "~:cim:Substation"
+				}
+			]
+		}]`
+		opt   Options
+		inner []string
+		buf   bytes.Buffer
+		err   error
+	)
+
+	Describe("when converting", func() {
+
+		JustBeforeEach(func() {
+			rw := NewInputOutput(input, "", &buf)
+			err = Convert(rw, &opt, 0)
+			rw.Flush()
+			Expect(err).To(BeNil())
+			inner, err = NewInner(buf.String(), "xml")
+			Expect(err).To(BeNil())
+		})
+		AfterEach(func() {
+			buf.Reset()
+		})
+
+		Context("with default fields", func() {
+			BeforeEach(func() {
+				opt = Options{"json": "json"}
+			})
+			It("strips all but '_id' and omits deleted entities", func() {
+				Expect(buf.String()).To(ContainSubstring(`"_id":"model"`))
+				Expect(buf.String()).NotTo(ContainSubstring(`"_updated"`))
+				Expect(buf.String()).NotTo(ContainSubstring(`"_hash"`))
+				Expect(inner[0]).To(ContainSubstring(`kept`))
+				Expect(inner[0]).NotTo(ContainSubstring(`removed`))
+			})
+		})
+
+		Context("with kept and stripped fields", func() {
+			BeforeEach(func() {
+				opt = Options{"json": "json", "keep": []string{"*"}, "strip": "_hash"}
+			})
+			It("keeps the allowed fields", func() {
+				Expect(buf.String()).To(ContainSubstring(`"_updated":42`))
+				Expect(buf.String()).To(ContainSubstring(`"_deleted":false`))
+				Expect(buf.String()).NotTo(ContainSubstring(`"_hash"`))
+			})
+		})
+
+		Context("with difference mode", func() {
+			BeforeEach(func() {
+				opt = Options{"json": "json", "difference": true}
+			})
+			It("moves deleted entities to reverse differences", func() {
+				forward := strings.Index(inner[0], "<dm:forwardDifferences")
+				reverse := strings.Index(inner[0], "<dm:reverseDifferences")
+				Expect(forward).To(BeNumerically(">", 0))
+				Expect(reverse).To(BeNumerically(">", forward))
+				Expect(strings.Index(inner[0], "kept")).To(BeNumerically("<", reverse))
+				Expect(strings.Index(inner[0], "removed")).To(BeNumerically(">", reverse))
+			})
+		})
+	})
+
+	Describe("when minting", func() {
+
+		var (
+			server   *Server
+			response *httptest.ResponseRecorder
+		)
+
+		BeforeEach(func() {
+			opt = Options{"log": ioutil.Discard, "seed": "ginkgo", "keep": []string{"_deleted", "_updated"}}
+			server = NewTestServer(opt)
+			response = httptest.NewRecorder()
+			request, _ := http.NewRequest("POST", "/", strings.NewReader(`[{"_id":"a","_deleted":false,"_updated":7,"_ts":1}]`))
+			server.ServeHTTP(response, request)
+		})
+		It("keeps the allowed fields", func() {
+			Expect(response.Code).To(Equal(200))
+			Expect(response.Body.String()).To(ContainSubstring(`"_deleted":false`))
+			Expect(response.Body.String()).To(ContainSubstring(`"_updated":7`))
+			Expect(response.Body.String()).NotTo(ContainSubstring(`"_ts"`))
+		})
+	})
+
+})
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Filter selects the entities of a model by their class resolved with identity(), and their properties by namespace prefix.
+// Classes are given as "<namespace>:<class>" (e.g. "cim:ACLineSegment") or as a whole namespace "<namespace>:" (e.g. "entsoe:")
+type Filter struct {
+	Include         []string `json:"include,omitempty"`          // classes to convert, all when empty
+	Exclude         []string `json:"exclude,omitempty"`          // classes never converted, also when referenced
+	IncludePrefixes []string `json:"include_prefixes,omitempty"` // property namespace prefixes to convert (e.g. "cim"), all when empty
+	ExcludePrefixes []string `json:"exclude_prefixes,omitempty"` // property namespace prefixes never converted
+	Follow          bool     `json:"follow,omitempty"`           // also convert resources referenced by selected entities, transitively
+	Referrers       bool     `json:"referrers,omitempty"`        // also convert entities referencing the included entities (e.g. their 'cim:Terminal's)
+}
+
+// resource is an entity of a model with its resolved identity
+type resource struct {
+	entity map[string]json.RawMessage
+	name   string
+	class  string
+	id     string
+}
+
+// filterOf returns the filter from the configuration, nil when every entity and property is converted
+func filterOf(cfg Options) (*Filter, error) {
+	val, exist := cfg["filter"]
+	if !exist {
+		return nil, nil
+	}
+	switch f := val.(type) {
+	case nil:
+		return nil, nil
+	case Filter:
+		return &f, nil
+	case *Filter:
+		return f, nil
+	case map[string]interface{}:
+		data, _ := json.Marshal(f)
+		var filter Filter
+		if err := json.Unmarshal(data, &filter); err != nil {
+			return nil, fmt.Errorf("expected option 'filter' to be a JSON object of class and prefix lists, but got error: %s", err)
+		}
+		return &filter, nil
+	default:
+		return nil, fmt.Errorf("expected option 'filter' to be a filter, but got %T", val)
+	}
+}
+
+// matchClass reports whether the class of the resource is among the given classes or namespaces
+func matchClass(classes []string, res *resource) bool {
+	for _, c := range classes {
+		if c == res.name+":"+res.class || c == res.name+":" {
+			return true
+		}
+	}
+	return false
+}
+
+// property reports whether properties of the namespace prefix are converted
+func (f *Filter) property(prefix string) bool {
+	if f == nil {
+		return true
+	}
+	for _, p := range f.ExcludePrefixes {
+		if strings.TrimSuffix(p, ":") == prefix {
+			return false
+		}
+	}
+	if len(f.IncludePrefixes) == 0 {
+		return true
+	}
+	for _, p := range f.IncludePrefixes {
+		if strings.TrimSuffix(p, ":") == prefix {
+			return true
+		}
+	}
+	return false
+}
+
+// selection returns the resources to convert, keeping their order in the model
+func (f *Filter) selection(resources []*resource) []*resource {
+	if f == nil {
+		return resources
+	}
+	byID := make(map[string]*resource, len(resources))
+	for _, res := range resources {
+		byID[res.id] = res
+	}
+	selected := make(map[string]bool, len(resources))
+	var pending []*resource
+	for _, res := range resources {
+		if matchClass(f.Exclude, res) {
+			continue
+		}
+		if len(f.Include) == 0 || matchClass(f.Include, res) {
+			selected[res.id] = true
+			pending = append(pending, res)
+		}
+	}
+	if f.Referrers {
+		seeds := make(map[string]bool, len(selected))
+		for id := range selected {
+			seeds[id] = true
+		}
+		for _, res := range resources {
+			if selected[res.id] || matchClass(f.Exclude, res) {
+				continue
+			}
+			for _, ref := range references(res.entity) {
+				if seeds[ref] {
+					selected[res.id] = true
+					pending = append(pending, res)
+					break
+				}
+			}
+		}
+	}
+	if f.Follow {
+		for len(pending) != 0 {
+			res := pending[0]
+			pending = pending[1:]
+			for _, ref := range references(res.entity) {
+				if target, exist := byID[ref]; exist && !selected[ref] && !matchClass(f.Exclude, target) {
+					selected[ref] = true
+					pending = append(pending, target)
+				}
+			}
+		}
+	}
+	result := make([]*resource, 0, len(selected))
+	for _, res := range resources {
+		if selected[res.id] {
+			result = append(result, res)
+		}
+	}
+	return result
+}
+
+// references returns the urn:uuid-scheme identifiers of the local '~:<namespace>:<UUID>' references of the entity
+func references(entity map[string]json.RawMessage) []string {
+	var refs []string
+	for k, v := range entity {
+		if skipKeys[k] || k == "$ids" {
+			continue
+		}
+		var value string
+		if err := json.Unmarshal(v, &value); err != nil {
+			continue
+		}
+		if pieces := strings.Split(value, ":"); len(pieces) == 3 && pieces[0] == "~" && len(strings.Split(pieces[2], "-")) == 5 {
+			refs = append(refs, "urn:uuid:"+pieces[2])
+		}
+	}
+	return refs
+}
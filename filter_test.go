@@ -0,0 +1,119 @@
+package main_test
+
+import (
+	"bytes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "sesam-cimrdf"
+)
+
+var _ = Describe("Microservice class and profile filtering", func() {
+
+	var (
+		input string = `[{` + namespaces + `
+			,"json":[
+				{
+					"$ids": ["urn:uuid:00000000-0000-0000-0000-000000000001", "~:ACLineSegment:00000000-0000-0000-0000-000000000001"],
+					"_id": "urn:uuid:00000000-0000-0000-0000-000000000001",
+					"cim:IdentifiedObject.name": "line",
+					"entsoe:IdentifiedObject.shortName": "L1",
+					"rdf:type": "~:cim:ACLineSegment"
+				},
+				{
+					"$ids": ["urn:uuid:00000000-0000-0000-0000-000000000002", "~:Terminal:00000000-0000-0000-0000-000000000002"],
+					"_id": "urn:uuid:00000000-0000-0000-0000-000000000002",
+					"cim:IdentifiedObject.name": "terminal",
+					"cim:Terminal.ConductingEquipment": "~:ACLineSegment:00000000-0000-0000-0000-000000000001",
+					"cim:Terminal.ConnectivityNode": "~:ConnectivityNode:00000000-0000-0000-0000-000000000003",
+					"rdf:type": "~:cim:Terminal"
+				},
+				{
+					"$ids": ["urn:uuid:00000000-0000-0000-0000-000000000003", "~:ConnectivityNode:00000000-0000-0000-0000-000000000003"],
+					"_id": "urn:uuid:00000000-0000-0000-0000-000000000003",
+					"cim:IdentifiedObject.name": "node",
+					"rdf:type": "~:cim:ConnectivityNode"
+				},
+				{
+					"$ids": ["urn:uuid:00000000-0000-0000-0000-000000000004", "~:Substation:00000000-0000-0000-0000-000000000004"],
+					"_id": "urn:uuid:00000000-0000-0000-0000-000000000004",
+					"cim:IdentifiedObject.name": "substation",
+					"rdf:type": "~:cim:Substation"
+				}
+			]
+		}]`
+		filter *Filter
+		inner  []string
+		buf    bytes.Buffer
+		err    error
+	)
+
+	JustBeforeEach(func() {
+		rw := NewInputOutput(input, "", &buf)
+		err = Convert(rw, &Options{"json": "json", "filter": filter}, 0)
+		rw.Flush()
+		Expect(err).To(BeNil())
+		inner, err = NewInner(buf.String(), "xml")
+		Expect(err).To(BeNil())
+	})
+	AfterEach(func() {
+		buf.Reset()
+	})
+
+	Context("with included class", func() {
+		BeforeEach(func() {
+			filter = &Filter{Include: []string{"cim:ACLineSegment"}}
+		})
+		It("delivers only the class", func() {
+			Expect(inner[0]).To(ContainSubstring(`<cim:ACLineSegment rdf:about`))
+			Expect(inner[0]).NotTo(ContainSubstring(`<cim:Terminal rdf:about`))
+			Expect(inner[0]).NotTo(ContainSubstring(`<cim:Substation rdf:about`))
+		})
+	})
+
+	Context("with included class and referrers", func() {
+		BeforeEach(func() {
+			filter = &Filter{Include: []string{"cim:ACLineSegment"}, Referrers: true}
+		})
+		It("delivers the class and the entities referencing it", func() {
+			Expect(inner[0]).To(ContainSubstring(`<cim:ACLineSegment rdf:about`))
+			Expect(inner[0]).To(ContainSubstring(`<cim:Terminal rdf:about`))
+			Expect(inner[0]).NotTo(ContainSubstring(`<cim:ConnectivityNode rdf:about`))
+		})
+	})
+
+	Context("with included class, referrers and followed references", func() {
+		BeforeEach(func() {
+			filter = &Filter{Include: []string{"cim:ACLineSegment"}, Referrers: true, Follow: true}
+		})
+		It("delivers the closure", func() {
+			Expect(inner[0]).To(ContainSubstring(`<cim:ACLineSegment rdf:about`))
+			Expect(inner[0]).To(ContainSubstring(`<cim:Terminal rdf:about`))
+			Expect(inner[0]).To(ContainSubstring(`<cim:ConnectivityNode rdf:about`))
+			Expect(inner[0]).NotTo(ContainSubstring(`<cim:Substation rdf:about`))
+		})
+	})
+
+	Context("with excluded class and followed references", func() {
+		BeforeEach(func() {
+			filter = &Filter{Include: []string{"cim:Terminal"}, Exclude: []string{"cim:ConnectivityNode"}, Follow: true}
+		})
+		It("never delivers the excluded class", func() {
+			Expect(inner[0]).To(ContainSubstring(`<cim:ACLineSegment rdf:about`))
+			Expect(inner[0]).To(ContainSubstring(`<cim:Terminal rdf:about`))
+			Expect(inner[0]).NotTo(ContainSubstring(`<cim:ConnectivityNode rdf:about`))
+		})
+	})
+
+	Context("with excluded property prefix", func() {
+		BeforeEach(func() {
+			filter = &Filter{ExcludePrefixes: []string{"entsoe"}}
+		})
+		It("delivers no properties of the prefix", func() {
+			Expect(inner[0]).To(ContainSubstring(`<cim:IdentifiedObject.name>line</cim:IdentifiedObject.name>`))
+			Expect(inner[0]).NotTo(ContainSubstring(`entsoe:IdentifiedObject.shortName`))
+		})
+	})
+
+})
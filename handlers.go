@@ -4,8 +4,12 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"net/http"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/julienschmidt/httprouter"
@@ -30,251 +34,915 @@ func (s *Server) HandleField(w http.ResponseWriter, r *http.Request, p httproute
 // https://tools.ietf.org/html/rfc4122   (URN:UUID-scheme)
 // https://en.wikipedia.org/wiki/Uniform_Resource_Name
 // https://en.wikipedia.org/wiki/Universally_unique_identifier
+//
+// Entities are minted and flushed to the client as they are decoded, so also chunked request bodies of unknown length
+// are streamed, also as NDJSON lines by Content-Type and Accept. Errors before the first entity give the usual HTTP
+// status codes, while later errors leave the JSON array unterminated and are reported in the X-Error trailer. In
+// partial failure mode the response is held until the batch has been minted, to send the counts as headers.
 func (s *Server) HandleFieldNamespace(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	log, o := s.log(r), s.optionsOf(r)
 
-	if r.ContentLength == 0 {
-		s.Errorf("error: missing JSON array of entities\n")
-		w.WriteHeader(http.StatusBadRequest)
+	media, ok := negotiate(r, mediaJSON, mediaNDJSON)
+	if !ok {
+		s.fail(w, r, newProblem(http.StatusNotAcceptable, problemNotAcceptable, "unsupported Accept '%s'", r.Header.Get("Accept")))
 		return
 	}
-
-	sz := 3 * 1024 * 1024 // 3MB
-	if int(r.ContentLength) > sz {
-		sz = int(r.ContentLength)
+	input, ok := contentType(r, mediaJSON, mediaNDJSON, mediaForm)
+	if !ok {
+		s.fail(w, r, newProblem(http.StatusUnsupportedMediaType, problemUnsupportedMedia, "unsupported Content-Type '%s'", r.Header.Get("Content-Type")))
+		return
 	}
-	result := bytes.NewBuffer(make([]byte, sz))
-	result.Reset()
-	defer func() {
-		result.Reset()
-	}()
-
-	var err error
-	dec := json.NewDecoder(r.Body)
-	t, err := dec.Token() // read opening bracket '['
+	name, seed, err := o.seedFor(r)
 	if err != nil {
-		s.Errorf("%s\n", err)
-		w.WriteHeader(http.StatusBadRequest)
+		s.fail(w, r, newProblem(http.StatusBadRequest, problemUnknownSeed, "%s", err))
 		return
 	}
-	if t.(json.Delim) != '[' {
-		s.Errorf("expected JSON array opening bracket '[', but found '%s'\n", t)
-		w.WriteHeader(http.StatusBadRequest)
-		return
+	if len(name) != 0 {
+		log = log.With("seed", name)
+	}
+	partial := o.partial
+	if val := r.URL.Query().Get("partial"); len(val) != 0 {
+		if partial, err = strconv.ParseBool(val); err != nil {
+			s.fail(w, r, newProblem(http.StatusBadRequest, problemMalformedRequest, "expected query parameter 'partial' to be a boolean, but got '%s'", val))
+			return
+		}
+	}
+	keyspecs := strings.Split(p.ByName("field"), ";")
+	for _, keyspec := range keyspecs {
+		if err = keyspecError(keyspec); err != nil {
+			s.fail(w, r, newProblem(http.StatusBadRequest, problemInvalidKeyspec, "%s", err).of(keyspec))
+			return
+		}
 	}
 
-	result.WriteRune('[')
+	in := newBatchReader(r.Body, input == mediaNDJSON)
+	if failure := in.open(); failure != nil {
+		s.fail(w, r, failure)
+		return
+	}
 
+	if partial { // held until the counts are known, to send them as headers
+		sr := &spooledResponse{ResponseWriter: w}
+		defer func() {
+			if err := sr.send(); err != nil {
+				log.Errorf("error writing response: %s", err)
+			}
+		}()
+		w = sr
+	}
+	out := newStream(w)
+	out.ndjson = media == mediaNDJSON
+	out.trailers = []string{headerSucceeded, headerFailed}
+	succeeded, failed := 0, 0
+	defer func() {
+		w.Header().Set(headerSucceeded, strconv.Itoa(succeeded))
+		w.Header().Set(headerFailed, strconv.Itoa(failed))
+	}()
+	if s.lookup != nil {
+		defer func() {
+			if err := s.lookup.flush(); err != nil {
+				log.Errorf("error writing lookup store: %s", err)
+			}
+		}()
+	}
+	if s.journal != nil {
+		defer func() {
+			if err := s.journal.flush(); err != nil {
+				log.Errorf("error writing entity journal: %s", err)
+			}
+		}()
+	}
+	var minted spool // published when the whole batch has been minted
+	defer minted.close()
 	nswarn := false
-	total := 0
-	for dec.More() {
+	for index := 0; in.more(); index++ {
 		var entity map[string]interface{}
-		if err := dec.Decode(&entity); err != nil {
-			if strings.Contains(err.Error(), "map[string]interface") {
-				s.Errorf("expected JSON object inside array, but got error instead\n")
+		if err := in.decode(&entity); err != nil {
+			var failure *problem
+			if _, ok := err.(*json.UnmarshalTypeError); ok {
+				failure = newProblem(http.StatusBadRequest, problemNotAnObject, "expected JSON object inside array, but got error instead")
 			} else {
-				s.Errorf("expected JSON object inside array, but got error: %s\n", err)
+				failure = newProblem(http.StatusBadRequest, problemMalformedRequest, "expected JSON object inside array, but got error: %s", err)
+			}
+			failure.at(index, nil)
+			metrics.add(metricMintFailed, "", 1)
+			failed++
+			if !partial || !in.recoverable(err) {
+				out.fail(s.report(r, failure))
+				return
+			}
+			s.report(r, failure)
+			entity = map[string]interface{}{fieldMintIndex: index, fieldMintError: failure}
+		} else {
+			original := make(map[string]interface{}, len(entity))
+			for k, v := range entity {
+				original[k] = v
+			}
+			if err := s.mint(log, name, seed, entity, keyspecs, p.ByName("namespace"), &nswarn); err != nil {
+				failure := err.(*problem).at(index, original["_id"])
+				metrics.add(metricMintFailed, "", 1)
+				failed++
+				if !partial {
+					out.fail(s.report(r, failure))
+					return
+				}
+				s.report(r, failure)
+				entity = original
+				entity[fieldMintError] = failure
+			} else {
+				succeeded++
 			}
-			w.WriteHeader(http.StatusBadRequest)
-			return
 		}
+		metrics.add(metricEntities, labels("operation", "mint"), 1)
 
-		keyspecs := strings.Split(p.ByName("field"), ";")
-		for _, keyspec := range keyspecs {
-			// key := p.ByName("field")
-			key := keyspec // key variable mutates (is substituted), so keeping the original specification as well
-			prefix := ""
-			if key[0] == '_' && key != "_id" {
-				prefix = "#_" // key given wanting automatic RDF resource local label reference format
-				key = key[1:]
+		strictEntity := make(map[string]interface{}, len(entity))
+		for k, v := range entity {
+			if o.internal.retain(k) || k == fieldMintError || k == fieldMintIndex {
+				strictEntity[k] = v
 			}
-
-			ns := p.ByName("namespace")
-			if key[0] == ':' && ns == "" {
-				// FIXME: just make some special meaning for <nil> namespace ? (when disabled HTTP 307 redirects for trailing slash in router)
-				ns = "rdf:type"
+		}
+		// TODO: make a testing-only flag here to make strictEntity not possible to marshal, for testing HTTP 503 below
+		data, err := json.Marshal(strictEntity)
+		if err != nil {
+			out.fail(s.report(r, newProblem(http.StatusInternalServerError, problemInternal, "%s", err).at(index, entity["_id"])))
+			return
+		}
+		if err = out.write(data); err != nil {
+			log.Errorf("error writing response: %s", err)
+			return
+		}
+		if _, failure := strictEntity[fieldMintError]; !failure && s.journal != nil {
+			if _, err = minted.Write(append(data, '\n')); err != nil {
+				out.fail(s.report(r, newProblem(http.StatusInternalServerError, problemInternal, "error holding entity for journal: %s", err).at(index, entity["_id"])))
+				return
 			}
-			if _, exist := entity[key[1:]]; exist && key[0] == ':' {
-				key = key[1:]
-			} else if val, exist := entity[key]; !exist {
-				// key shortcut given needing expanding
-				var nskey string
-				if key[0] == ':' {
-					if key[1] == '.' {
-						nskey = key[1:]
-					} else {
-						nskey = key
-					}
+		}
+	}
+
+	if failure := in.close(); failure != nil {
+		out.fail(s.report(r, failure))
+		return
+	}
+	s.publish(log, &minted)
+	if err = out.close(); err != nil {
+		log.Errorf("error writing response: %s", err)
+	}
+}
+
+// fieldMintError is the field of the problem of an entity failing minting in partial failure mode, fieldMintIndex
+// the field of the index in the batch of an element which is not an entity, and the headers of the counts of entities
+// succeeding and failing minting, which are trailers unless in partial failure mode
+const (
+	fieldMintError  string = "_mint_error"
+	fieldMintIndex  string = "_mint_index"
+	headerSucceeded string = "X-Mint-Succeeded"
+	headerFailed    string = "X-Mint-Failed"
+)
+
+// keyspecError returns why the keyspec of a minting route cannot be minted, if so
+func keyspecError(keyspec string) error {
+	key := keyspec
+	if len(key) != 0 && key[0] == '_' && key != "_id" {
+		key = key[1:]
+	}
+	if len(key) == 0 || key == ":" {
+		return fmt.Errorf("expected keyspec '%s' to name a field", keyspec)
+	}
+	return nil
+}
+
+// headerSeed is the HTTP header naming the seed of a minting request, or else its query parameter 'seed'
+const headerSeed string = "X-UUID-Seed"
+
+// seedFor returns the name and UUID namespace of the named seed picked by the request, or the UUID namespace
+// of the default seed when none is picked, or an error when the name is unknown
+func (o *serverOptions) seedFor(r *http.Request) (string, uuid.UUID, error) {
+	name := strings.Trim(r.Header.Get(headerSeed), " ")
+	if len(name) == 0 {
+		name = strings.Trim(r.URL.Query().Get("seed"), " ")
+	}
+	if len(name) == 0 {
+		return "", o.seed, nil
+	}
+	seed, exist := o.seeds[name]
+	if !exist {
+		return name, uuid.Nil, fmt.Errorf("unknown seed '%s'", name)
+	}
+	return name, seed, nil
+}
+
+// mint substitutes the entity values of the keyspecs with their UUIDs in the UUID namespace seed (named name, if
+// any), logging with the entity '_id' and keyspec, where namespace is the namespace of the route; nswarn is set after
+// the first warning about namespaces, to only warn once per request. A panic of a keyspec is recovered as the
+// returned problem, with the keyspec.
+func (s *Server) mint(log *logger, name string, seed uuid.UUID, entity map[string]interface{}, keyspecs []string, namespace string, nswarn *bool) (err error) {
+	if id, exist := entity["_id"]; exist {
+		log = log.With("_id", id)
+	}
+	keyspec := ""
+	defer func() {
+		if v := recover(); v != nil {
+			err = newProblem(http.StatusInternalServerError, problemMint, "error minting keyspec '%s': %v", keyspec, v).of(keyspec)
+		}
+	}()
+	for _, keyspec = range keyspecs {
+		log := log.With("keyspec", keyspec)
+		// key := p.ByName("field")
+		key := keyspec // key variable mutates (is substituted), so keeping the original specification as well
+		prefix := ""
+		if key[0] == '_' && key != "_id" {
+			prefix = "#_" // key given wanting automatic RDF resource local label reference format
+			key = key[1:]
+		}
+
+		ns := namespace
+		if key[0] == ':' && ns == "" {
+			// FIXME: just make some special meaning for <nil> namespace ? (when disabled HTTP 307 redirects for trailing slash in router)
+			ns = "rdf:type"
+		}
+		if _, exist := entity[key[1:]]; exist && key[0] == ':' {
+			key = key[1:]
+		} else if val, exist := entity[key]; !exist {
+			// key shortcut given needing expanding
+			var nskey string
+			if key[0] == ':' {
+				if key[1] == '.' {
+					nskey = key[1:]
 				} else {
-					ns = "" // no automatic namespace
-					if key[0] == '.' {
-						nskey = key
-					} else {
-						nskey = ":" + key
-					}
-				}
-				for k := range entity {
-					if strings.HasSuffix(k, nskey) {
-						key = k // k includes pipeline namespace, key is now expanded from shortcut
-						break
-					}
+					nskey = key
 				}
 			} else {
-				if strings.Contains(fmt.Sprintf("%v", val), ":") {
-					ns = "" // key value already includes desired namespace
+				ns = "" // no automatic namespace
+				if key[0] == '.' {
+					nskey = key
+				} else {
+					nskey = ":" + key
 				}
 			}
+			for k := range entity {
+				if strings.HasSuffix(k, nskey) {
+					key = k // k includes pipeline namespace, key is now expanded from shortcut
+					break
+				}
+			}
+		} else {
+			if strings.Contains(fmt.Sprintf("%v", val), ":") {
+				ns = "" // key value already includes desired namespace
+			}
+		}
 
-			if ns == "rdf:type" {
-				// want automatic namespacing
-				if val, exist := entity[ns]; exist {
-					switch value := val.(type) {
-					case []interface{}:
-						many := val.([]interface{})
-						if len(many) == 0 {
-							ns = "" // empty array
-						} else if len(many) == 1 {
-							ns = fmt.Sprintf("%v", many[0])
-						} else {
-							ns = fmt.Sprintf("%v", many[0])
-							if !nswarn {
-								s.Logf(logWARN, "warning '%s', multiple 'rdf:type' (using '%v', please indicate): %v\n", keyspec, many[0], many)
-								nswarn = true
-							}
+		if ns == "rdf:type" {
+			// want automatic namespacing
+			if val, exist := entity[ns]; exist {
+				switch value := val.(type) {
+				case []interface{}:
+					many := val.([]interface{})
+					if len(many) == 0 {
+						ns = "" // empty array
+					} else if len(many) == 1 {
+						ns = fmt.Sprintf("%v", many[0])
+					} else {
+						ns = fmt.Sprintf("%v", many[0])
+						if !*nswarn {
+							log.Warnf("multiple 'rdf:type' (using '%v', please indicate): %v", many[0], many)
+							*nswarn = true
 						}
-					default:
-						ns = fmt.Sprintf("%v", value)
 					}
-				} else {
-					if !nswarn {
-						s.Logf(logWARN, "warning '%s', no 'rdf:type' found\n", keyspec)
-						nswarn = true
-					}
-					ns = "" // no RDF type information, so setting blank namespace
-				}
-				if !nswarn && ns == "" {
-					s.Logf(logWARN, "warning '%s', empty 'rdf:type'\n", keyspec)
-					nswarn = true
+				default:
+					ns = fmt.Sprintf("%v", value)
 				}
-			} else if strings.HasSuffix(ns, ":") {
-				if !strings.HasPrefix(ns, "~:") {
-					ns = "~:" + ns
+			} else {
+				if !*nswarn {
+					log.Warnf("no 'rdf:type' found")
+					*nswarn = true
 				}
-				if val, exist := entity["rdf:type"]; exist {
-					choice := ""
-					switch value := val.(type) {
-					case []interface{}:
-						n := 0
-						for _, v := range value {
-							rdfType := v.(string)
-							if strings.HasPrefix(rdfType, ns) {
-								if n == 0 { // choose first prefix match
-									choice = rdfType
-								}
-								n++ // count matches for possible warning
-							}
+				ns = "" // no RDF type information, so setting blank namespace
+			}
+			if !*nswarn && ns == "" {
+				log.Warnf("empty 'rdf:type'")
+				*nswarn = true
+			}
+		} else if strings.HasSuffix(ns, ":") {
+			if !strings.HasPrefix(ns, "~:") {
+				ns = "~:" + ns
+			}
+			if val, exist := entity["rdf:type"]; exist {
+				choice := ""
+				switch value := val.(type) {
+				case []interface{}:
+					n := 0
+					for _, v := range value {
+						rdfType, ok := v.(string)
+						if !ok {
+							return newProblem(http.StatusBadRequest, problemInvalidType, "expected 'rdf:type' to be strings, but got '%v'", v).of(keyspec)
 						}
-						if choice == "" {
-							if !nswarn {
-								s.Logf(logWARN, "warning '%s', prefix '%s' not in 'rdf:type'\n", keyspec, ns)
-								nswarn = true
-							}
-						} else if n != 1 {
-							if !nswarn {
-								s.Logf(logWARN, "warning '%s', multiple 'rdf:type' (using '%v', please indicate): %v\n", keyspec, ns, value)
-								nswarn = true
+						if strings.HasPrefix(rdfType, ns) {
+							if n == 0 { // choose first prefix match
+								choice = rdfType
 							}
-						} else {
-							ns = "" // empty array
+							n++ // count matches for possible warning
 						}
-						ns = choice
-					default:
-						choice = fmt.Sprintf("%v", value)
-						if strings.HasPrefix(choice, ns) {
-							ns = choice
-						} else {
-							if !nswarn {
-								s.Logf(logWARN, "warning '%s', prefix '%s' doesn't match 'rdf:type' %v\n", keyspec, ns, value)
-								nswarn = true
-							}
-							ns = ""
+					}
+					if choice == "" {
+						if !*nswarn {
+							log.Warnf("prefix '%s' not in 'rdf:type'", ns)
+							*nswarn = true
+						}
+					} else if n != 1 {
+						if !*nswarn {
+							log.Warnf("multiple 'rdf:type' (using '%v', please indicate): %v", ns, value)
+							*nswarn = true
 						}
+					} else {
+						ns = "" // empty array
 					}
-				} else {
-					if !nswarn {
-						s.Logf(logWARN, "warning '%s', no 'rdf:type' found\n", keyspec)
-						nswarn = true
+					ns = choice
+				default:
+					choice = fmt.Sprintf("%v", value)
+					if strings.HasPrefix(choice, ns) {
+						ns = choice
+					} else {
+						if !*nswarn {
+							log.Warnf("prefix '%s' doesn't match 'rdf:type' %v", ns, value)
+							*nswarn = true
+						}
+						ns = ""
 					}
-					ns = "" // no RDF type information, so setting blank namespace
 				}
 			} else {
-				// given a complete namespace
-			}
-			if strings.HasPrefix(ns, "~:") {
-				ns = ns[2:]
+				if !*nswarn {
+					log.Warnf("no 'rdf:type' found")
+					*nswarn = true
+				}
+				ns = "" // no RDF type information, so setting blank namespace
 			}
+		} else {
+			// given a complete namespace
+		}
+		if strings.HasPrefix(ns, "~:") {
+			ns = ns[2:]
+		}
 
-			ns = strings.Trim(ns, " ") // forced empty if namespace-parameter was %20 (i.e ' ')
-			if val, exist := entity[key]; exist {
-				if len(ns) != 0 && !strings.HasSuffix(ns, ":") {
-					ns += ":"
+		ns = strings.Trim(ns, " ") // forced empty if namespace-parameter was %20 (i.e ' ')
+		if val, exist := entity[key]; exist {
+			if len(ns) != 0 && !strings.HasSuffix(ns, ":") {
+				ns += ":"
+			}
+			switch value := val.(type) {
+			case []interface{}:
+				many := val.([]interface{})
+				shaids := make([]interface{}, len(many))
+				for i, v := range many {
+					shaid := uuid.NewSHA1(seed, []byte(fmt.Sprintf("%s%v", ns, v))) // format is "namespace:value" since non-empty namespace always includes ':'
+					metrics.add(metricMinted, "", 1)
+					s.record(log, name, seed, ns, v, key, shaid)
+					shaids[i] = fmt.Sprintf("%s%s", prefix, shaid.String())
+					log.Logkv(logDEBUG, "minted", "key", key, "index", i, "value", fmt.Sprintf("%s%v", ns, v), "uuid", shaid.String())
 				}
-				switch value := val.(type) {
-				case []interface{}:
-					many := val.([]interface{})
-					shaids := make([]interface{}, len(many))
-					for i, v := range many {
-						shaid := uuid.NewSHA1(s.options.seed, []byte(fmt.Sprintf("%s%v", ns, v))) // format is "namespace:value" since non-empty namespace always includes ':'
-						shaids[i] = fmt.Sprintf("%s%s", prefix, shaid.String())
-						s.Logf(logDEBUG, "[%s]:%d '%s%v'\t  ->  %s   (%x)\n", key, i, ns, v, shaid.String(), [16]byte(shaid))
-					}
-					entity[key] = shaids
-				default:
-					shaid := uuid.NewSHA1(s.options.seed, []byte(fmt.Sprintf("%s%v", ns, value))) // format is "namespace:value" since non-empty namespace always includes ':'
-					entity[key] = fmt.Sprintf("%s%s", prefix, shaid.String())
-					s.Logf(logDEBUG, "[%s] '%s%v'\t  ->  %s   (%x)\n", key, ns, value, shaid.String(), [16]byte(shaid))
+				entity[key] = shaids
+			default:
+				shaid := uuid.NewSHA1(seed, []byte(fmt.Sprintf("%s%v", ns, value))) // format is "namespace:value" since non-empty namespace always includes ':'
+				metrics.add(metricMinted, "", 1)
+				s.record(log, name, seed, ns, value, key, shaid)
+				entity[key] = fmt.Sprintf("%s%s", prefix, shaid.String())
+				log.Logkv(logDEBUG, "minted", "key", key, "value", fmt.Sprintf("%s%v", ns, value), "uuid", shaid.String())
+			}
+		}
+
+	}
+	return nil
+}
+
+// migration is a UUID minted of a value with the current and earlier seed versions, for aliasing UUIDs
+// of earlier versions, e.g. in '$ids'
+type migration struct {
+	Value    string            `json:"value"` // the string hashed, "namespace:value"
+	UUID     string            `json:"uuid"`
+	Version  string            `json:"version"`
+	Versions map[string]string `json:"versions"`
+	Aliases  []string          `json:"$ids"`
+	Matched  string            `json:"matched,omitempty"` // version of the UUID given with the value
+	Error    string            `json:"error,omitempty"`
+}
+
+// HandleMigrate receives URL POST requests with a JSON array of values, as objects with 'value', optional 'namespace'
+// and optional earlier 'uuid', or as the strings hashed ("namespace:value"), and returns the UUIDs of each value
+// minted with the current and earlier seed versions side by side
+func (s *Server) HandleMigrate(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	log, o := s.log(r), s.optionsOf(r)
+	if _, ok := negotiate(r, mediaJSON); !ok {
+		s.fail(w, r, newProblem(http.StatusNotAcceptable, problemNotAcceptable, "unsupported Accept '%s'", r.Header.Get("Accept")))
+		return
+	}
+	if _, ok := contentType(r, mediaJSON); !ok {
+		s.fail(w, r, newProblem(http.StatusUnsupportedMediaType, problemUnsupportedMedia, "unsupported Content-Type '%s'", r.Header.Get("Content-Type")))
+		return
+	}
+	var values []interface{}
+	if err := json.NewDecoder(r.Body).Decode(&values); err != nil {
+		s.fail(w, r, newProblem(http.StatusBadRequest, problemMalformedRequest, "expected JSON array of values, but got error: %s", err))
+		return
+	}
+
+	versions := make([]string, 0, len(o.versions))
+	for version := range o.versions {
+		versions = append(versions, version)
+	}
+	sort.Strings(versions)
+	migrations := make([]migration, len(values))
+	for i, val := range values {
+		var name, given string
+		switch v := val.(type) {
+		case string:
+			name = v
+		case map[string]interface{}:
+			value, exist := v["value"]
+			if !exist || value == nil {
+				s.fail(w, r, newProblem(http.StatusBadRequest, problemMalformedRequest, "expected value %d to have a 'value'", i).at(i, nil))
+				return
+			}
+			ns, _ := v["namespace"].(string)
+			name = hashedName(ns, value)
+			given, _ = v["uuid"].(string)
+		default:
+			s.fail(w, r, newProblem(http.StatusBadRequest, problemMalformedRequest, "expected value %d to be a string or an object, but got %T", i, val).at(i, nil))
+			return
+		}
+		current := uuid.NewSHA1(o.seed, []byte(name)).String()
+		m := migration{Value: name, UUID: current, Version: o.version, Versions: map[string]string{o.version: current}, Aliases: []string{}}
+		for _, version := range versions {
+			id := uuid.NewSHA1(o.versions[version], []byte(name)).String()
+			m.Versions[version] = id
+			m.Aliases = append(m.Aliases, "urn:uuid:"+id)
+		}
+		if len(given) != 0 {
+			id, err := parseMinted(given)
+			if err != nil {
+				m.Error = err.Error()
+			}
+			for version, v := range m.Versions {
+				if id.String() == v {
+					m.Matched = version
 				}
 			}
+			if err == nil && len(m.Matched) == 0 {
+				m.Error = fmt.Sprintf("UUID '%s' is not minted of '%s' by any seed version", given, name)
+			}
+		}
+		migrations[i] = m
+	}
+
+	data, err := json.Marshal(migrations)
+	if err != nil {
+		s.fail(w, r, newProblem(http.StatusInternalServerError, problemInternal, "%s", err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if _, err = w.Write(data); err != nil {
+		log.Errorf("error writing response: %s", err)
+	}
+}
 
+// explanation is a value minted to its UUID, and how
+type explanation struct {
+	Value     string `json:"value"`
+	Namespace string `json:"namespace"`
+	Hashed    string `json:"hashed"`         // the string hashed, "namespace:value"
+	Seed      string `json:"seed,omitempty"` // the name of a named seed
+	SeedUUID  string `json:"seed_uuid"`      // the UUID namespace of the seed
+	UUID      string `json:"uuid"`
+	URN       string `json:"urn"`
+	CGMES     string `json:"cgmes"` // the CGMES 'rdf:ID' form
+}
+
+// HandleUUID receives URL GET requests minting query parameter 'value' in query parameter 'namespace', or POST requests
+// minting a JSON array of string values, with the seed of the request as minting, and returns how each value is minted
+func (s *Server) HandleUUID(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	log, o := s.log(r), s.optionsOf(r)
+	if _, ok := negotiate(r, mediaJSON); !ok {
+		s.fail(w, r, newProblem(http.StatusNotAcceptable, problemNotAcceptable, "unsupported Accept '%s'", r.Header.Get("Accept")))
+		return
+	}
+	name, seed, err := o.seedFor(r)
+	if err != nil {
+		s.fail(w, r, newProblem(http.StatusBadRequest, problemUnknownSeed, "%s", err))
+		return
+	}
+	query := r.URL.Query()
+	var values []string
+	if r.Method == http.MethodGet {
+		if _, exist := query["value"]; !exist {
+			s.fail(w, r, newProblem(http.StatusBadRequest, problemMalformedRequest, "missing query parameter 'value'"))
+			return
 		}
-		// TODO: make a testing-only flag here to make entity not possible to marshal, for testing HTTP 503 below
-		var data []byte
-		if data, err = json.Marshal(entity); err != nil {
-			s.Errorf("%s\n", err)
-			w.WriteHeader(http.StatusInternalServerError)
+		values = []string{query.Get("value")}
+	} else {
+		if _, ok := contentType(r, mediaJSON); !ok {
+			s.fail(w, r, newProblem(http.StatusUnsupportedMediaType, problemUnsupportedMedia, "unsupported Content-Type '%s'", r.Header.Get("Content-Type")))
 			return
 		}
-		if total != 0 {
-			result.WriteRune(',')
+		if err = json.NewDecoder(r.Body).Decode(&values); err != nil {
+			s.fail(w, r, newProblem(http.StatusBadRequest, problemMalformedRequest, "expected JSON array of strings, but got error: %s", err))
+			return
 		}
-		strictEntity := make(map[string]interface{}, len(entity))
-		for k, v := range entity {
-			if k == "_id" || k == "" || k[0] != '_' {
-				strictEntity[k] = v
+	}
+
+	ns := query.Get("namespace")
+	explanations := make([]explanation, len(values))
+	for i, value := range values {
+		hashed := hashedName(ns, value)
+		id := uuid.NewSHA1(seed, []byte(hashed)) // as by mint
+		explanations[i] = explanation{Value: value, Namespace: ns, Hashed: hashed, Seed: name, SeedUUID: seed.String(),
+			UUID: id.String(), URN: id.URN(), CGMES: "_" + id.String()}
+	}
+	var data []byte
+	if r.Method == http.MethodGet {
+		data, err = json.Marshal(explanations[0])
+	} else {
+		data, err = json.Marshal(explanations)
+	}
+	if err != nil {
+		s.fail(w, r, newProblem(http.StatusInternalServerError, problemInternal, "%s", err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if _, err = w.Write(data); err != nil {
+		log.Errorf("error writing response: %s", err)
+	}
+}
+
+// hashedName returns the string hashed by minting a value of a namespace, i.e. "namespace:value", or the value
+// when the namespace is empty, in the same way as mint
+func hashedName(namespace string, value interface{}) string {
+	ns := strings.Trim(strings.TrimPrefix(namespace, "~:"), " ")
+	if len(ns) != 0 && !strings.HasSuffix(ns, ":") {
+		ns += ":"
+	}
+	return fmt.Sprintf("%s%v", ns, value)
+}
+
+// parseMinted returns the UUID of a minted identifier, given as a UUID, a 'urn:uuid:' URN or a CGMES '_' or '#_' label
+func parseMinted(id string) (uuid.UUID, error) {
+	id = strings.TrimPrefix(strings.TrimPrefix(strings.Trim(id, " "), "#"), "_")
+	u, err := uuid.Parse(id)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("expected a UUID, but got '%s'", id)
+	}
+	return u, nil
+}
+
+// record stores the source of a UUID minted of the value in the namespace ("namespace:" or empty) in the lookup store, if any
+func (s *Server) record(log *logger, name string, seed uuid.UUID, namespace string, value interface{}, field string, id uuid.UUID) {
+	if s.lookup == nil {
+		return
+	}
+	record := lookupRecord{UUID: id.String(), Seed: name, SeedUUID: seed.String(), Namespace: strings.TrimSuffix(namespace, ":"),
+		Value: fmt.Sprintf("%v", value), Field: field, FirstSeen: time.Now().UTC()}
+	if err := s.lookup.record(record, id); err != nil {
+		log.Errorf("error recording minted UUID in lookup store: %s", err)
+	}
+}
+
+// publish stores the minted entities or converted models held in the spool, a JSON object per line, in the entity
+// journal, if any
+func (s *Server) publish(log *logger, pending *spool) {
+	if s.journal == nil {
+		return
+	}
+	r, err := pending.reader()
+	if err == nil {
+		err = s.journal.append(r)
+	}
+	if err != nil {
+		log.Errorf("error writing entity journal: %s", err)
+	}
+}
+
+// HandleLookup receives URL GET requests of a minted UUID (also as URN or CGMES '_' label),
+// and returns the source it was minted of as recorded in the lookup store
+func (s *Server) HandleLookup(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	log := s.log(r)
+	if s.lookup == nil {
+		s.fail(w, r, newProblem(http.StatusNotImplemented, problemNotImplemented, "no lookup store configured"))
+		return
+	}
+	if _, ok := negotiate(r, mediaJSON); !ok {
+		s.fail(w, r, newProblem(http.StatusNotAcceptable, problemNotAcceptable, "unsupported Accept '%s'", r.Header.Get("Accept")))
+		return
+	}
+	id, err := parseMinted(p.ByName("uuid"))
+	if err != nil {
+		s.fail(w, r, newProblem(http.StatusBadRequest, problemMalformedRequest, "%s", err))
+		return
+	}
+	record, found, err := s.lookup.lookup(id)
+	if err != nil {
+		s.fail(w, r, newProblem(http.StatusInternalServerError, problemInternal, "%s", err))
+		return
+	}
+	if !found {
+		writeProblem(w, newProblem(http.StatusNotFound, problemNotFound, "UUID '%s' not found", id))
+		return
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		s.fail(w, r, newProblem(http.StatusInternalServerError, problemInternal, "%s", err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if _, err = w.Write(data); err != nil {
+		log.Errorf("error writing response: %s", err)
+	}
+}
+
+// HandleLookupExport receives URL GET requests, and streams all records of the lookup store as a JSON array,
+// or as NDJSON when accepted
+func (s *Server) HandleLookupExport(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	log := s.log(r)
+	if s.lookup == nil {
+		s.fail(w, r, newProblem(http.StatusNotImplemented, problemNotImplemented, "no lookup store configured"))
+		return
+	}
+	media, ok := negotiate(r, mediaJSON, mediaNDJSON)
+	if !ok {
+		s.fail(w, r, newProblem(http.StatusNotAcceptable, problemNotAcceptable, "unsupported Accept '%s'", r.Header.Get("Accept")))
+		return
+	}
+	out := newStream(w)
+	out.ndjson = media == mediaNDJSON
+	if err := s.lookup.export(out.write); err != nil {
+		out.fail(s.report(r, newProblem(http.StatusInternalServerError, problemInternal, "error exporting lookup store: %s", err)))
+		return
+	}
+	if err := out.close(); err != nil {
+		log.Errorf("error writing response: %s", err)
+	}
+}
+
+// HandleEntities receives URL GET requests of Sesam's JSON pull protocol, and streams the entities of the journal
+// after the sequence number of query parameter 'since' (at most 'limit' of them), each with its sequence number in
+// '_updated', as a JSON array or as NDJSON when accepted
+func (s *Server) HandleEntities(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	log := s.log(r)
+	if s.journal == nil {
+		s.fail(w, r, newProblem(http.StatusNotImplemented, problemNotImplemented, "no entity journal configured"))
+		return
+	}
+	media, ok := negotiate(r, mediaJSON, mediaNDJSON)
+	if !ok {
+		s.fail(w, r, newProblem(http.StatusNotAcceptable, problemNotAcceptable, "unsupported Accept '%s'", r.Header.Get("Accept")))
+		return
+	}
+	var since int64
+	if val := r.URL.Query().Get("since"); len(val) != 0 {
+		var err error
+		if since, err = strconv.ParseInt(val, 10, 64); err != nil || since < 0 {
+			s.fail(w, r, newProblem(http.StatusBadRequest, problemMalformedRequest, "expected query parameter 'since' to be a sequence number, but got '%s'", val))
+			return
+		}
+	}
+	limit := 0
+	if val := r.URL.Query().Get("limit"); len(val) != 0 {
+		var err error
+		if limit, err = strconv.Atoi(val); err != nil || limit < 0 {
+			s.fail(w, r, newProblem(http.StatusBadRequest, problemMalformedRequest, "expected query parameter 'limit' to be a count, but got '%s'", val))
+			return
+		}
+	}
+	out := newStream(w)
+	out.ndjson = media == mediaNDJSON
+	if err := s.journal.since(since, limit, out.write); err != nil {
+		out.fail(s.report(r, newProblem(http.StatusInternalServerError, problemInternal, "error reading entity journal: %s", err)))
+		return
+	}
+	if err := out.close(); err != nil {
+		log.Errorf("error writing response: %s", err)
+	}
+}
+
+// HandleValidate receives URL POST requests with an RDF body in RDF/XML (default), Turtle or N-Triples
+// as given by the Content-Type, and returns the SHACL validation report against the configured shapes
+func (s *Server) HandleValidate(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	log, o := s.log(r), s.optionsOf(r)
+	if o.shapes == nil {
+		s.fail(w, r, newProblem(http.StatusNotImplemented, problemNotImplemented, "no SHACL shapes configured for validation"))
+		return
+	}
+	if _, ok := negotiate(r, mediaJSON); !ok {
+		s.fail(w, r, newProblem(http.StatusNotAcceptable, problemNotAcceptable, "unsupported Accept '%s'", r.Header.Get("Accept")))
+		return
+	}
+	syntax, ok := contentType(r, mediaRDFXML, "application/xml", "text/xml", mediaTurtle, mediaNTriples)
+	if !ok {
+		s.fail(w, r, newProblem(http.StatusUnsupportedMediaType, problemUnsupportedMedia, "unsupported Content-Type '%s'", r.Header.Get("Content-Type")))
+		return
+	}
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		s.fail(w, r, newProblem(http.StatusBadRequest, problemMalformedRequest, "error reading request: %s", err))
+		return
+	}
+	triples, err := parseRDF(data, syntax, "")
+	if err != nil {
+		s.fail(w, r, newProblem(http.StatusBadRequest, problemValidation, "%s", err))
+		return
+	}
+	if data, err = json.Marshal(o.shapes.Validate(triples)); err != nil {
+		s.fail(w, r, newProblem(http.StatusInternalServerError, problemInternal, "%s", err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if _, err = w.Write(data); err != nil {
+		log.Errorf("error writing response: %s", err)
+	}
+}
+
+// HandleConvert receives URL POST requests with JSON body consisting of array of models (or NDJSON lines), each holding a JSON array
+// of CIM entities, and returns the models converted by Convert in the format chosen by the Accept header:
+// the models with RDF/XML as JSON (default) or NDJSON, or the statements of all models as a single
+// RDF/XML, Turtle, N-Triples or JSON-LD document. JSON and NDJSON models are streamed as they are decoded, unless
+// the batch belongs to a run being assembled, whose models are converted when its last batch has been read.
+func (s *Server) HandleConvert(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	log, o := s.log(r), s.optionsOf(r)
+	media, ok := negotiate(r, mediaJSON, mediaNDJSON, mediaRDFXML, mediaTurtle, mediaNTriples, mediaJSONLD)
+	if !ok {
+		s.fail(w, r, newProblem(http.StatusNotAcceptable, problemNotAcceptable, "unsupported Accept '%s'", r.Header.Get("Accept")))
+		return
+	}
+	input, ok := contentType(r, mediaJSON, mediaNDJSON)
+	if !ok {
+		s.fail(w, r, newProblem(http.StatusUnsupportedMediaType, problemUnsupportedMedia, "unsupported Content-Type '%s'", r.Header.Get("Content-Type")))
+		return
+	}
+
+	c, err := newConverter(o.convert, int(r.ContentLength))
+	if err != nil {
+		s.fail(w, r, newProblem(http.StatusInternalServerError, problemInternal, "%s", err))
+		return
+	}
+	defer c.close()
+	c.log = log
+	if c.difference && media != mediaJSON && media != mediaNDJSON && media != mediaRDFXML {
+		s.fail(w, r, newProblem(http.StatusNotAcceptable, problemNotAcceptable, "difference models are only available as RDF/XML, not '%s'", media))
+		return
+	}
+
+	assembling, err := s.assembles(r)
+	if err != nil {
+		s.fail(w, r, err.(*problem))
+		return
+	}
+	in := newBatchReader(r.Body, input == mediaNDJSON)
+	if failure := in.open(); failure != nil {
+		s.fail(w, r, failure)
+		return
+	}
+	var batch []map[string]json.RawMessage // of a run being assembled, else the models are converted as decoded
+	if assembling {
+		for in.more() {
+			var model map[string]json.RawMessage
+			if err := in.decode(&model); err != nil {
+				s.fail(w, r, newProblem(http.StatusBadRequest, problemNotAnObject, "expected JSON object, but got error: %s", err).at(len(batch), nil))
+				return
 			}
+			batch = append(batch, model)
 		}
-		// TODO: make another testing-only flag here to make strictEntity not possible to marshal, for testing HTTP 503 below
-		if data, err = json.Marshal(strictEntity); err != nil {
-			s.Errorf("%s\n", err)
-			w.WriteHeader(http.StatusInternalServerError)
+		if failure := in.close(); failure != nil {
+			s.fail(w, r, failure)
 			return
 		}
-		result.Write(data)
-		total++
+		if batch, err = s.assemble(r, c.jField, batch); err != nil {
+			s.fail(w, r, err.(*problem))
+			return
+		}
+	}
+	more := func(index int) bool {
+		if assembling {
+			return index < len(batch)
+		}
+		return in.more()
 	}
 
-	if _, err = dec.Token(); err != nil { // read closing bracket ']'
-		s.Errorf("expected JSON array closing bracket ']', but got error: %s\n", err)
-		w.WriteHeader(http.StatusBadRequest)
+	var out *stream // of the models as JSON or NDJSON, while the statements of all models are merged into one document
+	if media == mediaJSON || media == mediaNDJSON {
+		out = newStream(w)
+		out.ndjson = media == mediaNDJSON
+	}
+	fail := func(p *problem) {
+		if out != nil {
+			out.fail(s.report(r, p))
+		} else {
+			s.fail(w, r, p)
+		}
+	}
+	var graphs []*graph
+	var models spool // published when all have been converted
+	defer models.close()
+	index := 0
+	for ; more(index); index++ {
+		var model map[string]json.RawMessage
+		if assembling {
+			model = batch[index]
+		} else if err := in.decode(&model); err != nil {
+			fail(newProblem(http.StatusBadRequest, problemNotAnObject, "expected JSON object, but got error: %s", err).at(index, nil))
+			return
+		}
+		strictModel, g, err := c.convert(model)
+		if err != nil {
+			fail(newProblem(http.StatusBadRequest, problemConversion, "%s", err).at(index, modelID(model)))
+			return
+		}
+		if s.journal != nil {
+			data, err := json.Marshal(strictModel)
+			if err == nil {
+				_, err = models.Write(append(data, '\n'))
+			}
+			if err != nil {
+				fail(newProblem(http.StatusInternalServerError, problemInternal, "error holding model for journal: %s", err).at(index, modelID(model)))
+				return
+			}
+		}
+		if out == nil {
+			graphs = append(graphs, g)
+			continue
+		}
+		data, err := json.Marshal(strictModel)
+		if err != nil {
+			fail(newProblem(http.StatusInternalServerError, problemInternal, "%s", err).at(index, modelID(model)))
+			return
+		}
+		if err = out.write(data); err != nil {
+			log.Errorf("error writing response: %s", err)
+			return
+		}
+	}
+	if !assembling {
+		if failure := in.close(); failure != nil {
+			fail(failure)
+			return
+		}
+	}
+	s.publish(log, &models)
+	if s.journal != nil {
+		if err = s.journal.flush(); err != nil {
+			log.Errorf("error writing entity journal: %s", err)
+		}
+	}
+	if out != nil {
+		if err = out.close(); err != nil {
+			log.Errorf("error writing response: %s", err)
+		}
 		return
 	}
 
-	result.WriteRune(']')
+	var result bytes.Buffer
+	var data []byte
+	switch media {
+	case mediaRDFXML:
+		mergeGraphs(graphs).writeRDFXML(&result)
+		data = result.Bytes()
+	case mediaTurtle:
+		mergeGraphs(graphs).writeTurtle(&result)
+		data = result.Bytes()
+	case mediaNTriples:
+		mergeGraphs(graphs).writeNTriples(&result)
+		data = result.Bytes()
+	case mediaJSONLD:
+		if data, err = json.Marshal(mergeGraphs(graphs).jsonLD()); err != nil {
+			s.fail(w, r, newProblem(http.StatusInternalServerError, problemInternal, "%s", err))
+			return
+		}
+	}
+	w.Header().Set("Content-Type", media+"; charset=utf-8")
+	if _, err = w.Write(data); err != nil {
+		log.Errorf("error writing response: %s", err)
+	}
+}
+
+// HandleHealth responds that the process is alive
+func (s *Server) HandleHealth(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	fmt.Fprint(w, `{"status":"ok"}`)
+}
 
-	// TODO: test-case with a failing w-ResponseWriter (simulating client peer closed connection etc) returning error for testing HTTP 503 below
+// HandleReady responds whether the server is ready to serve requests, or 503 Service Unavailable
+func (s *Server) HandleReady(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
-	if _, err = fmt.Fprint(w, result.String()); err != nil {
-		s.Errorf("error writing response: %s\n", err)
-		w.WriteHeader(http.StatusInternalServerError)
+	if !s.Ready() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprint(w, `{"status":"unavailable"}`)
 		return
 	}
+	fmt.Fprint(w, `{"status":"ready"}`)
+}
+
+// HandleMetrics responds with the metrics in the Prometheus text exposition format
+func (s *Server) HandleMetrics(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	metrics.write(w)
+}
+
+// HandleNotFound responds 404 Not Found for disabled routes
+func (s *Server) HandleNotFound(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	http.NotFound(w, r)
 }
@@ -0,0 +1,296 @@
+package main_test
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "sesam-cimrdf"
+)
+
+var _ = Describe("Microservice minting", func() {
+
+	var (
+		server   *Server
+		response *httptest.ResponseRecorder
+	)
+
+	BeforeEach(func() {
+		server = NewTestServer(Options{"log": ioutil.Discard, "seed": "ginkgo"})
+		response = httptest.NewRecorder()
+	})
+
+	Describe("when streaming", func() {
+
+		It("accepts bodies of unknown length", func() {
+			body := io.MultiReader(strings.NewReader(`[{"_id": "a"},`), strings.NewReader(`{"_id": "b"}]`))
+			request, _ := http.NewRequest("POST", "/", body)
+			request.ContentLength = -1
+			server.ServeHTTP(response, request)
+			Expect(response.Code).To(Equal(200))
+			var entities []map[string]interface{}
+			Expect(json.Unmarshal(response.Body.Bytes(), &entities)).To(Succeed())
+			Expect(entities).To(HaveLen(2))
+		})
+
+		It("rejects an empty body", func() {
+			request, _ := http.NewRequest("POST", "/", strings.NewReader(""))
+			server.ServeHTTP(response, request)
+			Expect(response.Code).To(Equal(400))
+		})
+
+		It("rejects malformed input before the first entity", func() {
+			request, _ := http.NewRequest("POST", "/", strings.NewReader(`[5]`))
+			server.ServeHTTP(response, request)
+			Expect(response.Code).To(Equal(400))
+		})
+
+		It("leaves the array unterminated and reports late errors in the trailer", func() {
+			request, _ := http.NewRequest("POST", "/", strings.NewReader(`[{"_id": "a"}, 5, {"_id": "b"}]`))
+			server.ServeHTTP(response, request)
+			Expect(response.Code).To(Equal(200))
+			var entities []map[string]interface{}
+			Expect(json.Unmarshal(response.Body.Bytes(), &entities)).NotTo(Succeed())
+			Expect(json.Unmarshal(append(response.Body.Bytes(), ']'), &entities)).To(Succeed())
+			Expect(entities).To(HaveLen(1))
+			Expect(response.Result().Trailer.Get("X-Error")).To(ContainSubstring("expected JSON object"))
+		})
+
+		It("responds before the request body is complete", func() {
+			ts := httptest.NewServer(server)
+			defer ts.Close()
+			pr, pw := io.Pipe()
+			go pw.Write([]byte(`[{"_id": "a"},`))
+			resp, err := http.Post(ts.URL+"/", "application/json", pr)
+			Expect(err).To(BeNil())
+			defer resp.Body.Close()
+
+			dec := json.NewDecoder(resp.Body)
+			_, err = dec.Token()
+			Expect(err).To(BeNil())
+			var first map[string]interface{}
+			Expect(dec.Decode(&first)).To(Succeed())
+			Expect(first["_id"]).To(HaveLen(36))
+
+			go func() {
+				pw.Write([]byte(`{"_id": "b"}]`))
+				pw.Close()
+			}()
+			var second map[string]interface{}
+			Expect(dec.Decode(&second)).To(Succeed())
+			Expect(second["_id"]).To(HaveLen(36))
+			Expect(second["_id"]).NotTo(Equal(first["_id"]))
+		})
+	})
+
+	Describe("when picking named seeds", func() {
+
+		Mint := func(server *Server, url string, header string) *httptest.ResponseRecorder {
+			response := httptest.NewRecorder()
+			request, _ := http.NewRequest("POST", url, strings.NewReader(`[{"_id": "a"}]`))
+			if len(header) != 0 {
+				request.Header.Set("X-UUID-Seed", header)
+			}
+			server.ServeHTTP(response, request)
+			return response
+		}
+
+		BeforeEach(func() {
+			server = NewTestServer(Options{"log": ioutil.Discard, "seed": "ginkgo", "seeds": map[string]interface{}{"transmission": "ginkgo-transmission", "test": "ginkgo-test"}})
+		})
+
+		It("mints with the seed named by header or query parameter", func() {
+			expected := Mint(NewTestServer(Options{"log": ioutil.Discard, "seed": "ginkgo-transmission"}), "/_id", "").Body.String()
+			Expect(Mint(server, "/_id", "transmission").Body.String()).To(Equal(expected))
+			Expect(Mint(server, "/_id?seed=transmission", "").Body.String()).To(Equal(expected))
+			Expect(Mint(server, "/_id?seed=test", "").Body.String()).NotTo(Equal(expected))
+			Expect(Mint(server, "/_id", "").Body.String()).NotTo(Equal(expected))
+		})
+
+		It("rejects unknown seeds", func() {
+			Expect(Mint(server, "/_id", "distribution").Code).To(Equal(400))
+			Expect(Mint(server, "/_id?seed=distribution", "").Code).To(Equal(400))
+		})
+	})
+
+	Describe("when migrating seed versions", func() {
+
+		var migrations []map[string]interface{}
+
+		Migrate := func(input string) *httptest.ResponseRecorder {
+			response := httptest.NewRecorder()
+			request, _ := http.NewRequest("POST", "/migrate", strings.NewReader(input))
+			server.ServeHTTP(response, request)
+			migrations = nil
+			json.Unmarshal(response.Body.Bytes(), &migrations)
+			return response
+		}
+
+		BeforeEach(func() {
+			server = NewTestServer(Options{"log": ioutil.Discard, "seed": "ginkgo-2", "seed_version": "v2", "seed_versions": map[string]interface{}{"v1": "ginkgo"}})
+		})
+
+		It("returns the UUIDs of all versions side by side", func() {
+			v1 := uuid.NewSHA1(uuid.NewSHA1(uuid.Nil, []byte("ginkgo")), []byte("cim:Substation:a")).String()
+			v2 := uuid.NewSHA1(uuid.NewSHA1(uuid.Nil, []byte("ginkgo-2")), []byte("cim:Substation:a")).String()
+			Expect(Migrate(`[{"value": "a", "namespace": "~:cim:Substation"}, "cim:Substation:a"]`).Code).To(Equal(200))
+			Expect(migrations).To(HaveLen(2))
+			for _, m := range migrations {
+				Expect(m).To(HaveKeyWithValue("value", "cim:Substation:a"))
+				Expect(m).To(HaveKeyWithValue("uuid", v2))
+				Expect(m).To(HaveKeyWithValue("version", "v2"))
+				Expect(m).To(HaveKeyWithValue("versions", map[string]interface{}{"v1": v1, "v2": v2}))
+				Expect(m).To(HaveKeyWithValue("$ids", []interface{}{"urn:uuid:" + v1}))
+			}
+		})
+
+		It("mints with the current version", func() {
+			response := httptest.NewRecorder()
+			request, _ := http.NewRequest("POST", "/_id", strings.NewReader(`[{"_id": "a"}]`))
+			server.ServeHTTP(response, request)
+			Migrate(`["a"]`)
+			Expect(response.Body.String()).To(ContainSubstring(migrations[0]["uuid"].(string)))
+		})
+
+		It("tells the version of given UUIDs", func() {
+			v1 := uuid.NewSHA1(uuid.NewSHA1(uuid.Nil, []byte("ginkgo")), []byte("a")).String()
+			Expect(Migrate(`[{"value": "a", "uuid": "_` + v1 + `"}, {"value": "b", "uuid": "urn:uuid:` + v1 + `"}]`).Code).To(Equal(200))
+			Expect(migrations[0]).To(HaveKeyWithValue("matched", "v1"))
+			Expect(migrations[0]).NotTo(HaveKey("error"))
+			Expect(migrations[1]).NotTo(HaveKey("matched"))
+			Expect(migrations[1]).To(HaveKey("error"))
+		})
+
+		It("rejects values without value", func() {
+			Expect(Migrate(`[{"namespace": "cim:Substation"}]`).Code).To(Equal(400))
+			Expect(Migrate(`[5]`).Code).To(Equal(400))
+		})
+	})
+
+	Describe("when explaining UUIDs", func() {
+
+		BeforeEach(func() {
+			server = NewTestServer(Options{"log": ioutil.Discard, "seed": "ginkgo", "seeds": map[string]interface{}{"test": "ginkgo-test"}})
+		})
+
+		Explain := func(method string, url string, body string) *httptest.ResponseRecorder {
+			response := httptest.NewRecorder()
+			request, _ := http.NewRequest(method, url, strings.NewReader(body))
+			server.ServeHTTP(response, request)
+			return response
+		}
+
+		It("mints a value as the minting routes", func() {
+			minted := Explain("POST", "/_id/cim:Substation", `[{"_id": "a b"}]`)
+			var entities []map[string]interface{}
+			Expect(json.Unmarshal(minted.Body.Bytes(), &entities)).To(Succeed())
+
+			response := Explain("GET", "/uuid?value=a+b&namespace=cim:Substation", "")
+			Expect(response.Code).To(Equal(200))
+			var explanation map[string]interface{}
+			Expect(json.Unmarshal(response.Body.Bytes(), &explanation)).To(Succeed())
+			id := entities[0]["_id"].(string)
+			Expect(explanation).To(HaveKeyWithValue("uuid", id))
+			Expect(explanation).To(HaveKeyWithValue("urn", "urn:uuid:"+id))
+			Expect(explanation).To(HaveKeyWithValue("cgmes", "_"+id))
+			Expect(explanation).To(HaveKeyWithValue("hashed", "cim:Substation:a b"))
+			Expect(explanation).To(HaveKeyWithValue("seed_uuid", uuid.NewSHA1(uuid.Nil, []byte("ginkgo")).String()))
+		})
+
+		It("mints a batch of values with a named seed", func() {
+			response := Explain("POST", "/uuid?namespace=cim:Substation&seed=test", `["a", "b"]`)
+			Expect(response.Code).To(Equal(200))
+			var explanations []map[string]interface{}
+			Expect(json.Unmarshal(response.Body.Bytes(), &explanations)).To(Succeed())
+			Expect(explanations).To(HaveLen(2))
+			Expect(explanations[1]).To(HaveKeyWithValue("hashed", "cim:Substation:b"))
+			Expect(explanations[1]).To(HaveKeyWithValue("seed", "test"))
+			Expect(explanations[1]).To(HaveKeyWithValue("uuid", uuid.NewSHA1(uuid.NewSHA1(uuid.Nil, []byte("ginkgo-test")), []byte("cim:Substation:b")).String()))
+		})
+
+		It("rejects missing values and unknown seeds", func() {
+			Expect(Explain("GET", "/uuid?namespace=cim:Substation", "").Code).To(Equal(400))
+			Expect(Explain("GET", "/uuid?value=a&seed=other", "").Code).To(Equal(400))
+			Expect(Explain("POST", "/uuid", `[1]`).Code).To(Equal(400))
+		})
+	})
+
+	Describe("when failing partially", func() {
+
+		var entities []map[string]interface{}
+
+		Mint := func(server *Server, url string) *httptest.ResponseRecorder {
+			response := httptest.NewRecorder()
+			request, _ := http.NewRequest("POST", url, strings.NewReader(`[{"_id": "a", "rdf:type": ["cim:Substation"]}, 5, {"_id": "b", "rdf:type": [5]}, {"_id": "c", "rdf:type": "cim:Line"}]`))
+			server.ServeHTTP(response, request)
+			entities = nil
+			json.Unmarshal(response.Body.Bytes(), &entities)
+			return response
+		}
+
+		It("attaches failures to the entities and counts them", func() {
+			response := Mint(NewTestServer(Options{"log": ioutil.Discard, "seed": "ginkgo", "partial": true}), "/_id/cim:")
+			Expect(response.Code).To(Equal(200))
+			Expect(entities).To(HaveLen(4))
+			Expect(entities[0]["_id"]).To(HaveLen(36))
+			Expect(entities[0]).NotTo(HaveKey("_mint_error"))
+			Expect(entities[1]["_mint_error"]).To(HaveKeyWithValue("code", "not_an_object"))
+			Expect(entities[1]["_mint_error"]).To(HaveKeyWithValue("index", BeEquivalentTo(1)))
+			Expect(entities[1]).To(HaveKeyWithValue("_mint_index", BeEquivalentTo(1)))
+			Expect(entities[2]).To(HaveKeyWithValue("_id", "b"))
+			Expect(entities[2]["_mint_error"]).To(HaveKeyWithValue("code", "invalid_rdf_type"))
+			Expect(entities[2]["_mint_error"]).To(HaveKeyWithValue("keyspec", "_id"))
+			Expect(entities[2]["_mint_error"]).To(HaveKeyWithValue("_id", "b"))
+			Expect(entities[3]["_id"]).To(HaveLen(36))
+			Expect(response.Result().Header.Get("X-Mint-Succeeded")).To(Equal("2"))
+			Expect(response.Result().Header.Get("X-Mint-Failed")).To(Equal("2"))
+			Expect(response.Result().Header).NotTo(HaveKey("Trailer"))
+		})
+
+		It("sends the counts of large batches as headers, and fatal problems as headers", func() {
+			var batch []string
+			for i := 0; i < 50000; i++ {
+				batch = append(batch, `{"_id": "`+strconv.Itoa(i)+`", "name": "`+strings.Repeat("x", 50)+`"}`)
+			}
+			response := httptest.NewRecorder()
+			request, _ := http.NewRequest("POST", "/_id?partial=true", strings.NewReader("["+strings.Join(batch, ",")+",5]"))
+			server.ServeHTTP(response, request)
+			Expect(response.Code).To(Equal(200))
+			Expect(response.Body.Len()).To(BeNumerically(">", 3*1024*1024))
+			Expect(json.Unmarshal(response.Body.Bytes(), &entities)).To(Succeed())
+			Expect(entities).To(HaveLen(50001))
+			Expect(response.Result().Header.Get("X-Mint-Succeeded")).To(Equal("50000"))
+			Expect(response.Result().Header.Get("X-Mint-Failed")).To(Equal("1"))
+
+			response = httptest.NewRecorder()
+			request, _ = http.NewRequest("POST", "/_id?partial=true", strings.NewReader(`[{"_id": "a"}, {"_id": `))
+			server.ServeHTTP(response, request)
+			Expect(response.Code).To(Equal(200))
+			Expect(response.Result().Header.Get("X-Problem")).To(ContainSubstring("malformed_request"))
+			Expect(response.Result().Header.Get("X-Mint-Succeeded")).To(Equal("1"))
+		})
+
+		It("is opted into by query parameter", func() {
+			Expect(Mint(server, "/_id/cim:?partial=true").Code).To(Equal(200))
+			Expect(entities).To(HaveLen(4))
+			Expect(Mint(server, "/_id/cim:?partial=maybe").Code).To(Equal(400))
+		})
+
+		It("otherwise fails the batch at the first failure", func() {
+			response := Mint(server, "/_id/cim:")
+			Expect(entities).To(BeNil())
+			Expect(json.Unmarshal(append(response.Body.Bytes(), ']'), &entities)).To(Succeed())
+			Expect(entities).To(HaveLen(1))
+			Expect(response.Result().Trailer.Get("X-Problem")).To(ContainSubstring("not_an_object"))
+			Expect(response.Result().Trailer.Get("X-Mint-Failed")).To(Equal("1"))
+		})
+	})
+})
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// journalStride is how many entities of the journal share an offset in memory
+const journalStride = 1024
+
+// journal is an embedded on-disk log of the entities minted and models converted, for serving them to Sesam as a JSON
+// source: an append-only file of JSON lines, each entity with its sequence number in '_updated', counting from 1
+// and surviving restarts, and the time it was journaled in '_ts' (microseconds). Entities journaled longer ago than
+// the retention (unless 0) are dropped from the start of the file when compacted in the background every
+// compactInterval, but for the latest entity, so that sequence numbers go on after a restart. Memory holds only the
+// offset of every journalStride-th entity, from which the others are found by reading on.
+type journal struct {
+	mu        sync.Mutex
+	path      string
+	retention time.Duration
+	file      *os.File // appending
+	w         *bufio.Writer
+	first     int64         // the sequence number before the first entity of the file, of the entities dropped
+	n         int64         // the last sequence number
+	offsets   []int64       // of the entities of sequence numbers first+1, first+1+journalStride, ...
+	size      int64         // of the file, including buffered entities
+	stop      chan struct{} // closed to stop compacting
+	stopped   sync.WaitGroup
+}
+
+// journalLine is the bookkeeping of a journaled entity
+type journalLine struct {
+	Updated int64 `json:"_updated"`
+	TS      int64 `json:"_ts"`
+}
+
+// openJournal opens the journal of the file, creating it when missing and truncating a partially written last entity;
+// errors of compacting in the background are reported to the callback
+func openJournal(path string, retention time.Duration, report func(error)) (*journal, error) {
+	j := &journal{path: path, retention: retention, stop: make(chan struct{})}
+	if in, err := os.Open(path); err == nil {
+		br := bufio.NewReader(in)
+		for {
+			line, err := br.ReadBytes('\n')
+			var entity journalLine
+			if err != nil || json.Unmarshal(line, &entity) != nil || (j.n != 0 && entity.Updated != j.n+1) || entity.Updated < 1 {
+				break // a partially written or malformed last line
+			}
+			if j.n == 0 {
+				j.first, j.n = entity.Updated-1, entity.Updated-1
+			}
+			j.index(int64(len(line)))
+		}
+		in.Close()
+		if err = os.Truncate(path, j.size); err != nil {
+			return nil, fmt.Errorf("error opening entity journal: %s", err)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("error opening entity journal: %s", err)
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("error opening entity journal: %s", err)
+	}
+	j.file, j.w = file, bufio.NewWriter(file)
+	if retention > 0 {
+		if err = j.compact(time.Now()); err != nil {
+			j.file.Close()
+			return nil, err
+		}
+		j.stopped.Add(1)
+		go j.compacting(compactInterval, report)
+	}
+	return j, nil
+}
+
+// append stores the entities of the reader, a JSON object per line, with the next sequence numbers in '_updated'
+func (j *journal) append(r io.Reader) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	ts := strconv.FormatInt(time.Now().UnixNano()/int64(time.Microsecond), 10)
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadBytes('\n')
+		if err == io.EOF && len(line) == 0 {
+			return nil
+		}
+		if err != nil && err != io.EOF {
+			return err
+		}
+		var entity map[string]json.RawMessage
+		if err = json.Unmarshal(line, &entity); err != nil {
+			return err
+		}
+		entity["_updated"] = json.RawMessage(strconv.FormatInt(j.n+1, 10))
+		entity["_ts"] = json.RawMessage(ts)
+		data, err := json.Marshal(entity)
+		if err != nil {
+			return err
+		}
+		data = append(data, '\n')
+		if _, err = j.w.Write(data); err != nil {
+			return err
+		}
+		j.index(int64(len(data)))
+	}
+}
+
+// index counts an entity of the length appended at the end of the file
+func (j *journal) index(length int64) {
+	if (j.n-j.first)%journalStride == 0 {
+		j.offsets = append(j.offsets, j.size)
+	}
+	j.n++
+	j.size += length
+}
+
+// flush writes the buffered entities to the file
+func (j *journal) flush() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.w.Flush()
+}
+
+// since calls back with each entity line after the sequence number, or after the entities dropped, at most limit of
+// them unless 0, as stored until the call
+func (j *journal) since(since int64, limit int, callback func(line []byte) error) error {
+	j.mu.Lock()
+	err := j.w.Flush()
+	n, size := j.n, j.size
+	if since < j.first {
+		since = j.first
+	}
+	var offset int64
+	if since < n {
+		offset = j.offsets[(since-j.first)/journalStride]
+	}
+	skip := (since - j.first) % journalStride // the entities from the offset until since
+	var in *os.File
+	if err == nil && since < n {
+		in, err = os.Open(j.path) // while locked, so not a file compacted since
+	}
+	j.mu.Unlock()
+	if err != nil || since >= n {
+		return err
+	}
+	defer in.Close()
+	br := bufio.NewReader(io.NewSectionReader(in, offset, size-offset))
+	for ; skip > 0; skip-- {
+		if _, err = br.ReadBytes('\n'); err != nil {
+			return err
+		}
+	}
+	for i := 0; limit == 0 || i < limit; i++ {
+		line, err := br.ReadBytes('\n')
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err = callback(bytes.TrimSuffix(line, []byte("\n"))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// compacting compacts the journal every interval until stopped
+func (j *journal) compacting(interval time.Duration, report func(error)) {
+	defer j.stopped.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-j.stop:
+			return
+		case now := <-ticker.C:
+			if err := j.compact(now); err != nil && report != nil {
+				report(err)
+			}
+		}
+	}
+}
+
+// compact rewrites the file without the entities past retention at its start, and reopens it for appending. The
+// entities are copied without holding the lock, apart from those appended meanwhile, so that minting goes on while
+// compacting.
+func (j *journal) compact(now time.Time) error {
+	j.mu.Lock()
+	if err := j.w.Flush(); err != nil {
+		j.mu.Unlock()
+		return fmt.Errorf("error compacting entity journal: %s", err)
+	}
+	first, copied, c := j.first, j.size, &journalCopy{first: j.first, last: j.n}
+	j.mu.Unlock()
+	if c.last == c.first {
+		return nil // nothing to drop
+	}
+
+	tmp := j.path + ".tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("error compacting entity journal: %s", err)
+	}
+	c.w = bufio.NewWriter(out)
+	if err = j.copy(c, 0, copied, now); err != nil {
+		out.Close()
+		return err
+	}
+	if c.first == first {
+		out.Close()
+		os.Remove(tmp)
+		return nil // nothing past retention
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if err = j.w.Flush(); err == nil {
+		err = j.copy(c, copied, j.size, now) // the entities appended meanwhile
+	}
+	if err == nil {
+		err = c.w.Flush()
+	}
+	if err == nil {
+		err = out.Close()
+	} else {
+		out.Close()
+	}
+	if err == nil {
+		err = os.Rename(tmp, j.path)
+	}
+	if err != nil {
+		return fmt.Errorf("error compacting entity journal: %s", err)
+	}
+	j.file.Close()
+	if j.file, err = os.OpenFile(j.path, os.O_WRONLY|os.O_APPEND, 0644); err != nil {
+		return fmt.Errorf("error opening entity journal: %s", err)
+	}
+	j.w, j.first, j.offsets, j.size = bufio.NewWriter(j.file), c.first, c.offsets, c.size
+	return nil
+}
+
+// journalCopy is the compacted copy of the journal being written
+type journalCopy struct {
+	w       *bufio.Writer
+	first   int64 // the sequence number before the first entity copied
+	last    int64 // the sequence number of the latest entity, which is kept
+	kept    bool  // whether entities are copied, having passed those past retention
+	copied  int64 // the entities copied
+	offsets []int64
+	size    int64
+}
+
+// copy copies the entities from the offset until the end of the file to the compacted copy, dropping those past
+// retention until the first one within
+func (j *journal) copy(c *journalCopy, offset int64, end int64, now time.Time) error {
+	in, err := os.Open(j.path)
+	if err != nil {
+		return fmt.Errorf("error compacting entity journal: %s", err)
+	}
+	defer in.Close()
+	br := bufio.NewReader(io.NewSectionReader(in, offset, end-offset))
+	for {
+		line, err := br.ReadBytes('\n')
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("error compacting entity journal: %s", err)
+		}
+		if !c.kept {
+			var entity journalLine
+			if json.Unmarshal(line, &entity) == nil && entity.Updated < c.last &&
+				now.Sub(time.Unix(0, entity.TS*int64(time.Microsecond))) > j.retention {
+				c.first = entity.Updated
+				continue
+			}
+			c.kept = true
+		}
+		if c.copied%journalStride == 0 {
+			c.offsets = append(c.offsets, c.size)
+		}
+		if _, err = c.w.Write(line); err != nil {
+			return fmt.Errorf("error compacting entity journal: %s", err)
+		}
+		c.size += int64(len(line))
+		c.copied++
+	}
+}
+
+// close stops compacting, and flushes and closes the file
+func (j *journal) close() error {
+	close(j.stop)
+	j.stopped.Wait()
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if err := j.w.Flush(); err != nil {
+		j.file.Close()
+		return err
+	}
+	return j.file.Close()
+}
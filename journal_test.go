@@ -0,0 +1,167 @@
+package main_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "sesam-cimrdf"
+)
+
+var _ = Describe("Microservice entity journal", func() {
+
+	var (
+		dir    string
+		file   string
+		server *Server
+	)
+
+	Request := func(method string, url string, body string, accept string) *httptest.ResponseRecorder {
+		response := httptest.NewRecorder()
+		request, _ := http.NewRequest(method, url, strings.NewReader(body))
+		if len(accept) != 0 {
+			request.Header.Set("Accept", accept)
+		}
+		server.ServeHTTP(response, request)
+		return response
+	}
+
+	Pull := func(url string) []map[string]interface{} {
+		response := Request("GET", url, "", "")
+		Expect(response.Code).To(Equal(200))
+		var entities []map[string]interface{}
+		Expect(json.Unmarshal(response.Body.Bytes(), &entities)).To(Succeed())
+		return entities
+	}
+
+	BeforeEach(func() {
+		dir, _ = ioutil.TempDir("", "journal")
+		file = filepath.Join(dir, "entities.jsonl")
+		server = NewTestServer(Options{"log": ioutil.Discard, "seed": "ginkgo", "entities": file})
+	})
+	AfterEach(func() {
+		server.Close()
+		os.RemoveAll(dir)
+	})
+
+	It("serves minted entities and converted models since a sequence number", func() {
+		Expect(Request("POST", "/_id/cim:Substation", `[{"_id": "a"}, {"_id": "b"}]`, "").Code).To(Equal(200))
+		Expect(Request("POST", "/convert", `[{"_id": "m0", "cim:Model.all": []}]`, "").Code).To(Equal(200))
+
+		entities := Pull("/entities")
+		Expect(entities).To(HaveLen(3))
+		Expect(entities[0]["_id"]).To(HaveLen(36))
+		Expect(entities[2]).To(HaveKeyWithValue("_id", "m0"))
+		for i, entity := range entities {
+			Expect(entity).To(HaveKeyWithValue("_updated", BeEquivalentTo(i+1)))
+		}
+
+		entities = Pull("/entities?since=1&limit=1")
+		Expect(entities).To(HaveLen(1))
+		Expect(entities[0]).To(HaveKeyWithValue("_updated", BeEquivalentTo(2)))
+		Expect(Pull("/entities?since=3")).To(BeEmpty())
+
+		response := Request("GET", "/entities?since=1", "", "application/x-ndjson")
+		Expect(response.Code).To(Equal(200))
+		Expect(strings.Split(strings.TrimSpace(response.Body.String()), "\n")).To(HaveLen(2))
+	})
+
+	It("resumes the sequence after a restart", func() {
+		Request("POST", "/_id", `[{"_id": "a"}]`, "")
+		Expect(server.Close()).To(Succeed())
+		f, _ := os.OpenFile(file, os.O_WRONLY|os.O_APPEND, 0644)
+		f.Write([]byte(`{"_id": "partial`))
+		f.Close()
+
+		server = NewTestServer(Options{"log": ioutil.Discard, "seed": "ginkgo", "entities": file})
+		Request("POST", "/_id", `[{"_id": "b"}]`, "")
+		entities := Pull("/entities")
+		Expect(entities).To(HaveLen(2))
+		Expect(entities[1]).To(HaveKeyWithValue("_updated", BeEquivalentTo(2)))
+	})
+
+	It("skips failed entities and batches", func() {
+		Request("POST", "/_id?partial=true", `[5, {"_id": "a"}]`, "")
+		Expect(Request("POST", "/_id", `[{"_id": "b"}, 5, {"_id": "c"}]`, "").Body.String()).To(HavePrefix(`[{"_id":`))
+		Request("POST", "/_id", `[{"_id": "d"}, {"_id": "e"}`, "")
+		Request("POST", "/convert", `[{"_id": "m0", "cim:Model.all": []}, {"_id": "m1", "names": 5, "cim:Model.all": []}]`, "")
+		Expect(Pull("/entities")).To(HaveLen(1))
+	})
+
+	It("serves entities far into the journal, also after a restart", func() {
+		var batch []string
+		for i := 0; i < 2100; i++ {
+			batch = append(batch, `{"_id": "`+strconv.Itoa(i)+`"}`)
+		}
+		Expect(Request("POST", "/_id", "["+strings.Join(batch, ",")+"]", "").Code).To(Equal(200))
+		for i := 0; i < 2; i++ {
+			entities := Pull("/entities?since=2047&limit=3")
+			Expect(entities).To(HaveLen(3))
+			for k, entity := range entities {
+				Expect(entity).To(HaveKeyWithValue("_updated", BeEquivalentTo(2048+k)))
+			}
+			Expect(Pull("/entities?since=2098")).To(HaveLen(2))
+			Expect(server.Close()).To(Succeed())
+			server = NewTestServer(Options{"log": ioutil.Discard, "seed": "ginkgo", "entities": file})
+		}
+	})
+
+	It("journals batches larger than memory holds", func() {
+		var batch []string
+		for i := 0; i < 40000; i++ {
+			batch = append(batch, `{"_id": "`+strconv.Itoa(i)+`", "name": "`+strings.Repeat("x", 100)+`"}`)
+		}
+		Expect(Request("POST", "/_id", "["+strings.Join(batch, ",")+"]", "").Code).To(Equal(200))
+		entities := Pull("/entities?since=39998")
+		Expect(entities).To(HaveLen(2))
+		Expect(entities[1]).To(HaveKeyWithValue("_updated", BeEquivalentTo(40000)))
+	})
+
+	It("drops entities past retention, but for the latest one", func() {
+		Age := func(count int) {
+			Expect(server.Close()).To(Succeed())
+			data, _ := ioutil.ReadFile(file)
+			lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+			for i := 0; i < count; i++ {
+				var entity map[string]interface{}
+				json.Unmarshal([]byte(lines[i]), &entity)
+				entity["_ts"] = 1000000
+				line, _ := json.Marshal(entity)
+				lines[i] = string(line)
+			}
+			ioutil.WriteFile(file, []byte(strings.Join(lines, "\n")+"\n"), 0644)
+			server = NewTestServer(Options{"log": ioutil.Discard, "seed": "ginkgo", "entities": file, "entities_retention": "720h"})
+		}
+		Request("POST", "/_id", `[{"_id": "a"}, {"_id": "b"}, {"_id": "c"}]`, "")
+		Expect(Pull("/entities")[0]).To(HaveKey("_ts"))
+		Age(2)
+		entities := Pull("/entities")
+		Expect(entities).To(HaveLen(1))
+		Expect(entities[0]).To(HaveKeyWithValue("_updated", BeEquivalentTo(3)))
+		Expect(Pull("/entities?since=1")).To(HaveLen(1))
+
+		Age(1)
+		Expect(Pull("/entities")).To(HaveLen(1))
+		Request("POST", "/_id", `[{"_id": "d"}]`, "")
+		entities = Pull("/entities?since=2")
+		Expect(entities).To(HaveLen(2))
+		Expect(entities[1]).To(HaveKeyWithValue("_updated", BeEquivalentTo(4)))
+		Expect(Pull("/entities?since=3")).To(HaveLen(1))
+	})
+
+	It("rejects malformed parameters, and is not implemented without a journal", func() {
+		Expect(Request("GET", "/entities?since=x", "", "").Code).To(Equal(400))
+		Expect(Request("GET", "/entities?limit=-1", "", "").Code).To(Equal(400))
+		Expect(server.Close()).To(Succeed())
+		server = NewTestServer(Options{"log": ioutil.Discard, "seed": "ginkgo"})
+		Expect(Request("GET", "/entities", "", "").Code).To(Equal(501))
+	})
+})
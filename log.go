@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+)
+
+// logger writes structured log lines as JSON objects with time, level name (of logLevel), message and
+// the context fields of the logger, such as request ID, route, entity '_id' and keyspec
+type logger struct {
+	out    *logOutput
+	level  int
+	fields []interface{} // alternating keys and values
+}
+
+// logOutput serializes the lines of loggers sharing a writer
+type logOutput struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func newLogger(w io.Writer, level int) *logger {
+	return &logger{out: &logOutput{w: w}, level: level}
+}
+
+// levelOf returns the log level of its name in logLevel, or the default when empty or unknown
+func levelOf(name string, defaultLevel int) int {
+	name = strings.ToUpper(strings.Trim(name, " "))
+	for k, val := range logLevel {
+		if val == name {
+			return k
+		}
+	}
+	return defaultLevel
+}
+
+// loggerOf returns the logger of options 'logger', or of options 'log' (io.Writer) and 'level' (name),
+// defaulting to ERROR level on standard error
+func loggerOf(opt Options) *logger {
+	if l, ok := opt["logger"].(*logger); ok && l != nil {
+		return l
+	}
+	var w io.Writer = os.Stderr
+	if val, ok := opt["log"].(io.Writer); ok && val != nil {
+		w = val
+	}
+	level := logERROR
+	if val, exist := opt["level"]; exist && val != nil {
+		level = levelOf(fmt.Sprintf("%v", val), logERROR)
+	}
+	return newLogger(w, level)
+}
+
+// With returns a logger adding the key and value pairs to each line
+func (l *logger) With(kv ...interface{}) *logger {
+	fields := make([]interface{}, 0, len(l.fields)+len(kv))
+	fields = append(append(fields, l.fields...), kv...)
+	return &logger{out: l.out, level: l.level, fields: fields}
+}
+
+// Enabled tells whether lines of the level are written
+func (l *logger) Enabled(level int) bool {
+	return l.level >= level
+}
+
+// Logf writes a line of the level with the formatted message and the additional key and value pairs
+func (l *logger) Logf(level int, format string, args ...interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+	l.write(level, strings.TrimRight(fmt.Sprintf(format, args...), "\n"), nil)
+}
+
+// Logkv writes a line of the level with the message and the additional key and value pairs
+func (l *logger) Logkv(level int, msg string, kv ...interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+	l.write(level, msg, kv)
+}
+
+// Errorf writes a line of ERROR level
+func (l *logger) Errorf(format string, args ...interface{}) {
+	l.Logf(logERROR, format, args...)
+}
+
+// Warnf writes a line of WARN level
+func (l *logger) Warnf(format string, args ...interface{}) {
+	l.Logf(logWARN, format, args...)
+}
+
+// Debugf writes a line of DEBUG level
+func (l *logger) Debugf(format string, args ...interface{}) {
+	l.Logf(logDEBUG, format, args...)
+}
+
+func (l *logger) write(level int, msg string, kv []interface{}) {
+	var line strings.Builder
+	line.WriteString(`{"time":`)
+	writeJSON(&line, time.Now().UTC().Format(time.RFC3339Nano))
+	line.WriteString(`,"level":`)
+	name := "CUSTOM"
+	if level >= 0 && level < len(logLevel) {
+		name = logLevel[level]
+	}
+	writeJSON(&line, name)
+	line.WriteString(`,"msg":`)
+	writeJSON(&line, msg)
+	for _, fields := range [][]interface{}{l.fields, kv} {
+		for i := 0; i+1 < len(fields); i += 2 {
+			line.WriteRune(',')
+			writeJSON(&line, fmt.Sprintf("%v", fields[i]))
+			line.WriteRune(':')
+			writeJSON(&line, fields[i+1])
+		}
+	}
+	line.WriteString("}\n")
+	l.out.mu.Lock()
+	defer l.out.mu.Unlock()
+	io.WriteString(l.out.w, line.String())
+}
+
+func writeJSON(b *strings.Builder, v interface{}) {
+	if raw, ok := v.(json.RawMessage); ok && json.Valid(raw) {
+		b.Write(raw)
+		return
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		data, _ = json.Marshal(fmt.Sprintf("%v", v))
+	}
+	b.Write(data)
+}
+
+type loggerKey struct{}
+
+// headerRequestID is the HTTP header of the request ID, taken from the request (or its Sesam query parameter
+// 'request_id') or else generated, and echoed in the response
+const headerRequestID string = "X-Request-ID"
+
+// sesamParameters are the query parameters of Sesam's HTTP transform logged with each request
+var sesamParameters = []string{"pipe_id", "since", "is_first", "is_last"}
+
+// trace wraps the handle of a route to log with the request ID, route and Sesam parameters of each request,
+// and to serve it with the options of its Sesam 'pipe_id', if configured
+func (s *Server) trace(route string, handle httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		query := r.URL.Query()
+		id := strings.Trim(r.Header.Get(headerRequestID), " ")
+		if len(id) == 0 {
+			id = strings.Trim(query.Get("request_id"), " ")
+		}
+		if len(id) == 0 || len(id) > 128 {
+			id = uuid.New().String()
+		}
+		w.Header().Set(headerRequestID, id)
+		o := s.current() // the request is served with the options current at its start, also when reloaded
+		if po, exist := o.pipes[query.Get("pipe_id")]; exist {
+			o = po
+		}
+		kv := []interface{}{"request_id", id, "route", route}
+		for _, key := range sesamParameters {
+			if val, exist := query[key]; exist {
+				kv = append(kv, key, val[0])
+			}
+		}
+		ctx := context.WithValue(context.WithValue(r.Context(), optionsKey{}, o), loggerKey{}, o.logger.With(kv...))
+		handle(w, r.WithContext(ctx), p)
+	}
+}
+
+// log returns the logger of the request, or of the server outside of requests
+func (s *Server) log(r *http.Request) *logger {
+	if r != nil {
+		if l, ok := r.Context().Value(loggerKey{}).(*logger); ok {
+			return l
+		}
+	}
+	return s.optionsOf(r).logger
+}
@@ -0,0 +1,114 @@
+package main_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "sesam-cimrdf"
+)
+
+// NewLines returns the JSON log lines
+func NewLines(buf *bytes.Buffer) []map[string]interface{} {
+	var lines []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var fields map[string]interface{}
+		Expect(json.Unmarshal([]byte(line), &fields)).To(Succeed(), line)
+		lines = append(lines, fields)
+	}
+	return lines
+}
+
+var _ = Describe("Microservice structured logging", func() {
+
+	var (
+		buf      bytes.Buffer
+		server   *Server
+		response *httptest.ResponseRecorder
+	)
+
+	BeforeEach(func() {
+		buf.Reset()
+		server = NewTestServer(Options{"log": &buf, "level": "DEBUG", "seed": "ginkgo"})
+		buf.Reset()
+		response = httptest.NewRecorder()
+	})
+
+	It("logs minting with request ID, route, entity _id and keyspec", func() {
+		request, _ := http.NewRequest("POST", "/_id", strings.NewReader(`[{"_id": "a"}]`))
+		request.Header.Set("X-Request-ID", "req-1")
+		server.ServeHTTP(response, request)
+		Expect(response.Code).To(Equal(200))
+		Expect(response.Header().Get("X-Request-ID")).To(Equal("req-1"))
+
+		lines := NewLines(&buf)
+		Expect(lines[0]).To(HaveKeyWithValue("level", "WARN"))
+		Expect(lines[0]).To(HaveKeyWithValue("msg", "no 'rdf:type' found"))
+		Expect(lines[0]).To(HaveKeyWithValue("request_id", "req-1"))
+		Expect(lines[0]).To(HaveKeyWithValue("route", "/:field"))
+		Expect(lines[0]).To(HaveKeyWithValue("_id", "a"))
+		Expect(lines[0]).To(HaveKeyWithValue("keyspec", "_id"))
+		Expect(lines[1]).To(HaveKeyWithValue("level", "DEBUG"))
+		Expect(lines[1]).To(HaveKey("uuid"))
+	})
+
+	It("generates request IDs", func() {
+		request, _ := http.NewRequest("POST", "/_id", strings.NewReader(`[]`))
+		server.ServeHTTP(response, request)
+		Expect(response.Header().Get("X-Request-ID")).To(HaveLen(36))
+	})
+
+	It("logs the Sesam parameters and echoes the Sesam request ID", func() {
+		request, _ := http.NewRequest("POST", "/_id?request_id=sesam-1&pipe_id=substations&since=42&is_first=true&is_last=false", strings.NewReader(`[{"_id": "a"}]`))
+		server.ServeHTTP(response, request)
+		Expect(response.Code).To(Equal(200))
+		Expect(response.Header().Get("X-Request-ID")).To(Equal("sesam-1"))
+
+		lines := NewLines(&buf)
+		Expect(lines[0]).To(HaveKeyWithValue("request_id", "sesam-1"))
+		Expect(lines[0]).To(HaveKeyWithValue("pipe_id", "substations"))
+		Expect(lines[0]).To(HaveKeyWithValue("since", "42"))
+		Expect(lines[0]).To(HaveKeyWithValue("is_first", "true"))
+		Expect(lines[0]).To(HaveKeyWithValue("is_last", "false"))
+	})
+
+	It("logs entities skipped by conversion", func() {
+		input := `[{"_id": "m", "cim:Model.all": [{"_id": "urn:uuid:bad", "rdf:type": "~:cim:Substation"}]}]`
+		request, _ := http.NewRequest("POST", "/convert", strings.NewReader(input))
+		request.Header.Set("X-Request-ID", "req-2")
+		server.ServeHTTP(response, request)
+		Expect(response.Code).To(Equal(200))
+
+		lines := NewLines(&buf)
+		Expect(lines).To(HaveLen(1))
+		Expect(lines[0]).To(HaveKeyWithValue("level", "ERROR"))
+		Expect(lines[0]["msg"]).To(HavePrefix("skipping entity: expected '_id'"))
+		Expect(lines[0]).To(HaveKeyWithValue("request_id", "req-2"))
+		Expect(lines[0]).To(HaveKeyWithValue("route", "/convert"))
+		Expect(lines[0]).To(HaveKeyWithValue("_id", "urn:uuid:bad"))
+		Expect(lines[0]).To(HaveKey("entity"))
+	})
+
+	It("logs conversion on its own", func() {
+		input := `[{"json": [{"_id": "urn:uuid:bad"}]}]`
+		rw := NewInputOutput(input, "", &bytes.Buffer{})
+		var log bytes.Buffer
+		Expect(Convert(rw, &Options{"json": "json", "log": &log, "level": "ERROR"}, 0)).To(Succeed())
+		lines := NewLines(&log)
+		Expect(lines[0]).To(HaveKeyWithValue("_id", "urn:uuid:bad"))
+		Expect(lines[0]).NotTo(HaveKey("entity"))
+	})
+
+	It("keeps quiet below its level", func() {
+		quiet := NewTestServer(Options{"log": &buf, "level": "OFF", "seed": "ginkgo"})
+		request, _ := http.NewRequest("POST", "/_id", strings.NewReader(`[5]`))
+		quiet.ServeHTTP(response, request)
+		Expect(response.Code).To(Equal(400))
+		Expect(buf.Len()).To(Equal(0))
+	})
+})
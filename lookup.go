@@ -0,0 +1,295 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// compactInterval is how often at most the lookup store drops the records past retention
+const compactInterval = time.Hour
+
+// lookupRecord is the source of a minted UUID
+type lookupRecord struct {
+	UUID      string    `json:"uuid"`
+	Seed      string    `json:"seed,omitempty"` // the name of a named seed
+	SeedUUID  string    `json:"seed_uuid"`
+	Namespace string    `json:"namespace"`
+	Value     string    `json:"value"`
+	Field     string    `json:"field"`
+	FirstSeen time.Time `json:"first_seen"`
+}
+
+// lookupEntry is the index entry of a record in the store file
+type lookupEntry struct {
+	offset    int64
+	firstSeen int64 // Unix seconds
+}
+
+// lookupStore is an embedded on-disk store of the sources of minted UUIDs: an append-only file of JSON lines,
+// indexed in memory by UUID. Records first seen longer ago than the retention (unless 0) are dropped when compacted
+// in the background every compactInterval, and recorded anew when minted again.
+type lookupStore struct {
+	mu        sync.Mutex
+	path      string
+	retention time.Duration
+	file      *os.File // appending
+	w         *bufio.Writer
+	size      int64 // of the file, including buffered records
+	index     map[uuid.UUID]lookupEntry
+	stop      chan struct{} // closed to stop compacting
+	stopped   sync.WaitGroup
+}
+
+// openLookup opens the store of the file, creating it when missing and dropping a partially written last record;
+// errors of compacting in the background are reported to the callback
+func openLookup(path string, retention time.Duration, report func(error)) (*lookupStore, error) {
+	ls := &lookupStore{path: path, retention: retention, stop: make(chan struct{})}
+	if err := ls.compact(time.Now()); err != nil {
+		return nil, err
+	}
+	if retention > 0 {
+		ls.stopped.Add(1)
+		go ls.compacting(compactInterval, report)
+	}
+	return ls, nil
+}
+
+// compacting compacts the store every interval until stopped
+func (ls *lookupStore) compacting(interval time.Duration, report func(error)) {
+	defer ls.stopped.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ls.stop:
+			return
+		case now := <-ticker.C:
+			if err := ls.compact(now); err != nil && report != nil {
+				report(err)
+			}
+		}
+	}
+}
+
+// expired reports whether a record first seen at the Unix time is past retention
+func (ls *lookupStore) expired(firstSeen int64, now time.Time) bool {
+	return ls.retention > 0 && now.Sub(time.Unix(firstSeen, 0)) > ls.retention
+}
+
+// compact rewrites the file with the records not past retention, and reopens it for appending. The records are
+// copied without holding the lock, apart from those recorded meanwhile, so that minting goes on while compacting.
+func (ls *lookupStore) compact(now time.Time) error {
+	ls.mu.Lock()
+	copied := int64(-1) // the whole file, when opening
+	if ls.file != nil {
+		if err := ls.w.Flush(); err != nil {
+			ls.mu.Unlock()
+			return fmt.Errorf("error compacting lookup store: %s", err)
+		}
+		copied = ls.size
+	}
+	ls.mu.Unlock()
+
+	tmp := ls.path + ".tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("error compacting lookup store: %s", err)
+	}
+	c := &lookupCopy{w: bufio.NewWriter(out), index: map[uuid.UUID]lookupEntry{}}
+	if err = ls.copy(c, 0, copied, now); err != nil {
+		out.Close()
+		return err
+	}
+
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	if ls.file != nil {
+		if err = ls.w.Flush(); err == nil {
+			err = ls.copy(c, copied, ls.size, now) // the records recorded meanwhile
+		}
+		if err != nil {
+			out.Close()
+			return err
+		}
+	}
+	if err = c.w.Flush(); err == nil {
+		err = out.Close()
+	}
+	if err == nil {
+		err = os.Rename(tmp, ls.path)
+	}
+	if err != nil {
+		return fmt.Errorf("error compacting lookup store: %s", err)
+	}
+	if ls.file != nil {
+		ls.file.Close()
+	}
+	if ls.file, err = os.OpenFile(ls.path, os.O_WRONLY|os.O_APPEND, 0644); err != nil {
+		return fmt.Errorf("error opening lookup store: %s", err)
+	}
+	ls.w, ls.size, ls.index = bufio.NewWriter(ls.file), c.size, c.index
+	return nil
+}
+
+// lookupCopy is the compacted copy of the store being written
+type lookupCopy struct {
+	w     *bufio.Writer
+	index map[uuid.UUID]lookupEntry
+	size  int64
+}
+
+// copy copies the records not past retention from the offset until the end (or the end of file when negative)
+// of the file to the compacted copy
+func (ls *lookupStore) copy(c *lookupCopy, offset int64, end int64, now time.Time) error {
+	in, err := os.Open(ls.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error opening lookup store: %s", err)
+	}
+	defer in.Close()
+	var r io.Reader = in
+	if end >= 0 {
+		r = io.NewSectionReader(in, offset, end-offset)
+	}
+	err = scanLookup(r, func(line []byte, record lookupRecord, id uuid.UUID) error {
+		entry := lookupEntry{offset: c.size, firstSeen: record.FirstSeen.Unix()}
+		if _, exist := c.index[id]; exist || ls.expired(entry.firstSeen, now) {
+			return nil
+		}
+		c.index[id] = entry
+		n, err := c.w.Write(line)
+		c.size += int64(n)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("error compacting lookup store: %s", err)
+	}
+	return nil
+}
+
+// scanLookup calls back with each record line of the reader (including its newline), stopping at the first
+// malformed or partially written line
+func scanLookup(r io.Reader, callback func(line []byte, record lookupRecord, id uuid.UUID) error) error {
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadBytes('\n')
+		if err == io.EOF {
+			return nil // a last line without newline was partially written
+		}
+		if err != nil {
+			return err
+		}
+		var record lookupRecord
+		if json.Unmarshal(line, &record) != nil {
+			return nil
+		}
+		id, err := uuid.Parse(record.UUID)
+		if err != nil {
+			return nil
+		}
+		if err = callback(line, record, id); err != nil {
+			return err
+		}
+	}
+}
+
+// record stores the source of a minted UUID unless already stored
+func (ls *lookupStore) record(record lookupRecord, id uuid.UUID) error {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	if _, exist := ls.index[id]; exist {
+		return nil
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if _, err = ls.w.Write(data); err != nil {
+		return err
+	}
+	ls.index[id] = lookupEntry{offset: ls.size, firstSeen: record.FirstSeen.Unix()}
+	ls.size += int64(len(data))
+	return nil
+}
+
+// flush writes the buffered records to the file
+func (ls *lookupStore) flush() error {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	return ls.w.Flush()
+}
+
+// lookup returns the source of a minted UUID, or false when not stored or past retention
+func (ls *lookupStore) lookup(id uuid.UUID) (lookupRecord, bool, error) {
+	var record lookupRecord
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	entry, exist := ls.index[id]
+	if !exist || ls.expired(entry.firstSeen, time.Now()) {
+		return record, false, nil
+	}
+	if err := ls.w.Flush(); err != nil {
+		return record, false, err
+	}
+	in, err := os.Open(ls.path)
+	if err != nil {
+		return record, false, err
+	}
+	defer in.Close()
+	line, err := bufio.NewReader(io.NewSectionReader(in, entry.offset, ls.size-entry.offset)).ReadBytes('\n')
+	if err == nil {
+		err = json.Unmarshal(line, &record)
+	}
+	if err != nil {
+		return record, false, fmt.Errorf("error reading lookup store at %d: %s", entry.offset, err)
+	}
+	return record, true, nil
+}
+
+// export calls back with each record line not past retention, as stored until the call; the file is opened while
+// holding the lock, so that its size matches it even when compacted meanwhile
+func (ls *lookupStore) export(callback func(line []byte) error) error {
+	ls.mu.Lock()
+	err := ls.w.Flush()
+	size := ls.size
+	var in *os.File
+	if err == nil {
+		in, err = os.Open(ls.path)
+	}
+	ls.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	now := time.Now()
+	return scanLookup(io.NewSectionReader(in, 0, size), func(line []byte, record lookupRecord, id uuid.UUID) error {
+		if ls.expired(record.FirstSeen.Unix(), now) {
+			return nil
+		}
+		return callback(bytes.TrimSuffix(line, []byte("\n")))
+	})
+}
+
+// close stops compacting, and flushes and closes the file
+func (ls *lookupStore) close() error {
+	close(ls.stop)
+	ls.stopped.Wait()
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	if err := ls.w.Flush(); err != nil {
+		ls.file.Close()
+		return err
+	}
+	return ls.file.Close()
+}
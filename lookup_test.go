@@ -0,0 +1,131 @@
+package main_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "sesam-cimrdf"
+)
+
+var _ = Describe("Microservice reverse lookup", func() {
+
+	var (
+		dir    string
+		file   string
+		server *Server
+	)
+
+	Request := func(method string, url string, body string, accept string) *httptest.ResponseRecorder {
+		response := httptest.NewRecorder()
+		request, _ := http.NewRequest(method, url, strings.NewReader(body))
+		if len(accept) != 0 {
+			request.Header.Set("Accept", accept)
+		}
+		server.ServeHTTP(response, request)
+		return response
+	}
+
+	Mint := func(body string) []map[string]interface{} {
+		response := Request("POST", "/_id/cim:Substation", body, "")
+		Expect(response.Code).To(Equal(200))
+		var entities []map[string]interface{}
+		Expect(json.Unmarshal(response.Body.Bytes(), &entities)).To(Succeed())
+		return entities
+	}
+
+	BeforeEach(func() {
+		dir, _ = ioutil.TempDir("", "lookup")
+		file = filepath.Join(dir, "lookup.jsonl")
+		server = NewTestServer(Options{"log": ioutil.Discard, "seed": "ginkgo", "lookup": file})
+	})
+	AfterEach(func() {
+		server.Close()
+		os.RemoveAll(dir)
+	})
+
+	It("looks up the source of minted UUIDs", func() {
+		id := Mint(`[{"_id": "a"}]`)[0]["_id"].(string)
+		for _, url := range []string{"/lookup/" + id, "/lookup/_" + id, "/lookup/urn:uuid:" + id} {
+			response := Request("GET", url, "", "")
+			Expect(response.Code).To(Equal(200))
+			var record map[string]interface{}
+			Expect(json.Unmarshal(response.Body.Bytes(), &record)).To(Succeed())
+			Expect(record).To(HaveKeyWithValue("uuid", id))
+			Expect(record).To(HaveKeyWithValue("namespace", "cim:Substation"))
+			Expect(record).To(HaveKeyWithValue("value", "a"))
+			Expect(record).To(HaveKeyWithValue("field", "_id"))
+			Expect(record).To(HaveKey("first_seen"))
+		}
+		Expect(Request("GET", "/lookup/00000000-0000-0000-0000-000000000001", "", "").Code).To(Equal(404))
+		Expect(Request("GET", "/lookup/not-a-uuid", "", "").Code).To(Equal(400))
+	})
+
+	It("exports the records once each, also after a restart", func() {
+		Mint(`[{"_id": "a"}, {"_id": "b"}]`)
+		Mint(`[{"_id": "a"}]`)
+		Expect(server.Close()).To(Succeed())
+		server = NewTestServer(Options{"log": ioutil.Discard, "seed": "ginkgo", "lookup": file})
+
+		response := Request("GET", "/lookup", "", "")
+		Expect(response.Code).To(Equal(200))
+		var records []map[string]interface{}
+		Expect(json.Unmarshal(response.Body.Bytes(), &records)).To(Succeed())
+		Expect(records).To(HaveLen(2))
+		Expect(records[1]).To(HaveKeyWithValue("value", "b"))
+
+		response = Request("GET", "/lookup", "", "application/x-ndjson")
+		Expect(response.Code).To(Equal(200))
+		Expect(strings.Split(strings.TrimSpace(response.Body.String()), "\n")).To(HaveLen(2))
+	})
+
+	It("drops records past retention", func() {
+		id := Mint(`[{"_id": "a"}]`)[0]["_id"].(string)
+		Expect(server.Close()).To(Succeed())
+		data, _ := ioutil.ReadFile(file)
+		ioutil.WriteFile(file, []byte(strings.Replace(string(data), `"first_seen":"20`, `"first_seen":"19`, 1)+`{"uuid": "partial`), 0644)
+
+		server = NewTestServer(Options{"log": ioutil.Discard, "seed": "ginkgo", "lookup": file, "lookup_retention": "720h"})
+		Expect(Request("GET", "/lookup/"+id, "", "").Code).To(Equal(404))
+		Mint(`[{"_id": "a"}]`)
+		Expect(Request("GET", "/lookup/"+id, "", "").Code).To(Equal(200))
+	})
+
+	It("exports while minting with retention", func() {
+		Expect(server.Close()).To(Succeed())
+		server = NewTestServer(Options{"log": ioutil.Discard, "seed": "ginkgo", "lookup": file, "lookup_retention": "720h"})
+		done := make(chan struct{})
+		go func() {
+			defer GinkgoRecover()
+			defer close(done)
+			for i := 0; i < 20; i++ {
+				Mint(`[{"_id": "` + strings.Repeat("a", i+1) + `"}]`)
+			}
+		}()
+		for i := 0; i < 20; i++ {
+			response := Request("GET", "/lookup", "", "")
+			Expect(response.Code).To(Equal(200))
+			var records []map[string]interface{}
+			Expect(json.Unmarshal(response.Body.Bytes(), &records)).To(Succeed())
+		}
+		<-done
+		Expect(server.Close()).To(Succeed())
+		server = NewTestServer(Options{"log": ioutil.Discard, "seed": "ginkgo", "lookup": file, "lookup_retention": "720h"})
+		var records []map[string]interface{}
+		Expect(json.Unmarshal(Request("GET", "/lookup", "", "").Body.Bytes(), &records)).To(Succeed())
+		Expect(records).To(HaveLen(20))
+	})
+
+	It("is not implemented without a store", func() {
+		Expect(server.Close()).To(Succeed())
+		server = NewTestServer(Options{"log": ioutil.Discard, "seed": "ginkgo"})
+		Expect(Request("GET", "/lookup/00000000-0000-0000-0000-000000000001", "", "").Code).To(Equal(501))
+	})
+})
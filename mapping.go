@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+const (
+	kindLiteral   string = "literal"
+	kindReference string = "reference"
+	anyClass      string = "*" // mapping class key applying to every 'rdf:type' class
+)
+
+// PropertyMapping names the CIM property (e.g. "cim:IdentifiedObject.name") a source key is renamed to,
+// and whether its value is a "literal" (default) or a "reference" to another resource
+type PropertyMapping struct {
+	Property string `json:"property"`
+	Kind     string `json:"kind,omitempty"`
+}
+
+// Mapping of source JSON keys to CIM properties per 'rdf:type' class (e.g. "cim:ACLineSegment"),
+// where the class "*" applies to every class and a source key starting with ':' matches
+// any Sesam namespaced key with that suffix (e.g. ":name" matches "pipe:name")
+type Mapping map[string]map[string]PropertyMapping
+
+// UnmarshalJSON accepts either a plain property name string (a literal) or a JSON object
+func (pm *PropertyMapping) UnmarshalJSON(data []byte) error {
+	var property string
+	if err := json.Unmarshal(data, &property); err == nil {
+		*pm = PropertyMapping{Property: property, Kind: kindLiteral}
+		return nil
+	}
+	type plain PropertyMapping // avoids recursion into this method
+	var value plain
+	if err := json.Unmarshal(data, &value); err != nil {
+		return fmt.Errorf("expected property mapping to be a JSON string or object, but got error: %s", err)
+	}
+	*pm = PropertyMapping(value)
+	if pm.Kind == "" {
+		pm.Kind = kindLiteral
+	}
+	return nil
+}
+
+// LoadMapping reads and validates a JSON mapping file
+func LoadMapping(path string) (Mapping, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read mapping file '%s': %s", path, err)
+	}
+	var m Mapping
+	if err = json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("expected mapping file '%s' to be a JSON object of classes, but got error: %s", path, err)
+	}
+	if err = m.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid mapping file '%s': %s", path, err)
+	}
+	return m, nil
+}
+
+// Validate checks that every target is a namespaced CIM property of a known kind
+func (m Mapping) Validate() error {
+	for class, properties := range m {
+		if class != anyClass && len(strings.Split(class, ":")) != 2 {
+			return fmt.Errorf("expected class '%s' to be of format '<namespace>:<class>' or '*'", class)
+		}
+		for key, pm := range properties {
+			if key == "" || key == ":" {
+				return fmt.Errorf("empty source key in class '%s'", class)
+			}
+			if len(strings.Split(pm.Property, ":")) != 2 {
+				return fmt.Errorf("expected property '%s' of '%s' in class '%s' to be of format '<namespace>:<Class.attribute>'", pm.Property, key, class)
+			}
+			if pm.Kind != kindLiteral && pm.Kind != kindReference {
+				return fmt.Errorf("expected kind of '%s' in class '%s' to be '%s' or '%s', but got '%s'", key, class, kindLiteral, kindReference, pm.Kind)
+			}
+		}
+	}
+	return nil
+}
+
+// mappingOf returns the mapping from the configuration, loading it when given as a file path
+func mappingOf(cfg Options) (Mapping, error) {
+	val, exist := cfg["mapping"]
+	if !exist {
+		return nil, nil
+	}
+	switch m := val.(type) {
+	case nil:
+		return nil, nil
+	case Mapping:
+		return m, m.Validate()
+	case *Mapping:
+		return *m, m.Validate()
+	case string:
+		return LoadMapping(m)
+	default:
+		return nil, fmt.Errorf("expected option 'mapping' to be a mapping or a file path, but got %T", val)
+	}
+}
+
+// apply renames the mapped source keys of the entity to their CIM properties for the given class, the mapping
+// of the class taking precedence over that of "*". Of several keys matching a ':' shortcut, the value of the first
+// in sorted order is kept.
+func (m Mapping) apply(entity map[string]json.RawMessage, name string, class string) {
+	mapped := map[string]bool{} // properties renamed to, not to be mapped again
+	for _, properties := range []map[string]PropertyMapping{m[name+":"+class], m[anyClass]} {
+		keys := make([]string, 0, len(properties))
+		for key := range properties {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			pm := properties[key]
+			matched := matchKeys(entity, key, mapped)
+			if len(matched) == 0 {
+				continue
+			}
+			v := entity[matched[0]]
+			for _, k := range matched {
+				delete(entity, k)
+			}
+			if pm.Kind == kindReference {
+				var ref string
+				if err := json.Unmarshal(v, &ref); err == nil {
+					v, _ = json.Marshal(reference(ref))
+				}
+			}
+			entity[pm.Property] = v
+			mapped[pm.Property] = true
+		}
+	}
+}
+
+// matchKeys returns the sorted entity keys matching the source key, expanding ':' shortcuts, but for those mapped
+func matchKeys(entity map[string]json.RawMessage, key string, mapped map[string]bool) []string {
+	if _, exist := entity[key]; exist {
+		if mapped[key] {
+			return nil
+		}
+		return []string{key}
+	}
+	var keys []string
+	if key[0] == ':' {
+		for k := range entity {
+			if strings.HasSuffix(k, key) && !mapped[k] {
+				keys = append(keys, k)
+			}
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// reference normalises a resource reference to the '~:<namespace>:<value>' form understood by Convert,
+// accepting urn:uuid-scheme, '_'-prefixed and '#_'-prefixed UUIDs and namespaced names
+func reference(ref string) string {
+	switch {
+	case strings.HasPrefix(ref, "~:"):
+		return ref
+	case strings.HasPrefix(ref, "urn:uuid:"):
+		return "~:uuid:" + ref[posUUID:]
+	case strings.HasPrefix(ref, "#_"):
+		return "~:uuid:" + ref[2:]
+	case strings.HasPrefix(ref, "_") && len(strings.Split(ref, "-")) == 5:
+		return "~:uuid:" + ref[1:]
+	case len(strings.Split(ref, "-")) == 5:
+		return "~:uuid:" + ref
+	case len(strings.Split(ref, ":")) == 2:
+		return "~:" + ref
+	}
+	return ref
+}
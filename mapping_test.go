@@ -0,0 +1,175 @@
+package main_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "sesam-cimrdf"
+)
+
+var _ = Describe("Microservice property name mapping", func() {
+
+	var (
+		dir     string
+		mapping Mapping
+		input   string
+		buf     bytes.Buffer
+		err     error
+	)
+
+	Describe("when loading a mapping file", func() {
+
+		BeforeEach(func() {
+			dir, _ = ioutil.TempDir("", "mapping")
+		})
+		AfterEach(func() {
+			os.RemoveAll(dir)
+		})
+
+		Context("with literal and reference properties", func() {
+			BeforeEach(func() {
+				path := filepath.Join(dir, "mapping.json")
+				ioutil.WriteFile(path, []byte(`{
+					"cim:ACLineSegment": {
+						":name": "cim:IdentifiedObject.name",
+						"pipe:length": {"property": "cim:Conductor.length"},
+						"pipe:container": {"property": "cim:Equipment.EquipmentContainer", "kind": "reference"}
+					}
+				}`), 0644)
+				mapping, err = LoadMapping(path)
+			})
+			It("holds", func() {
+				By("no error")
+				Expect(err).To(BeNil())
+				By("shorthand literal")
+				Expect(mapping["cim:ACLineSegment"][":name"]).To(Equal(PropertyMapping{Property: "cim:IdentifiedObject.name", Kind: "literal"}))
+				By("reference kind")
+				Expect(mapping["cim:ACLineSegment"]["pipe:container"].Kind).To(Equal("reference"))
+			})
+		})
+
+		Context("with unknown kind", func() {
+			BeforeEach(func() {
+				path := filepath.Join(dir, "mapping.json")
+				ioutil.WriteFile(path, []byte(`{"cim:ACLineSegment": {"pipe:name": {"property": "cim:IdentifiedObject.name", "kind": "blob"}}}`), 0644)
+				mapping, err = LoadMapping(path)
+			})
+			It("fails", func() {
+				Expect(err).NotTo(BeNil())
+			})
+		})
+
+		Context("with property missing namespace", func() {
+			BeforeEach(func() {
+				path := filepath.Join(dir, "mapping.json")
+				ioutil.WriteFile(path, []byte(`{"cim:ACLineSegment": {"pipe:name": "name"}}`), 0644)
+				mapping, err = LoadMapping(path)
+			})
+			It("fails", func() {
+				Expect(err).NotTo(BeNil())
+			})
+		})
+	})
+
+	Describe("when converting with a mapping", func() {
+
+		BeforeEach(func() {
+			mapping = Mapping{
+				"cim:ACLineSegment": {
+					":name":           {Property: "cim:IdentifiedObject.name", Kind: "literal"},
+					"pipe:container":  {Property: "cim:Equipment.EquipmentContainer", Kind: "reference"},
+					"pipe:aggregated": {Property: "cim:Equipment.aggregate", Kind: "literal"},
+				},
+			}
+			input = `[{` + namespaces + `
+				,"json":[
+					{
+						"$ids": [
+							"urn:uuid:00000000-0000-0000-0000-000000000001",
+							"~:ACLineSegment:00000000-0000-0000-0000-000000000001"
+						],
+						"_id": "urn:uuid:00000000-0000-0000-0000-000000000001",
+						"pipe:name": "Line 1",
+						"pipe:container": "urn:uuid:00000000-0000-0000-0000-000000000002",
+						"pipe:aggregated": false,
+						"pipe:unmapped": "dropped",
+						"rdf:type": "~:cim:ACLineSegment"
+					}
+				]
+			}]`
+			rw := NewInputOutput(input, "", &buf)
+			err = Convert(rw, &Options{"json": "json", "mapping": mapping}, 0)
+			rw.Flush()
+		})
+		AfterEach(func() {
+			buf.Reset()
+		})
+		It("delivers", func() {
+			By("no error")
+			Expect(err).To(BeNil())
+			inner, err := NewInner(buf.String(), "xml")
+			Expect(err).To(BeNil())
+			By("renamed literal properties")
+			Expect(inner[0]).To(ContainSubstring(`<cim:IdentifiedObject.name>Line 1</cim:IdentifiedObject.name>`))
+			Expect(inner[0]).To(ContainSubstring(`<cim:Equipment.aggregate>false</cim:Equipment.aggregate>`))
+			By("renamed reference properties")
+			Expect(inner[0]).To(ContainSubstring(`<cim:Equipment.EquipmentContainer rdf:resource="#_00000000-0000-0000-0000-000000000002"/>`))
+			By("no unmapped source keys")
+			Expect(inner[0]).NotTo(ContainSubstring(`dropped`))
+		})
+	})
+
+	Describe("when converting with class and '*' mappings of the same keys", func() {
+
+		BeforeEach(func() {
+			mapping = Mapping{
+				"*": {
+					":name":      {Property: "cim:IdentifiedObject.description", Kind: "literal"},
+					"pipe:alias": {Property: "cim:IdentifiedObject.aliasName", Kind: "literal"},
+				},
+				"cim:ACLineSegment": {
+					":name": {Property: "cim:IdentifiedObject.name", Kind: "literal"},
+				},
+			}
+			input = `[{` + namespaces + `
+				,"json":[
+					{
+						"$ids": [
+							"urn:uuid:00000000-0000-0000-0000-000000000001",
+							"~:ACLineSegment:00000000-0000-0000-0000-000000000001"
+						],
+						"_id": "urn:uuid:00000000-0000-0000-0000-000000000001",
+						"b:name": "Line B",
+						"a:name": "Line A",
+						"pipe:alias": "L1",
+						"rdf:type": "~:cim:ACLineSegment"
+					}
+				]
+			}]`
+			rw := NewInputOutput(input, "", &buf)
+			err = Convert(rw, &Options{"json": "json", "mapping": mapping}, 0)
+			rw.Flush()
+		})
+		AfterEach(func() {
+			buf.Reset()
+		})
+		It("delivers", func() {
+			By("no error")
+			Expect(err).To(BeNil())
+			inner, err := NewInner(buf.String(), "xml")
+			Expect(err).To(BeNil())
+			By("the class mapping taking precedence, with the first of the matching keys")
+			Expect(inner[0]).To(ContainSubstring(`<cim:IdentifiedObject.name>Line A</cim:IdentifiedObject.name>`))
+			Expect(inner[0]).NotTo(ContainSubstring(`Line B`))
+			Expect(inner[0]).NotTo(ContainSubstring(`cim:IdentifiedObject.description`))
+			By("the '*' mapping of the other keys")
+			Expect(inner[0]).To(ContainSubstring(`<cim:IdentifiedObject.aliasName>L1</cim:IdentifiedObject.aliasName>`))
+		})
+	})
+
+})
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// metric names of the Prometheus text exposition at GET /metrics
+const (
+	metricRequests       string = "cimrdf_http_requests_total"
+	metricBytesIn        string = "cimrdf_http_request_bytes_total"
+	metricBytesOut       string = "cimrdf_http_response_bytes_total"
+	metricEntities       string = "cimrdf_entities_processed_total"
+	metricMinted         string = "cimrdf_uuids_minted_total"
+	metricSkipped        string = "cimrdf_entities_skipped_total"
+	metricMintFailed     string = "cimrdf_entities_mint_failed_total"
+	metricConvertSeconds string = "cimrdf_conversion_duration_seconds"
+)
+
+// metricHelp describes the metrics, in order of exposition
+var metricHelp = []struct{ name, kind, help string }{
+	{metricRequests, "counter", "HTTP requests by route and status code."},
+	{metricBytesIn, "counter", "HTTP request body bytes read by route."},
+	{metricBytesOut, "counter", "HTTP response body bytes written by route."},
+	{metricEntities, "counter", "Entities processed by operation (mint or convert)."},
+	{metricMinted, "counter", "UUIDs minted."},
+	{metricSkipped, "counter", "Entities skipped by conversion for lacking a valid identity."},
+	{metricMintFailed, "counter", "Entities failing minting, attached to them or failing the request."},
+	{metricConvertSeconds, "histogram", "Duration of converting a model."},
+}
+
+// durationBuckets are the upper bounds of the conversion duration histogram in seconds
+var durationBuckets = []float64{0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5, 10, 30}
+
+type histogram struct {
+	counts []uint64 // per bucket, not cumulative
+	count  uint64
+	sum    float64
+}
+
+// registry holds the counters and histograms of the process, keyed by metric name and rendered labels
+type registry struct {
+	mu         sync.Mutex
+	counters   map[string]map[string]float64
+	histograms map[string]*histogram
+}
+
+var metrics = &registry{counters: map[string]map[string]float64{}, histograms: map[string]*histogram{}}
+
+// labels renders label name and value pairs as '{name="value",...}'
+func labels(pairs ...string) string {
+	if len(pairs) == 0 {
+		return ""
+	}
+	parts := make([]string, 0, len(pairs)/2)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		value := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(pairs[i+1])
+		parts = append(parts, fmt.Sprintf(`%s="%s"`, pairs[i], value))
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+// add increments the counter of the metric with the labels
+func (m *registry) add(name string, labels string, v float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.counters[name] == nil {
+		m.counters[name] = map[string]float64{}
+	}
+	m.counters[name][labels] += v
+}
+
+// observe records a value in the histogram of the metric
+func (m *registry) observe(name string, v float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	h, exist := m.histograms[name]
+	if !exist {
+		h = &histogram{counts: make([]uint64, len(durationBuckets))}
+		m.histograms[name] = h
+	}
+	for i, le := range durationBuckets {
+		if v <= le {
+			h.counts[i]++
+			break
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+// write writes all metrics in the Prometheus text exposition format
+func (m *registry) write(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, metric := range metricHelp {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", metric.name, metric.help, metric.name, metric.kind)
+		if h, exist := m.histograms[metric.name]; exist {
+			var cumulative uint64
+			for i, le := range durationBuckets {
+				cumulative += h.counts[i]
+				fmt.Fprintf(w, "%s_bucket{le=\"%g\"} %d\n", metric.name, le, cumulative)
+			}
+			fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", metric.name, h.count)
+			fmt.Fprintf(w, "%s_sum %g\n%s_count %d\n", metric.name, h.sum, metric.name, h.count)
+			continue
+		}
+		keys := make([]string, 0, len(m.counters[metric.name]))
+		for k := range m.counters[metric.name] {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Fprintf(w, "%s%s %g\n", metric.name, k, m.counters[metric.name][k])
+		}
+	}
+}
+
+// countingReader counts the bytes read from a request body
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.ReadCloser.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
+
+// countingWriter records the status code and counts the bytes written of a response,
+// passing on flushes and full duplex to the underlying ResponseWriter
+type countingWriter struct {
+	http.ResponseWriter
+	status int
+	n      int64
+}
+
+func (cw *countingWriter) WriteHeader(code int) {
+	if cw.status == 0 {
+		cw.status = code
+	}
+	cw.ResponseWriter.WriteHeader(code)
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+	n, err := cw.ResponseWriter.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+func (cw *countingWriter) Flush() {
+	if flusher, ok := cw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (cw *countingWriter) EnableFullDuplex() error {
+	if duplex, ok := cw.ResponseWriter.(interface{ EnableFullDuplex() error }); ok {
+		return duplex.EnableFullDuplex()
+	}
+	return fmt.Errorf("full duplex not supported")
+}
+
+// instrument wraps the handle of a route to count its requests, statuses and bytes
+func instrument(route string, handle httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		cr := &countingReader{ReadCloser: r.Body}
+		if r.Body != nil {
+			r.Body = cr
+		}
+		cw := &countingWriter{ResponseWriter: w}
+		handle(cw, r, p)
+		if cw.status == 0 {
+			cw.status = http.StatusOK
+		}
+		metrics.add(metricRequests, labels("route", route, "status", fmt.Sprintf("%d", cw.status)), 1)
+		metrics.add(metricBytesIn, labels("route", route), float64(cr.n))
+		metrics.add(metricBytesOut, labels("route", route), float64(cw.n))
+	}
+}
+
+// since observes the seconds since start in the histogram of the metric
+func since(name string, start time.Time) {
+	metrics.observe(name, time.Since(start).Seconds())
+}
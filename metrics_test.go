@@ -0,0 +1,66 @@
+package main_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "sesam-cimrdf"
+)
+
+var _ = Describe("Microservice health and metrics", func() {
+
+	var (
+		server   *Server
+		response *httptest.ResponseRecorder
+	)
+
+	BeforeEach(func() {
+		server = NewTestServer(Options{"log": ioutil.Discard, "seed": "ginkgo"})
+		response = httptest.NewRecorder()
+	})
+
+	Get := func(path string) {
+		request, _ := http.NewRequest("GET", path, nil)
+		server.ServeHTTP(response, request)
+	}
+
+	It("is healthy", func() {
+		Get("/health")
+		Expect(response.Code).To(Equal(200))
+		Expect(response.Body.String()).To(ContainSubstring(`"ok"`))
+	})
+
+	It("is ready until told otherwise", func() {
+		Get("/ready")
+		Expect(response.Code).To(Equal(200))
+		server.SetReady(false)
+		response = httptest.NewRecorder()
+		Get("/ready")
+		Expect(response.Code).To(Equal(503))
+	})
+
+	It("exposes request, minting and conversion metrics", func() {
+		request, _ := http.NewRequest("POST", "/_id", strings.NewReader(`[{"_id": "a"}, {"_id": "b"}]`))
+		server.ServeHTTP(httptest.NewRecorder(), request)
+		input := `[{"cim:Model.all": [{"$ids": 5}]}]`
+		request, _ = http.NewRequest("POST", "/convert", strings.NewReader(input))
+		server.ServeHTTP(httptest.NewRecorder(), request)
+
+		Get("/metrics")
+		Expect(response.Code).To(Equal(200))
+		Expect(response.Header().Get("Content-Type")).To(HavePrefix("text/plain"))
+		metrics := response.Body.String()
+		Expect(metrics).To(ContainSubstring(`cimrdf_http_requests_total{route="/:field",status="200"}`))
+		Expect(metrics).To(ContainSubstring(`cimrdf_http_requests_total{route="/convert",status="200"}`))
+		Expect(metrics).To(ContainSubstring(`cimrdf_http_request_bytes_total{route="/:field"}`))
+		Expect(metrics).To(ContainSubstring(`cimrdf_entities_processed_total{operation="mint"}`))
+		Expect(metrics).To(MatchRegexp(`(?m)^cimrdf_uuids_minted_total [1-9]`))
+		Expect(metrics).To(MatchRegexp(`(?m)^cimrdf_entities_skipped_total [1-9]`))
+		Expect(metrics).To(MatchRegexp(`(?m)^cimrdf_conversion_duration_seconds_count [1-9]`))
+	})
+})
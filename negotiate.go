@@ -0,0 +1,92 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	mediaJSON     string = "application/json"
+	mediaNDJSON   string = "application/x-ndjson"
+	mediaJSONLD   string = "application/ld+json"
+	mediaRDFXML   string = "application/rdf+xml"
+	mediaTurtle   string = "text/turtle"
+	mediaNTriples string = "application/n-triples"
+	mediaForm     string = "application/x-www-form-urlencoded" // of e.g. 'curl -d', read as JSON by the minting routes
+)
+
+// mediaRange is a media range of an Accept header with its quality
+type mediaRange struct {
+	kind    string // e.g. "application", or "*"
+	subtype string // e.g. "json", or "*"
+	q       float64
+}
+
+// negotiate returns the offered media type most preferred by the Accept header of the request, which is the first offer
+// when the header is absent; ok is false when no offer is acceptable (HTTP 406)
+func negotiate(r *http.Request, offers ...string) (media string, ok bool) {
+	header := r.Header.Get("Accept")
+	if strings.TrimSpace(header) == "" {
+		return offers[0], true
+	}
+	var ranges []mediaRange
+	for _, part := range strings.Split(header, ",") {
+		params := strings.Split(part, ";")
+		kinds := strings.SplitN(strings.ToLower(strings.TrimSpace(params[0])), "/", 2)
+		if len(kinds) != 2 {
+			continue
+		}
+		mr := mediaRange{kind: kinds[0], subtype: kinds[1], q: 1}
+		for _, param := range params[1:] {
+			if kv := strings.SplitN(strings.TrimSpace(param), "=", 2); len(kv) == 2 && kv[0] == "q" {
+				if q, err := strconv.ParseFloat(kv[1], 64); err == nil {
+					mr.q = q
+				}
+			}
+		}
+		ranges = append(ranges, mr)
+	}
+	// more specific ranges take precedence over wildcards for the same offer
+	sort.SliceStable(ranges, func(i, j int) bool { return specificity(ranges[i]) > specificity(ranges[j]) })
+	best := 0.0
+	for _, offer := range offers {
+		kinds := strings.SplitN(offer, "/", 2)
+		for _, mr := range ranges {
+			if (mr.kind == kinds[0] || mr.kind == "*") && (mr.subtype == kinds[1] || mr.subtype == "*") {
+				if mr.q > best {
+					best = mr.q
+					media = offer
+				}
+				break
+			}
+		}
+	}
+	return media, best > 0
+}
+
+func specificity(mr mediaRange) int {
+	switch {
+	case mr.kind == "*":
+		return 0
+	case mr.subtype == "*":
+		return 1
+	}
+	return 2
+}
+
+// contentType returns the media type of the request body without parameters, or the default when absent;
+// ok is false when it is not among the supported media types (HTTP 415)
+func contentType(r *http.Request, supported ...string) (media string, ok bool) {
+	media = strings.ToLower(strings.TrimSpace(strings.Split(r.Header.Get("Content-Type"), ";")[0]))
+	if media == "" {
+		return supported[0], true
+	}
+	for _, s := range supported {
+		if media == s {
+			return media, true
+		}
+	}
+	return media, false
+}
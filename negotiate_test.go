@@ -0,0 +1,142 @@
+package main_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "sesam-cimrdf"
+)
+
+var _ = Describe("Microservice content negotiation", func() {
+
+	const input string = `[{` + namespaces + `
+		,"cim:Model.all":[
+			{
+				"$ids": ["urn:uuid:00000000-0000-0000-0000-000000000001", "~:Substation:00000000-0000-0000-0000-000000000001"],
+				"_id": "urn:uuid:00000000-0000-0000-0000-000000000001",
+				"cim:IdentifiedObject.name": "ONE",
+				"cim:Substation.Region": "~:SubGeographicalRegion:00000000-0000-0000-0000-000000000002",
+				"rdf:type": "~:cim:Substation"
+			}
+		]
+	}]`
+
+	var (
+		server   *Server
+		response *httptest.ResponseRecorder
+	)
+
+	BeforeEach(func() {
+		server = NewTestServer(Options{"log": ioutil.Discard, "seed": "ginkgo"})
+		response = httptest.NewRecorder()
+	})
+
+	Convert := func(accept string, contentType string) {
+		request, _ := http.NewRequest("POST", "/convert", strings.NewReader(input))
+		if accept != "" {
+			request.Header.Set("Accept", accept)
+		}
+		if contentType != "" {
+			request.Header.Set("Content-Type", contentType)
+		}
+		server.ServeHTTP(response, request)
+	}
+
+	It("returns JSON-wrapped RDF/XML by default", func() {
+		Convert("", "")
+		Expect(response.Code).To(Equal(200))
+		Expect(response.Header().Get("Content-Type")).To(HavePrefix("application/json"))
+		var models []map[string]interface{}
+		Expect(json.Unmarshal(response.Body.Bytes(), &models)).To(Succeed())
+		Expect(models).To(HaveLen(1))
+		Expect(models[0]["xml"]).To(ContainSubstring(`<cim:Substation rdf:about="_00000000-0000-0000-0000-000000000001">`))
+	})
+
+	It("returns one model per line as NDJSON", func() {
+		Convert("application/x-ndjson", "application/json")
+		Expect(response.Code).To(Equal(200))
+		Expect(response.Header().Get("Content-Type")).To(HavePrefix("application/x-ndjson"))
+		lines := strings.Split(strings.TrimSuffix(response.Body.String(), "\n"), "\n")
+		Expect(lines).To(HaveLen(1))
+		Expect(lines[0]).To(HavePrefix("{"))
+	})
+
+	It("returns raw RDF/XML", func() {
+		Convert("application/rdf+xml", "")
+		Expect(response.Code).To(Equal(200))
+		Expect(response.Header().Get("Content-Type")).To(HavePrefix("application/rdf+xml"))
+		Expect(response.Body.String()).To(HavePrefix(`<?xml version="1.0" encoding="UTF-8"`))
+		Expect(response.Body.String()).To(ContainSubstring(`rdf:resource="#_00000000-0000-0000-0000-000000000002"`))
+	})
+
+	It("returns Turtle", func() {
+		Convert("text/turtle", "")
+		Expect(response.Code).To(Equal(200))
+		Expect(response.Header().Get("Content-Type")).To(HavePrefix("text/turtle"))
+		Expect(response.Body.String()).To(ContainSubstring("@prefix cim: <http://iec.ch/TC57/2017/CIM-schema-cim100#> ."))
+		Expect(response.Body.String()).To(ContainSubstring(`cim:IdentifiedObject.name "ONE"`))
+	})
+
+	It("returns N-Triples", func() {
+		Convert("application/n-triples", "")
+		Expect(response.Code).To(Equal(200))
+		Expect(response.Body.String()).To(ContainSubstring(
+			`<urn:uuid:00000000-0000-0000-0000-000000000001> <http://iec.ch/TC57/2017/CIM-schema-cim100#IdentifiedObject.name> "ONE" .`))
+	})
+
+	It("returns JSON-LD", func() {
+		Convert("application/ld+json", "")
+		Expect(response.Code).To(Equal(200))
+		var document map[string]interface{}
+		Expect(json.Unmarshal(response.Body.Bytes(), &document)).To(Succeed())
+		Expect(document).To(HaveKey("@context"))
+		Expect(document["@graph"]).To(HaveLen(1))
+	})
+
+	It("prefers the highest quality", func() {
+		Convert("application/json;q=0.5, text/turtle;q=0.9, */*;q=0.1", "")
+		Expect(response.Code).To(Equal(200))
+		Expect(response.Header().Get("Content-Type")).To(HavePrefix("text/turtle"))
+	})
+
+	It("rejects unacceptable output with 406", func() {
+		Convert("image/png", "")
+		Expect(response.Code).To(Equal(406))
+	})
+
+	It("rejects unsupported input with 415", func() {
+		Convert("", "text/plain")
+		Expect(response.Code).To(Equal(415))
+	})
+
+	It("mints a missing or form Content-Type as JSON", func() {
+		for _, contentType := range []string{"", "application/x-www-form-urlencoded"} {
+			response = httptest.NewRecorder()
+			request, _ := http.NewRequest("POST", "/_id", strings.NewReader(`[{"_id": "1"}]`))
+			if contentType != "" {
+				request.Header.Set("Content-Type", contentType)
+			}
+			server.ServeHTTP(response, request)
+			Expect(response.Code).To(Equal(200))
+			Expect(response.Body.String()).To(MatchRegexp(`^\[{"_id":"[0-9a-f-]{36}"}\]`))
+		}
+		response = httptest.NewRecorder()
+		request, _ := http.NewRequest("POST", "/_id", strings.NewReader(`[{"_id": "1"}]`))
+		request.Header.Set("Content-Type", "text/plain")
+		server.ServeHTTP(response, request)
+		Expect(response.Code).To(Equal(415))
+	})
+
+	It("rejects unacceptable minting output with 406", func() {
+		request, _ := http.NewRequest("POST", "/_id", strings.NewReader(`[{"_id": "1"}]`))
+		request.Header.Set("Accept", "text/turtle")
+		server.ServeHTTP(response, request)
+		Expect(response.Code).To(Equal(406))
+	})
+})
@@ -14,67 +14,290 @@ import (
 type Options map[string]interface{}
 
 type serverOptions struct {
-	log       io.Writer
-	level     int
-	seed      uuid.UUID
-	namespace string
-	options   *Options
+	log              io.Writer
+	level            int
+	logger           *logger
+	seed             uuid.UUID
+	namespace        string
+	seeds            map[string]uuid.UUID // named seeds picked per request, see seedFor
+	version          string               // version name of seed
+	versions         map[string]uuid.UUID // earlier versions of seed by name, for migrating their UUIDs
+	internal         internalKeys
+	partial          bool          // whether minting failures of entities are attached to them, instead of failing the request
+	lookup           string        // file of the lookup store, if any
+	retention        time.Duration // of the lookup store
+	journal          string        // file of the journal of minted entities and converted models, if any
+	journalRetention time.Duration // of the entity journal
+	assembly         time.Duration // of holding the models of an unfinished pipe run, see assemble
+	shapes           *Shapes
+	limits           sizeLimits
+	throttle         throttleLimits
+	auth             *authenticator
+	routes           map[string]bool           // enabled route groups
+	convert          Options                   // options of Convert for the conversion route
+	pipes            map[string]*serverOptions // overriding options per Sesam 'pipe_id', see pipeOptions
+	options          *Options
 }
 
-// NewOptions returns default microservice options
-func NewOptions(opt *Options) serverOptions {
-	var seed uuid.UUID = uuid.Nil
-	var log io.Writer = os.Stdout
-	level := ""
-	num := logERROR
-	namespace := strings.Trim(os.Getenv("UUID_SEED"), " ")
-	if len(namespace) == 0 {
-		if opt != nil {
-			// TODO: unit-tests for all of the below
-			if val, exist := (*opt)["seed"]; exist && len(strings.Trim(val.(string), " ")) != 0 {
-				namespace = fmt.Sprintf("%v", val)
-				seed = uuid.NewSHA1(uuid.Nil, []byte(namespace))
-			} else if val, exist := (*opt)["SEED"]; exist && len(strings.Trim(val.(string), " ")) != 0 {
-				namespace = fmt.Sprintf("%v", val)
-				seed = uuid.NewSHA1(uuid.Nil, []byte(namespace))
-			} else if val, exist := (*opt)["uuid"]; exist && len(strings.Trim(val.(string), " ")) != 0 {
-				seed = val.(uuid.UUID)
-			} else if val, exist := (*opt)["UUID"]; exist && len(strings.Trim(val.(string), " ")) != 0 {
-				seed = val.(uuid.UUID)
-			}
+// NewOptions returns the typed microservice options of options as merged by LoadConfig, or an error
+// when an option has an invalid type or value
+func NewOptions(opt *Options) (serverOptions, error) {
+	o := Options{}
+	if opt != nil {
+		o = *opt
+	}
+	so := serverOptions{log: os.Stdout, level: logERROR, assembly: time.Hour, options: opt}
+	var err error
+
+	if so.seed, so.namespace, err = seedOf(o); err != nil {
+		return so, err
+	}
+	if so.seeds, err = seedsOf(o, "seeds"); err != nil {
+		return so, err
+	}
+	if so.versions, err = seedsOf(o, "seed_versions"); err != nil {
+		return so, err
+	}
+	so.version = "current"
+	if val, exist := o["seed_version"]; exist && val != nil {
+		version, ok := val.(string)
+		if !ok {
+			return so, fmt.Errorf("expected option 'seed_version' to be a string, but got %T", val)
+		}
+		if version = strings.Trim(version, " "); len(version) != 0 {
+			so.version = version
 		}
-	} else {
-		seed = uuid.NewSHA1(uuid.Nil, []byte(namespace))
 	}
-	if seed == uuid.Nil {
-		fmt.Fprintf(os.Stderr, "%s\n", "fatal: missing environment 'UUID_SEED' or option 'seed' or 'uuid' for microservice.")
-		time.Sleep(30 * time.Second)
-		os.Exit(1)
+	if _, exist := so.versions[so.version]; exist {
+		return so, fmt.Errorf("expected option 'seed_versions' to have earlier versions than '%s'", so.version)
+	}
+	if val, exist := o["log"]; exist && val != nil {
+		w, ok := val.(io.Writer)
+		if !ok {
+			return so, fmt.Errorf("expected option 'log' to be an io.Writer, but got %T", val)
+		}
+		so.log = w
 	}
+	if val, exist := o["level"]; exist && val != nil {
+		name, ok := val.(string)
+		if !ok {
+			return so, fmt.Errorf("expected option 'level' to be a string, but got %T", val)
+		}
+		if so.level = levelOf(name, -1); so.level < 0 {
+			return so, fmt.Errorf("expected option 'level' to be one of %s, but got '%s'", strings.Join(logLevel, ", "), name)
+		}
+	}
+	so.logger = newLogger(so.log, so.level)
 
-	if opt != nil {
-		if val, exist := (*opt)["level"]; exist {
-			level = fmt.Sprintf("%v", val)
+	if val, exist := o["partial"]; exist && val != nil {
+		partial, ok := val.(bool)
+		if !ok {
+			return so, fmt.Errorf("expected option 'partial' to be a boolean, but got %T", val)
+		}
+		so.partial = partial
+	}
+	if val, exist := o["lookup"]; exist && val != nil {
+		file, ok := val.(string)
+		if !ok {
+			return so, fmt.Errorf("expected option 'lookup' to be a string, but got %T", val)
+		}
+		so.lookup = strings.Trim(file, " ")
+	}
+	if val, exist := o["lookup_retention"]; exist && val != nil {
+		if so.retention, err = durationOf(val); err != nil {
+			return so, fmt.Errorf("option 'lookup_retention' %s", err)
+		}
+	}
+
+	if val, exist := o["entities"]; exist && val != nil {
+		file, ok := val.(string)
+		if !ok {
+			return so, fmt.Errorf("expected option 'entities' to be a string, but got %T", val)
+		}
+		so.journal = strings.Trim(file, " ")
+	}
+	if val, exist := o["entities_retention"]; exist && val != nil {
+		if so.journalRetention, err = durationOf(val); err != nil {
+			return so, fmt.Errorf("option 'entities_retention' %s", err)
+		}
+	}
+	if val, exist := o["assembly_timeout"]; exist && val != nil {
+		if so.assembly, err = durationOf(val); err != nil {
+			return so, fmt.Errorf("option 'assembly_timeout' %s", err)
+		}
+	}
+
+	if so.internal, err = internalKeysOf(Options{"keep": o["keep"], "strip": o["strip"]}); err != nil {
+		return so, err
+	}
+	if so.shapes, err = shapesOf(Options{"shapes": o["shapes"]}); err != nil {
+		return so, err
+	}
+	if so.limits, err = sizeLimitsOf(Options{"max_request_size": o["max_request_size"]}); err != nil {
+		return so, err
+	}
+	if so.throttle, err = throttleLimitsOf(o); err != nil {
+		return so, err
+	}
+	jwt := Options{}
+	for _, k := range []string{"jwt_secret", "jwks", "audience", "issuer", "claims"} {
+		jwt[k] = o[k]
+	}
+	if so.auth, err = authenticatorOf(jwt); err != nil {
+		return so, err
+	}
+
+	so.routes = make(map[string]bool, len(routeGroups))
+	enabled := routeGroups
+	if o["routes"] != nil {
+		if enabled, err = fieldList(o, "routes"); err != nil {
+			return so, err
+		}
+	}
+	for _, group := range enabled {
+		known := false
+		for _, g := range routeGroups {
+			known = known || g == group
+		}
+		if !known {
+			return so, fmt.Errorf("expected option 'routes' of route groups %s, but got '%s'", strings.Join(routeGroups, ", "), group)
+		}
+		so.routes[group] = true
+	}
+
+	so.convert = Options{"json": "cim:Model.all", "ns": "names", "names": defaultNamespaces}
+	for k, v := range o {
+		so.convert[k] = v
+	}
+	so.convert["names"] = namesOf(so.convert)
+	so.convert["keep"], so.convert["strip"], so.convert["shapes"], so.convert["logger"] = o["keep"], o["strip"], so.shapes, so.logger
+	if so.convert["mapping"], err = mappingOf(so.convert); err != nil {
+		return so, err
+	}
+	// the conversion options are otherwise validated by creating a converter of them
+	c, err := newConverter(so.convert, 0)
+	if err != nil {
+		return so, err
+	}
+	c.close()
+	if so.pipes, err = pipesOf(o, so); err != nil {
+		return so, err
+	}
+	return so, nil
+}
+
+// pipeOptions are the options which option 'pipes' can override per Sesam 'pipe_id'
+var pipeOptions = []string{"names", "json", "xml", "ns", "digest", "version", "report", "difference", "filter", "partial",
+	"keep", "strip", "mapping", "shapes"}
+
+// pipesOf returns the options of each pipe of option 'pipes', an object of pipe IDs and objects of options
+// overriding the options so of o, sharing its logger
+func pipesOf(o Options, so serverOptions) (map[string]*serverOptions, error) {
+	val, exist := o["pipes"]
+	if !exist || val == nil {
+		return nil, nil
+	}
+	pipes, ok := val.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("expected option 'pipes' to be an object of pipe IDs, but got %T", val)
+	}
+	result := make(map[string]*serverOptions, len(pipes))
+	for pipe, val := range pipes {
+		overrides, ok := val.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("expected option 'pipes' of '%s' to be an object of options, but got %T", pipe, val)
+		}
+		merged := Options{}
+		for k, v := range o {
+			if k != "pipes" {
+				merged[k] = v
+			}
 		}
-		if val, exist := (*opt)["log"]; exist {
-			log = val.(io.Writer)
+		for k, v := range overrides {
+			known := false
+			for _, key := range pipeOptions {
+				known = known || key == k
+			}
+			if !known {
+				return nil, fmt.Errorf("expected option 'pipes' of '%s' to override options %s, but got '%s'", pipe, strings.Join(pipeOptions, ", "), k)
+			}
+			merged[k] = v
+		}
+		po, err := NewOptions(&merged)
+		if err != nil {
+			return nil, fmt.Errorf("option 'pipes' of '%s': %s", pipe, err)
 		}
+		po.logger, po.options = so.logger, so.options
+		po.convert["logger"] = so.logger
+		result[pipe] = &po
 	}
-	val := os.Getenv("LOG_LEVEL")
-	if len(val) != 0 {
-		level = val
+	return result, nil
+}
+
+// seedOf returns the UUID namespace of minting of option 'seed' (a string hashed to a UUID) or 'uuid' (a UUID,
+// or its string), and the seed string, or an error when neither is given
+func seedOf(opt Options) (uuid.UUID, string, error) {
+	for _, key := range []string{"seed", "SEED"} {
+		if val, exist := opt[key]; exist && val != nil {
+			seed, ok := val.(string)
+			if !ok {
+				return uuid.Nil, "", fmt.Errorf("expected option '%s' to be a string, but got %T", key, val)
+			}
+			if seed = strings.Trim(seed, " "); len(seed) != 0 {
+				return uuid.NewSHA1(uuid.Nil, []byte(seed)), seed, nil
+			}
+		}
 	}
-	if len(level) != 0 {
-		level = strings.ToUpper(level)
-		var k int
-		for k, val = range logLevel {
-			if val == level {
-				num = k
-				break
+	for _, key := range []string{"uuid", "UUID"} {
+		if val, exist := opt[key]; exist && val != nil {
+			switch v := val.(type) {
+			case uuid.UUID:
+				if v != uuid.Nil {
+					return v, "", nil
+				}
+			case string:
+				if v = strings.Trim(v, " "); len(v) == 0 {
+					continue
+				}
+				u, err := uuid.Parse(v)
+				if err != nil || u == uuid.Nil {
+					return uuid.Nil, "", fmt.Errorf("expected option '%s' to be a non-nil UUID, but got '%s'", key, v)
+				}
+				return u, "", nil
+			default:
+				return uuid.Nil, "", fmt.Errorf("expected option '%s' to be a UUID, but got %T", key, val)
 			}
 		}
 	}
-	return serverOptions{log: log, level: num, seed: seed, namespace: namespace, options: opt}
+	return uuid.Nil, "", fmt.Errorf("missing environment 'UUID_SEED' or option 'seed' or 'uuid' for microservice")
+}
+
+// seedsOf returns the UUID namespaces of the named seed strings of the option, a JSON object
+func seedsOf(opt Options, key string) (map[string]uuid.UUID, error) {
+	seeds := map[string]uuid.UUID{}
+	named := map[string]interface{}{}
+	switch val := opt[key].(type) {
+	case nil:
+	case map[string]interface{}:
+		named = val
+	case map[string]string:
+		for name, seed := range val {
+			named[name] = seed
+		}
+	default:
+		return nil, fmt.Errorf("expected option '%s' to be a JSON object of named seeds, but got %T", key, val)
+	}
+	for name, val := range named {
+		seed, ok := val.(string)
+		if !ok {
+			return nil, fmt.Errorf("expected the seed of '%s' of option '%s' to be a string, but got %T", name, key, val)
+		}
+		if name, seed = strings.Trim(name, " "), strings.Trim(seed, " "); len(name) == 0 || len(seed) == 0 {
+			return nil, fmt.Errorf("expected option '%s' to have names and seeds, but got '%s' of '%s'", key, seed, name)
+		}
+		seeds[name] = uuid.NewSHA1(uuid.Nil, []byte(seed))
+	}
+	return seeds, nil
 }
 
 var logLevel = []string{"OFF", "CUSTOM", "QUIET", "LIVE", "FATAL", "ERROR", "WARN", "INFO", "DEBUG", "TRACE", "ALL"}
@@ -95,30 +318,95 @@ const (
 
 // Log to configured output with an INFO level
 func (s *Server) Log(l string) {
-	if strings.HasSuffix(l, "\n") {
-		s.Logf(logINFO, l)
-	} else {
-		s.Logf(logINFO, "%s\n", l)
-	}
+	s.Logf(logINFO, "%s", l)
 }
 
 // Logf to configured output with given level, format and parameters
 func (s *Server) Logf(level int, format string, args ...interface{}) {
-	if s.options.level >= level {
-		fmt.Fprintf(s.options.log, format, args...)
-	}
+	s.current().logger.Logf(level, format, args...)
 }
 
 // Error logs to configured output with an ERROR level
 func (s *Server) Error(l string) {
-	if strings.HasSuffix(l, "\n") {
-		s.Logf(logERROR, l)
-	} else {
-		s.Logf(logERROR, "%s\n", l)
-	}
+	s.Logf(logERROR, "%s", l)
 }
 
 // Errorf logs to configured output with ERROR level, format and parameters
 func (s *Server) Errorf(format string, args ...interface{}) {
 	s.Logf(logERROR, format, args...)
 }
+
+// internalKeys decides which top-level keys prefixed with '_' (Sesam internal fields such as '_updated',
+// '_deleted', '_hash' and '_ts') are kept in output; '_id' is always kept and all others are stripped by default
+type internalKeys struct {
+	keep  map[string]bool
+	strip map[string]bool
+}
+
+// newInternalKeys returns internal keys to keep (allowlist, '*' for all) unless also given to strip (denylist)
+func newInternalKeys(keep []string, strip []string) internalKeys {
+	ik := internalKeys{keep: make(map[string]bool, len(keep)), strip: make(map[string]bool, len(strip))}
+	for _, k := range keep {
+		ik.keep[k] = true
+	}
+	for _, k := range strip {
+		ik.strip[k] = true
+	}
+	return ik
+}
+
+// internalKeysOf returns the internal keys to keep from options 'keep' and 'strip'
+func internalKeysOf(opt Options) (internalKeys, error) {
+	keep, err := fieldList(opt, "keep")
+	if err != nil {
+		return internalKeys{}, err
+	}
+	strip, err := fieldList(opt, "strip")
+	if err != nil {
+		return internalKeys{}, err
+	}
+	return newInternalKeys(keep, strip), nil
+}
+
+// retain reports whether the top-level key is kept in output
+func (ik internalKeys) retain(k string) bool {
+	if k == "_id" || k == "" || k[0] != '_' {
+		return true
+	}
+	if ik.strip[k] {
+		return false
+	}
+	return ik.keep[k] || ik.keep["*"]
+}
+
+// fieldList returns the option as a list of field names, given as a JSON array or a comma-separated string
+func fieldList(opt Options, name string) ([]string, error) {
+	val, exist := opt[name]
+	if !exist || val == nil {
+		return nil, nil
+	}
+	var fields []string
+	switch list := val.(type) {
+	case []string:
+		fields = list
+	case string:
+		fields = strings.Split(list, ",")
+	case []interface{}:
+		for _, v := range list {
+			field, ok := v.(string)
+			if !ok {
+				return nil, fmt.Errorf("expected option '%s' to be a list of field names, but got %T", name, v)
+			}
+			fields = append(fields, field)
+		}
+	default:
+		return nil, fmt.Errorf("expected option '%s' to be a list of field names, but got %T", name, val)
+	}
+	result := make([]string, 0, len(fields))
+	for _, field := range fields {
+		if field = strings.Trim(field, " "); len(field) != 0 {
+			result = append(result, field)
+		}
+	}
+	return result, nil
+}
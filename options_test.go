@@ -2,13 +2,26 @@ package main_test
 
 import (
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 
+	"github.com/google/uuid"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 
 	. "sesam-cimrdf"
 )
 
+// NewTestServer returns a microservice Server of the options, which must be valid
+func NewTestServer(opt Options) *Server {
+	so, err := NewOptions(&opt)
+	Expect(err).To(BeNil())
+	server, err := NewServer(so)
+	Expect(err).To(BeNil())
+	return server
+}
+
 var _ = Describe("Microservice options", func() {
 
 	var (
@@ -25,6 +38,59 @@ var _ = Describe("Microservice options", func() {
 				Expect(opt).To(HaveKeyWithValue("log", ioutil.Discard))
 			})
 		})
+
+		It("accepts a UUID as its string", func() {
+			id := uuid.NewSHA1(uuid.Nil, []byte("ginkgo"))
+			_, err := NewOptions(&Options{"log": ioutil.Discard, "uuid": id.String()})
+			Expect(err).To(BeNil())
+			_, err = NewOptions(&Options{"log": ioutil.Discard, "UUID": id})
+			Expect(err).To(BeNil())
+		})
+
+		It("mints the same UUIDs of a seed and its UUID", func() {
+			id := uuid.NewSHA1(uuid.Nil, []byte("ginkgo"))
+			var bodies []string
+			for _, o := range []Options{{"seed": "ginkgo"}, {"uuid": id.String()}} {
+				o["log"] = ioutil.Discard
+				response := httptest.NewRecorder()
+				request, _ := http.NewRequest("POST", "/", strings.NewReader(`[{"_id":"a"}]`))
+				NewTestServer(o).ServeHTTP(response, request)
+				Expect(response.Code).To(Equal(200))
+				bodies = append(bodies, response.Body.String())
+			}
+			Expect(bodies[0]).NotTo(ContainSubstring(`"a"`))
+			Expect(bodies[1]).To(Equal(bodies[0]))
+		})
+
+		It("returns errors instead of panicking", func() {
+			for _, o := range []Options{
+				{},
+				{"seed": 42},
+				{"seed": " "},
+				{"uuid": "not-a-uuid"},
+				{"uuid": uuid.Nil.String()},
+				{"UUID": 42},
+				{"seed": "ginkgo", "log": "stdout"},
+				{"seed": "ginkgo", "level": "LOUD"},
+				{"seed": "ginkgo", "routes": "mint,unknown"},
+				{"seed": "ginkgo", "keep": 1},
+				{"seed": "ginkgo", "seeds": "test"},
+				{"seed": "ginkgo", "seeds": map[string]interface{}{"test": 1}},
+				{"seed": "ginkgo", "seeds": map[string]interface{}{"test": ""}},
+				{"seed": "ginkgo", "pipes": "substations"},
+				{"seed": "ginkgo", "pipes": map[string]interface{}{"substations": map[string]interface{}{"seed": "other"}}},
+				{"seed": "ginkgo", "pipes": map[string]interface{}{"substations": map[string]interface{}{"partial": "yes"}}},
+			} {
+				_, err := NewOptions(&o)
+				Expect(err).NotTo(BeNil(), "%v", o)
+			}
+		})
+
+		It("refuses a server without UUID namespace", func() {
+			so, _ := NewOptions(&Options{})
+			_, err := NewServer(so)
+			Expect(err).NotTo(BeNil())
+		})
 	})
 
 })
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// mediaProblem is the media type of problem details responses (RFC 7807)
+const mediaProblem string = "application/problem+json"
+
+// trailerProblem is the HTTP trailer of the problem details after the response of a stream has started
+const trailerProblem string = "X-Problem"
+
+// codes of problems
+const (
+	problemNotAcceptable    string = "not_acceptable"
+	problemUnsupportedMedia string = "unsupported_media_type"
+	problemMalformedRequest string = "malformed_request"
+	problemMissingArray     string = "missing_array"
+	problemNotAnObject      string = "not_an_object"
+	problemInvalidKeyspec   string = "invalid_keyspec"
+	problemUnknownSeed      string = "unknown_seed"
+	problemInvalidType      string = "invalid_rdf_type"
+	problemMint             string = "mint_failed"
+	problemConversion       string = "conversion_failed"
+	problemValidation       string = "validation_failed"
+	problemNotFound         string = "not_found"
+	problemNotImplemented   string = "not_implemented"
+	problemTooLarge         string = "request_too_large"
+	problemQueueFull        string = "queue_full"
+	problemQueueTimeout     string = "queue_timeout"
+	problemUnauthorized     string = "unauthorized"
+	problemForbidden        string = "forbidden"
+	problemInternal         string = "internal_error"
+)
+
+// problemType prefixes the code of a problem to the URI of its type
+const problemType string = "urn:cimrdf:problem:"
+
+// problem is a JSON problem details response (RFC 7807) with the code of the problem, and where relevant
+// the zero-based index and '_id' of the offending entity or model and the keyspec being minted
+type problem struct {
+	Type      string      `json:"type"`
+	Title     string      `json:"title"`
+	Status    int         `json:"status"`
+	Code      string      `json:"code"`
+	Detail    string      `json:"detail"`
+	RequestID string      `json:"request_id,omitempty"`
+	Index     *int        `json:"index,omitempty"`
+	ID        interface{} `json:"_id,omitempty"`
+	Keyspec   string      `json:"keyspec,omitempty"`
+}
+
+func newProblem(status int, code string, format string, args ...interface{}) *problem {
+	return &problem{Type: problemType + code, Title: http.StatusText(status), Status: status, Code: code,
+		Detail: fmt.Sprintf(format, args...)}
+}
+
+func (p *problem) Error() string {
+	return p.Detail
+}
+
+// at sets the index and the '_id' (if any) of the offending entity or model
+func (p *problem) at(index int, id interface{}) *problem {
+	p.Index, p.ID = &index, id
+	return p
+}
+
+// of sets the keyspec being minted
+func (p *problem) of(keyspec string) *problem {
+	p.Keyspec = keyspec
+	return p
+}
+
+// report logs the problem of the request, with its index, '_id' and keyspec
+func (s *Server) report(r *http.Request, p *problem) *problem {
+	log := s.log(r)
+	if p.Index != nil {
+		log = log.With("index", *p.Index)
+	}
+	if p.ID != nil {
+		log = log.With("_id", p.ID)
+	}
+	if len(p.Keyspec) != 0 {
+		log = log.With("keyspec", p.Keyspec)
+	}
+	log.Errorf("error: %s", p.Detail)
+	return p
+}
+
+// fail logs the problem of the request and responds with it
+func (s *Server) fail(w http.ResponseWriter, r *http.Request, p *problem) {
+	writeProblem(w, s.report(r, p))
+}
+
+// writeProblem responds with the problem, with the request ID of the response
+func writeProblem(w http.ResponseWriter, p *problem) {
+	p.RequestID = w.Header().Get(headerRequestID)
+	data, _ := json.Marshal(p)
+	w.Header().Set("Content-Type", mediaProblem)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(p.Status)
+	w.Write(data)
+}
@@ -0,0 +1,137 @@
+package main_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "sesam-cimrdf"
+)
+
+var _ = Describe("Microservice problem responses", func() {
+
+	var (
+		server   *Server
+		response *httptest.ResponseRecorder
+	)
+
+	Post := func(url string, body string) map[string]interface{} {
+		request, _ := http.NewRequest("POST", url, strings.NewReader(body))
+		request.Header.Set("X-Request-ID", "req-1")
+		server.ServeHTTP(response, request)
+		var problem map[string]interface{}
+		if response.Code != 200 {
+			Expect(response.Header().Get("Content-Type")).To(Equal("application/problem+json"))
+			Expect(json.Unmarshal(response.Body.Bytes(), &problem)).To(Succeed())
+		}
+		return problem
+	}
+
+	BeforeEach(func() {
+		server = NewTestServer(Options{"log": ioutil.Discard, "seed": "ginkgo"})
+		response = httptest.NewRecorder()
+	})
+
+	It("tells the code, message and index of the offending entity", func() {
+		problem := Post("/", `[5]`)
+		Expect(response.Code).To(Equal(400))
+		Expect(problem).To(HaveKeyWithValue("status", BeEquivalentTo(400)))
+		Expect(problem).To(HaveKeyWithValue("title", "Bad Request"))
+		Expect(problem).To(HaveKeyWithValue("code", "not_an_object"))
+		Expect(problem).To(HaveKeyWithValue("type", "urn:cimrdf:problem:not_an_object"))
+		Expect(problem).To(HaveKeyWithValue("detail", ContainSubstring("expected JSON object")))
+		Expect(problem).To(HaveKeyWithValue("index", BeEquivalentTo(0)))
+		Expect(problem).To(HaveKeyWithValue("request_id", "req-1"))
+	})
+
+	It("reports problems after the first entity in a trailer", func() {
+		Post("/", `[{"_id": "a"}, {"_id": "b"}, 5]`)
+		Expect(response.Code).To(Equal(200))
+		var problem map[string]interface{}
+		Expect(json.Unmarshal([]byte(response.Result().Trailer.Get("X-Problem")), &problem)).To(Succeed())
+		Expect(problem).To(HaveKeyWithValue("code", "not_an_object"))
+		Expect(problem).To(HaveKeyWithValue("index", BeEquivalentTo(2)))
+	})
+
+	It("tells the invalid keyspec", func() {
+		problem := Post("/_id;;name", `[{"_id": "a"}]`)
+		Expect(response.Code).To(Equal(400))
+		Expect(problem).To(HaveKeyWithValue("code", "invalid_keyspec"))
+		Expect(problem).To(HaveKeyWithValue("detail", ContainSubstring("keyspec ''")))
+
+		response = httptest.NewRecorder()
+		problem = Post("/_", `[{"_id": "a"}]`)
+		Expect(response.Code).To(Equal(400))
+		Expect(problem).To(HaveKeyWithValue("keyspec", "_"))
+	})
+
+	It("tells the index and _id of an entity with an invalid rdf:type", func() {
+		problem := Post("/_id/cim:", `[{"_id": "a", "rdf:type": [5]}]`)
+		Expect(response.Code).To(Equal(400))
+		Expect(problem).To(HaveKeyWithValue("code", "invalid_rdf_type"))
+		Expect(problem).To(HaveKeyWithValue("index", BeEquivalentTo(0)))
+		Expect(problem).To(HaveKeyWithValue("_id", "a"))
+		Expect(problem).To(HaveKeyWithValue("keyspec", "_id"))
+	})
+
+	It("tells the index and _id of the model failing conversion", func() {
+		problem := Post("/convert", `[{"_id": "m0", "names": 5, "cim:Model.all": []}]`)
+		Expect(response.Code).To(Equal(400))
+		Expect(problem).To(HaveKeyWithValue("code", "conversion_failed"))
+		Expect(problem).To(HaveKeyWithValue("index", BeEquivalentTo(0)))
+		Expect(problem).To(HaveKeyWithValue("_id", "m0"))
+	})
+
+	It("reports models failing conversion after the first model in a trailer", func() {
+		Post("/convert", `[{"_id": "m0", "cim:Model.all": []}, {"_id": "m1", "names": 5, "cim:Model.all": []}]`)
+		Expect(response.Code).To(Equal(200))
+		Expect(response.Body.String()).To(HavePrefix(`[{"_id":"m0"`))
+		Expect(response.Body.String()).NotTo(HaveSuffix("]"))
+		var problem map[string]interface{}
+		Expect(json.Unmarshal([]byte(response.Result().Trailer.Get("X-Problem")), &problem)).To(Succeed())
+		Expect(problem).To(HaveKeyWithValue("code", "conversion_failed"))
+		Expect(problem).To(HaveKeyWithValue("index", BeEquivalentTo(1)))
+		Expect(problem).To(HaveKeyWithValue("_id", "m1"))
+		Expect(problem).To(HaveKeyWithValue("request_id", "req-1"))
+	})
+
+	It("tells the index and _id of a model whose entities are not an array", func() {
+		for _, entities := range []string{`5`, `"x"`, `{}`} {
+			response = httptest.NewRecorder()
+			Post("/convert", `[{"_id": "m0", "cim:Model.all": []}, {"_id": "m1", "cim:Model.all": `+entities+`}]`)
+			Expect(response.Code).To(Equal(200))
+			var problem map[string]interface{}
+			Expect(json.Unmarshal([]byte(response.Result().Trailer.Get("X-Problem")), &problem)).To(Succeed())
+			Expect(problem).To(HaveKeyWithValue("code", "conversion_failed"))
+			Expect(problem).To(HaveKeyWithValue("detail", ContainSubstring("'cim:Model.all' to be a JSON array")))
+			Expect(problem).To(HaveKeyWithValue("index", BeEquivalentTo(1)))
+			Expect(problem).To(HaveKeyWithValue("_id", "m1"))
+		}
+	})
+
+	It("tells the index of a model failing conversion in a run being assembled", func() {
+		Post("/convert?is_first=true&is_last=false&pipe_id=p", `[{"_id": "m0", "cim:Model.all": []}]`)
+		Expect(response.Code).To(Equal(200))
+		response = httptest.NewRecorder()
+		problem := Post("/convert?is_last=true&pipe_id=p", `[{"_id": "m1", "names": 5, "cim:Model.all": []}]`)
+		Expect(response.Code).To(Equal(200))
+		Expect(problem).To(BeNil())
+		Expect(json.Unmarshal([]byte(response.Result().Trailer.Get("X-Problem")), &problem)).To(Succeed())
+		Expect(problem).To(HaveKeyWithValue("index", BeEquivalentTo(1)))
+		Expect(problem).To(HaveKeyWithValue("_id", "m1"))
+	})
+
+	It("responds with problems to unsupported media types", func() {
+		request, _ := http.NewRequest("POST", "/convert", strings.NewReader(`[]`))
+		request.Header.Set("Accept", "image/png")
+		server.ServeHTTP(response, request)
+		Expect(response.Code).To(Equal(406))
+		Expect(response.Header().Get("Content-Type")).To(Equal("application/problem+json"))
+		Expect(response.Body.String()).To(ContainSubstring(`"code":"not_acceptable"`))
+	})
+})
@@ -0,0 +1,388 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+const (
+	objLiteral = iota // plain literal value
+	objLocal          // UUID of a resource local to the model
+	objName           // namespaced name "<prefix>:<name>" expanded with the model namespaces
+)
+
+// statement is an RDF triple of a converted model
+type statement struct {
+	subject   string // UUID of the described resource
+	predicate string // namespaced property "<prefix>:<Class.attribute>"
+	object    string
+	kind      int
+}
+
+// graph holds the statements of a converted model in the order they are written
+type graph struct {
+	ns         map[string]string
+	difference bool
+	about      string      // urn:uuid-scheme identifier of the difference model
+	forward    []statement // all statements unless a difference model
+	reverse    []statement // difference models only
+}
+
+// statementsOf returns the statements describing the resource with the properties having namespaces in ns
+func statementsOf(res *resource, ns map[string]string, filter *Filter) []statement {
+	subject := res.id[posUUID:]
+	stmts := []statement{{subject: subject, predicate: "rdf:type", object: res.name + ":" + res.class, kind: objName}}
+	var local []statement
+
+	keys := make([]string, 0, len(res.entity))
+	for k := range res.entity {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if skip, exists := skipKeys[k]; exists {
+			if skip {
+				continue
+			}
+		}
+		parts := strings.Split(k, ":")
+		if len(parts) != 2 {
+			continue
+		}
+		prefix := parts[0]
+		attr := parts[1]
+		if _, exists := ns[prefix]; !exists || !filter.property(prefix) {
+			continue
+		}
+		var value interface{}
+		dec := json.NewDecoder(bytes.NewReader(res.entity[k]))
+		dec.UseNumber() // keeps numbers as given instead of float64 formatting
+		if err := dec.Decode(&value); err != nil {
+			continue
+		}
+		switch attrValue := value.(type) {
+		case nil:
+			continue
+		case string:
+			stmts = append(stmts, valueStatement(subject, k, attrValue, ns))
+		case map[string]interface{}:
+			localID := uuid.NewSHA1(uuid.Nil, []byte(fmt.Sprintf("%s:%s:%s", res.id, prefix, attr))).String()
+			stmts = append(stmts, statement{subject: subject, predicate: k, object: localID, kind: objLocal})
+			localKeys := make([]string, 0, len(attrValue))
+			for localKey := range attrValue {
+				localKeys = append(localKeys, localKey)
+			}
+			sort.Strings(localKeys)
+			for _, localKey := range localKeys {
+				if len(strings.Split(localKey, ":")) != 2 {
+					continue
+				}
+				switch localValue := attrValue[localKey].(type) {
+				case nil, map[string]interface{}, []interface{}:
+					continue
+				case string:
+					local = append(local, valueStatement(localID, localKey, localValue, ns))
+				default:
+					local = append(local, statement{subject: localID, predicate: localKey, object: fmt.Sprintf("%v", localValue), kind: objLiteral})
+				}
+			}
+		case []interface{}:
+			continue
+		default:
+			stmts = append(stmts, statement{subject: subject, predicate: k, object: fmt.Sprintf("%v", attrValue), kind: objLiteral})
+		}
+	}
+	return append(stmts, local...)
+}
+
+// valueStatement returns the statement for a string value, being a reference when of format '~:<namespace>:<name or UUID>'
+func valueStatement(subject string, predicate string, value string, ns map[string]string) statement {
+	if pieces := strings.Split(value, ":"); len(pieces) == 3 && pieces[0] == "~" {
+		localRef := pieces[2]
+		localNS := pieces[1]
+		if len(strings.Split(localRef, "-")) == 5 {
+			return statement{subject: subject, predicate: predicate, object: localRef, kind: objLocal}
+		} else if _, exists := ns[localNS]; exists {
+			return statement{subject: subject, predicate: predicate, object: localNS + ":" + localRef, kind: objName}
+		}
+	}
+	return statement{subject: subject, predicate: predicate, object: value, kind: objLiteral}
+}
+
+// expand returns the IRI of a namespaced name, or the name itself when its namespace is unknown
+func (g *graph) expand(name string) string {
+	if i := strings.Index(name, ":"); i > 0 {
+		if iri, exists := g.ns[name[:i]]; exists {
+			return iri + name[i+1:]
+		}
+	}
+	return name
+}
+
+// empty reports whether the graph has no statements
+func (g *graph) empty() bool {
+	return len(g.forward) == 0 && len(g.reverse) == 0
+}
+
+// writeRDFXML writes the graph as a CIM RDF/XML document, or nothing when the graph is empty
+func (g *graph) writeRDFXML(result *bytes.Buffer) {
+	if g.empty() {
+		return
+	}
+	result.WriteString(headerXML)
+	result.WriteRune('\n')
+	if !g.difference {
+		result.WriteString(headerRDF)
+		result.WriteRune('\n')
+		g.writeDescriptions(result, g.forward)
+		result.WriteString(footerRDF)
+		return
+	}
+	result.WriteString(headerDifferenceRDF)
+	result.WriteRune('\n')
+	result.WriteString(fmt.Sprintf("  <dm:DifferenceModel rdf:about=\"%s\">\n", g.about))
+	result.WriteString("  <dm:forwardDifferences rdf:parseType=\"Statements\">\n")
+	g.writeDescriptions(result, g.forward)
+	result.WriteString("  </dm:forwardDifferences>\n")
+	result.WriteString("  <dm:reverseDifferences rdf:parseType=\"Statements\">\n")
+	g.writeDescriptions(result, g.reverse)
+	result.WriteString("  </dm:reverseDifferences>\n")
+	result.WriteString("  </dm:DifferenceModel>\n")
+	result.WriteString(footerRDF)
+}
+
+// writeDescriptions writes one RDF/XML node element for each run of statements about the same subject
+func (g *graph) writeDescriptions(result *bytes.Buffer, stmts []statement) {
+	for i := 0; i < len(stmts); {
+		j := i
+		element := "rdf:Description"
+		for ; j < len(stmts) && stmts[j].subject == stmts[i].subject; j++ {
+			if stmts[j].predicate == "rdf:type" && stmts[j].kind == objName {
+				element = stmts[j].object
+			}
+		}
+		result.WriteString(fmt.Sprintf("  <%s rdf:about=\"_%s\">\n", element, stmts[i].subject))
+		for _, stmt := range stmts[i:j] {
+			switch {
+			case stmt.predicate == "rdf:type" && stmt.object == element:
+				continue
+			case stmt.kind == objLocal:
+				result.WriteString(fmt.Sprintf("    <%s rdf:resource=\"#_%s\"/>\n", stmt.predicate, stmt.object))
+			case stmt.kind == objName:
+				result.WriteString(fmt.Sprintf("    <%s rdf:resource=\"%s\"/>\n", stmt.predicate, escapeXML(g.expand(stmt.object))))
+			default:
+				result.WriteString(fmt.Sprintf("    <%s>%s</%s>\n", stmt.predicate, escapeXML(stmt.object), stmt.predicate))
+			}
+		}
+		result.WriteString(fmt.Sprintf("  </%s>\n", element))
+		i = j
+	}
+}
+
+// escapeXML returns the text with XML special characters escaped
+func escapeXML(text string) string {
+	var buf bytes.Buffer
+	xml.EscapeText(&buf, []byte(text))
+	return buf.String()
+}
+
+// ntriple returns the statement in N-Triples syntax, with local resources as urn:uuid-scheme IRIs
+func (g *graph) ntriple(stmt statement) string {
+	var object string
+	switch stmt.kind {
+	case objLocal:
+		object = "<urn:uuid:" + stmt.object + ">"
+	case objName:
+		object = "<" + g.expand(stmt.object) + ">"
+	default:
+		data, _ := json.Marshal(stmt.object) // JSON string escapes are valid N-Triples escapes, apart from '\/' which is never produced
+		object = string(data)
+	}
+	return fmt.Sprintf("<urn:uuid:%s> <%s> %s .", stmt.subject, g.expand(stmt.predicate), object)
+}
+
+// triples returns the forward statements of the graph as RDF triples, with local resources as urn:uuid-scheme IRIs
+func (g *graph) triples() []triple {
+	triples := make([]triple, len(g.forward))
+	for i, stmt := range g.forward {
+		t := triple{s: iri("urn:uuid:" + stmt.subject), p: iri(g.expand(stmt.predicate))}
+		switch stmt.kind {
+		case objLocal:
+			t.o = iri("urn:uuid:" + stmt.object)
+		case objName:
+			t.o = iri(g.expand(stmt.object))
+		default:
+			t.o = literal(stmt.object, "")
+		}
+		triples[i] = t
+	}
+	return triples
+}
+
+// digest returns the SHA-256 of the canonical form of the graph, being its N-Triples lines sorted and
+// thereby independent of property order, leaving out 'md:Model.version' which may be derived from the digest itself
+func (g *graph) digest() string {
+	var lines []string
+	for _, stmt := range g.forward {
+		if stmt.predicate != "md:Model.version" {
+			lines = append(lines, g.ntriple(stmt))
+		}
+	}
+	for _, stmt := range g.reverse {
+		if stmt.predicate != "md:Model.version" {
+			lines = append(lines, "- "+g.ntriple(stmt)) // reverse differences are distinct from forward ones
+		}
+	}
+	sort.Strings(lines)
+	hash := sha256.New()
+	for _, line := range lines {
+		hash.Write([]byte(line))
+		hash.Write([]byte{'\n'})
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// version sets 'md:Model.version' of every model description (md:FullModel) in the forward statements
+func (g *graph) version(version string) {
+	headers := make(map[string]bool)
+	for _, stmt := range g.forward {
+		if stmt.predicate == "rdf:type" && stmt.kind == objName && strings.HasPrefix(stmt.object, "md:") {
+			headers[stmt.subject] = true
+		}
+	}
+	stmts := make([]statement, 0, len(g.forward)+len(headers))
+	for i, stmt := range g.forward {
+		if !headers[stmt.subject] || stmt.predicate != "md:Model.version" {
+			stmts = append(stmts, stmt)
+		}
+		if headers[stmt.subject] && (i+1 == len(g.forward) || g.forward[i+1].subject != stmt.subject) {
+			stmts = append(stmts, statement{subject: stmt.subject, predicate: "md:Model.version", object: version, kind: objLiteral})
+		}
+	}
+	g.forward = stmts
+}
+
+// mergeGraphs returns a single graph of the statements of all graphs, using the namespaces of all of them
+func mergeGraphs(graphs []*graph) *graph {
+	merged := &graph{ns: make(map[string]string)}
+	for _, g := range graphs {
+		if g == nil {
+			continue
+		}
+		for prefix, iri := range g.ns {
+			merged.ns[prefix] = iri
+		}
+		if g.difference && !merged.difference {
+			merged.difference = true
+			merged.about = g.about
+		}
+		merged.forward = append(merged.forward, g.forward...)
+		merged.reverse = append(merged.reverse, g.reverse...)
+	}
+	return merged
+}
+
+// writeNTriples writes the forward statements of the graph as N-Triples
+func (g *graph) writeNTriples(result *bytes.Buffer) {
+	for _, stmt := range g.forward {
+		result.WriteString(g.ntriple(stmt))
+		result.WriteRune('\n')
+	}
+}
+
+var turtleLocal = regexp.MustCompile(`^[A-Za-z_]([A-Za-z0-9_.-]*[A-Za-z0-9_-])?$`)
+
+// compact returns the namespaced name in Turtle syntax, or the expanded IRI when not a valid prefixed name
+func (g *graph) compact(name string) string {
+	if i := strings.Index(name, ":"); i > 0 {
+		if _, exists := g.ns[name[:i]]; exists && turtleLocal.MatchString(name[i+1:]) {
+			return name
+		}
+	}
+	return "<" + g.expand(name) + ">"
+}
+
+// writeTurtle writes the forward statements of the graph as Turtle, with a predicate list per subject
+func (g *graph) writeTurtle(result *bytes.Buffer) {
+	prefixes := make([]string, 0, len(g.ns))
+	for prefix := range g.ns {
+		if turtleLocal.MatchString(prefix) && !strings.Contains(prefix, ".") {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+	sort.Strings(prefixes)
+	for _, prefix := range prefixes {
+		result.WriteString(fmt.Sprintf("@prefix %s: <%s> .\n", prefix, g.ns[prefix]))
+	}
+	for i, stmt := range g.forward {
+		if i == 0 || g.forward[i-1].subject != stmt.subject {
+			result.WriteString(fmt.Sprintf("\n<urn:uuid:%s>", stmt.subject))
+		} else {
+			result.WriteString(" ;")
+		}
+		predicate := g.compact(stmt.predicate)
+		if stmt.predicate == "rdf:type" {
+			predicate = "a"
+		}
+		var object string
+		switch stmt.kind {
+		case objLocal:
+			object = "<urn:uuid:" + stmt.object + ">"
+		case objName:
+			object = g.compact(stmt.object)
+		default:
+			data, _ := json.Marshal(stmt.object)
+			object = string(data)
+		}
+		result.WriteString(fmt.Sprintf("\n    %s %s", predicate, object))
+		if i+1 == len(g.forward) || g.forward[i+1].subject != stmt.subject {
+			result.WriteString(" .\n")
+		}
+	}
+}
+
+// jsonLD returns the forward statements of the graph as a JSON-LD document with the namespaces as context
+func (g *graph) jsonLD() map[string]interface{} {
+	context := make(map[string]interface{}, len(g.ns))
+	for prefix, iri := range g.ns {
+		context[prefix] = iri
+	}
+	nodes := []map[string]interface{}{}
+	var node map[string]interface{}
+	for i, stmt := range g.forward {
+		if i == 0 || g.forward[i-1].subject != stmt.subject {
+			node = map[string]interface{}{"@id": "urn:uuid:" + stmt.subject}
+			nodes = append(nodes, node)
+		}
+		var key string
+		var value interface{}
+		switch {
+		case stmt.predicate == "rdf:type":
+			key, value = "@type", stmt.object
+		case stmt.kind == objLocal:
+			key, value = stmt.predicate, map[string]string{"@id": "urn:uuid:" + stmt.object}
+		case stmt.kind == objName:
+			key, value = stmt.predicate, map[string]string{"@id": stmt.object}
+		default:
+			key, value = stmt.predicate, stmt.object
+		}
+		switch existing := node[key].(type) {
+		case nil:
+			node[key] = value
+		case []interface{}:
+			node[key] = append(existing, value)
+		default:
+			node[key] = []interface{}{existing, value}
+		}
+	}
+	return map[string]interface{}{"@context": context, "@graph": nodes}
+}
@@ -0,0 +1,124 @@
+package main_test
+
+import (
+	"bytes"
+	"encoding/json"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "sesam-cimrdf"
+)
+
+func NewRDFXML(input string) string {
+	var buf bytes.Buffer
+	rw := NewInputOutput(input, "", &buf)
+	err := Convert(rw, &Options{"json": "json"}, 0)
+	rw.Flush()
+	Expect(err).To(BeNil())
+	var models []map[string]interface{}
+	Expect(json.Unmarshal(buf.Bytes(), &models)).To(Succeed())
+	Expect(models).To(HaveLen(1))
+	Expect(models[0]).To(HaveKey("xml"))
+	return models[0]["xml"].(string)
+}
+
+var _ = Describe("Microservice RDF/XML serialization", func() {
+
+	It("writes the properties of a resource in sorted order", func() {
+		xml := NewRDFXML(`[{` + namespaces + `
+			,"json":[
+				{
+					"$ids": ["urn:uuid:00000000-0000-0000-0000-000000000001", "~:Substation:00000000-0000-0000-0000-000000000001"],
+					"_id": "urn:uuid:00000000-0000-0000-0000-000000000001",
+					"cim:Substation.Region": "~:SubGeographicalRegion:00000000-0000-0000-0000-000000000002",
+					"cim:IdentifiedObject.name": "first",
+					"cim:IdentifiedObject.description": "second",
+					"rdf:type": "~:cim:Substation"
+				}
+			]
+		}]`)
+		Expect(xml).To(ContainSubstring(`  <cim:Substation rdf:about="_00000000-0000-0000-0000-000000000001">
+    <cim:IdentifiedObject.description>second</cim:IdentifiedObject.description>
+    <cim:IdentifiedObject.name>first</cim:IdentifiedObject.name>
+    <cim:Substation.Region rdf:resource="#_00000000-0000-0000-0000-000000000002"/>
+  </cim:Substation>
+`))
+	})
+
+	It("escapes XML special characters of literals", func() {
+		xml := NewRDFXML(`[{` + namespaces + `
+			,"json":[
+				{
+					"$ids": ["urn:uuid:00000000-0000-0000-0000-000000000001", "~:Substation:00000000-0000-0000-0000-000000000001"],
+					"_id": "urn:uuid:00000000-0000-0000-0000-000000000001",
+					"cim:IdentifiedObject.name": "<A & B>",
+					"rdf:type": "~:cim:Substation"
+				}
+			]
+		}]`)
+		Expect(xml).To(ContainSubstring(`<cim:IdentifiedObject.name>&lt;A &amp; B&gt;</cim:IdentifiedObject.name>`))
+	})
+
+	It("writes numbers as given", func() {
+		xml := NewRDFXML(`[{` + namespaces + `
+			,"json":[
+				{
+					"$ids": ["urn:uuid:00000000-0000-0000-0000-000000000001", "~:BaseVoltage:00000000-0000-0000-0000-000000000001"],
+					"_id": "urn:uuid:00000000-0000-0000-0000-000000000001",
+					"cim:BaseVoltage.nominalVoltage": 1000000,
+					"rdf:type": "~:cim:BaseVoltage"
+				}
+			]
+		}]`)
+		Expect(xml).To(ContainSubstring(`<cim:BaseVoltage.nominalVoltage>1000000</cim:BaseVoltage.nominalVoltage>`))
+	})
+
+	It("writes local resources as descriptions of their own after the resource", func() {
+		xml := NewRDFXML(`[{` + namespaces + `
+			,"json":[
+				{
+					"$ids": ["urn:uuid:00000000-0000-0000-0000-000000000001", "~:Substation:00000000-0000-0000-0000-000000000001"],
+					"_id": "urn:uuid:00000000-0000-0000-0000-000000000001",
+					"cim:PowerSystemResource.Location": {"cim:Location.mainAddress": "Street 1"},
+					"rdf:type": "~:cim:Substation"
+				}
+			]
+		}]`)
+		Expect(xml).To(MatchRegexp(`(?s)<cim:Substation rdf:about="_00000000-0000-0000-0000-000000000001">
+    <cim:PowerSystemResource.Location rdf:resource="#_([0-9a-f-]{36})"/>
+  </cim:Substation>
+  <rdf:Description rdf:about="_([0-9a-f-]{36})">
+    <cim:Location.mainAddress>Street 1</cim:Location.mainAddress>
+  </rdf:Description>
+`))
+	})
+
+	It("writes each model on its own", func() {
+		var buf bytes.Buffer
+		rw := NewInputOutput(`[{`+namespaces+`
+			,"json":[
+				{
+					"$ids": ["urn:uuid:00000000-0000-0000-0000-000000000001", "~:Substation:00000000-0000-0000-0000-000000000001"],
+					"_id": "urn:uuid:00000000-0000-0000-0000-000000000001",
+					"rdf:type": "~:cim:Substation"
+				}
+			]
+		}, {`+namespaces+`
+			,"json":[
+				{
+					"$ids": ["urn:uuid:00000000-0000-0000-0000-000000000002", "~:Line:00000000-0000-0000-0000-000000000002"],
+					"_id": "urn:uuid:00000000-0000-0000-0000-000000000002",
+					"rdf:type": "~:cim:Line"
+				}
+			]
+		}]`, "", &buf)
+		Expect(Convert(rw, &Options{"json": "json"}, 0)).To(BeNil())
+		rw.Flush()
+		var models []map[string]interface{}
+		Expect(json.Unmarshal(buf.Bytes(), &models)).To(Succeed())
+		Expect(models).To(HaveLen(2))
+		Expect(models[1]["xml"]).To(ContainSubstring("cim:Line"))
+		Expect(models[1]["xml"]).NotTo(ContainSubstring("cim:Substation"))
+	})
+})
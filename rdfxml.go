@@ -0,0 +1,226 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// rdfxml is a parser of RDF/XML (https://www.w3.org/TR/rdf-syntax-grammar/) as used by CIM/CGMES documents,
+// where 'rdf:about="_<UUID>"', 'rdf:ID="_<UUID>"' and 'rdf:resource="#_<UUID>"' all name the resource 'urn:uuid:<UUID>'
+type rdfxml struct {
+	dec     *xml.Decoder
+	base    string
+	blanks  int
+	depth   int // of the node and property elements being parsed
+	triples []triple
+}
+
+// parseRDFXML returns the triples of an RDF/XML document, with relative IRIs resolved against base
+func parseRDFXML(r io.Reader, base string) ([]triple, error) {
+	p := &rdfxml{dec: xml.NewDecoder(r), base: base}
+	for {
+		tok, err := p.dec.Token()
+		if err == io.EOF {
+			return p.triples, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("RDF/XML syntax error: %s", err)
+		}
+		if tok == nil {
+			return nil, fmt.Errorf("RDF/XML syntax error: no token at offset %d", p.dec.InputOffset())
+		}
+		if start, ok := tok.(xml.StartElement); ok {
+			if b := attr(start, xmlNS, "base"); b != "" {
+				p.base = b
+			}
+			if start.Name.Space == nsRDF && start.Name.Local == "RDF" {
+				if err = p.nodeElements(); err != nil {
+					return nil, err
+				}
+			} else if _, err = p.nodeElement(start); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+const xmlNS string = "http://www.w3.org/XML/1998/namespace"
+
+func attr(e xml.StartElement, space string, local string) string {
+	for _, a := range e.Attr {
+		if a.Name.Space == space && a.Name.Local == local {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// token returns the next token, failing at the end of the document and when the decoder makes no progress
+func (p *rdfxml) token() (xml.Token, error) {
+	tok, err := p.dec.Token()
+	if err != nil {
+		return nil, fmt.Errorf("RDF/XML syntax error: %s", err)
+	}
+	if tok == nil {
+		return nil, fmt.Errorf("RDF/XML syntax error: no token at offset %d", p.dec.InputOffset())
+	}
+	return tok, nil
+}
+
+// enter counts an element nested in those being parsed, failing beyond maxNesting, to be undone by leave
+func (p *rdfxml) enter() error {
+	p.depth++
+	if p.depth > maxNesting {
+		return fmt.Errorf("RDF/XML syntax error: elements nested deeper than %d at offset %d", maxNesting, p.dec.InputOffset())
+	}
+	return nil
+}
+
+func (p *rdfxml) leave() {
+	p.depth--
+}
+
+func (p *rdfxml) blank() term {
+	p.blanks++
+	return term{kind: termBlank, value: fmt.Sprintf("r%d", p.blanks)}
+}
+
+// resource returns the IRI term of a reference, mapping CIM local identifiers to urn:uuid-scheme
+func (p *rdfxml) resource(ref string) term {
+	id := strings.TrimPrefix(ref, "#")
+	if strings.HasPrefix(id, "_") && len(strings.Split(id, "-")) == 5 && !strings.Contains(id, ":") {
+		return iri("urn:uuid:" + id[1:])
+	}
+	return iri(resolve(p.base, ref))
+}
+
+// nodeElements parses node elements until the end of the enclosing element
+func (p *rdfxml) nodeElements() error {
+	for {
+		tok, err := p.token()
+		if err != nil {
+			return err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if _, err = p.nodeElement(t); err != nil {
+				return err
+			}
+		case xml.EndElement:
+			return nil
+		}
+	}
+}
+
+// nodeElement parses a node element and its property elements, returning its subject
+func (p *rdfxml) nodeElement(start xml.StartElement) (term, error) {
+	var subject term
+	if err := p.enter(); err != nil {
+		return subject, err
+	}
+	defer p.leave()
+	switch {
+	case attr(start, nsRDF, "about") != "":
+		subject = p.resource(attr(start, nsRDF, "about"))
+	case attr(start, nsRDF, "ID") != "":
+		subject = p.resource("#" + attr(start, nsRDF, "ID"))
+	case attr(start, nsRDF, "nodeID") != "":
+		subject = term{kind: termBlank, value: "n" + attr(start, nsRDF, "nodeID")}
+	default:
+		subject = p.blank()
+	}
+	if !(start.Name.Space == nsRDF && start.Name.Local == "Description") {
+		p.triples = append(p.triples, triple{subject, iri(nsRDF + "type"), iri(start.Name.Space + start.Name.Local)})
+	}
+	for _, a := range start.Attr {
+		if a.Name.Space == nsRDF || a.Name.Space == xmlNS || a.Name.Space == "xmlns" || a.Name.Space == "" {
+			continue
+		}
+		p.triples = append(p.triples, triple{subject, iri(a.Name.Space + a.Name.Local), literal(a.Value, "")})
+	}
+	for {
+		tok, err := p.token()
+		if err != nil {
+			return subject, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if err = p.propertyElement(subject, t); err != nil {
+				return subject, err
+			}
+		case xml.EndElement:
+			return subject, nil
+		}
+	}
+}
+
+// propertyElement parses a property element of the subject
+func (p *rdfxml) propertyElement(subject term, start xml.StartElement) error {
+	if err := p.enter(); err != nil {
+		return err
+	}
+	defer p.leave()
+	predicate := iri(start.Name.Space + start.Name.Local)
+	if ref := attr(start, nsRDF, "resource"); ref != "" {
+		p.triples = append(p.triples, triple{subject, predicate, p.resource(ref)})
+		return p.dec.Skip()
+	}
+	if id := attr(start, nsRDF, "nodeID"); id != "" {
+		p.triples = append(p.triples, triple{subject, predicate, term{kind: termBlank, value: "n" + id}})
+		return p.dec.Skip()
+	}
+	switch attr(start, nsRDF, "parseType") {
+	case "Resource":
+		node := p.blank()
+		p.triples = append(p.triples, triple{subject, predicate, node})
+		for {
+			tok, err := p.dec.Token()
+			if err != nil {
+				return fmt.Errorf("RDF/XML syntax error: %s", err)
+			}
+			switch t := tok.(type) {
+			case xml.StartElement:
+				if err = p.propertyElement(node, t); err != nil {
+					return err
+				}
+			case xml.EndElement:
+				return nil
+			}
+		}
+	case "Statements": // IEC 61970-552 difference models, where only the forward differences are kept
+		if predicate.value == "http://iec.ch/TC57/61970-552/DifferenceModel/1#reverseDifferences" {
+			return p.dec.Skip()
+		}
+		return p.nodeElements()
+	case "Literal", "Collection":
+		return p.dec.Skip()
+	}
+	var text strings.Builder
+	nested := false
+	for {
+		tok, err := p.token()
+		if err != nil {
+			return err
+		}
+		switch t := tok.(type) {
+		case xml.CharData:
+			text.Write(t)
+		case xml.StartElement:
+			object, err := p.nodeElement(t)
+			if err != nil {
+				return err
+			}
+			p.triples = append(p.triples, triple{subject, predicate, object})
+			nested = true
+		case xml.EndElement:
+			if !nested {
+				lit := literal(text.String(), attr(start, nsRDF, "datatype"))
+				lit.lang = attr(start, xmlNS, "lang")
+				p.triples = append(p.triples, triple{subject, predicate, lit})
+			}
+			return nil
+		}
+	}
+}
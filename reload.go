@@ -0,0 +1,220 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// configWatchInterval is how often the configuration file and the files it references are checked for modifications
+const configWatchInterval = 10 * time.Second
+
+// restartOptions are the options which only take effect when the server is restarted, since minted UUIDs,
+// the registered routes and the listener are fixed while running
+var restartOptions = []string{"seed", "uuid", "seeds", "seed_version", "seed_versions", "routes", "lookup", "lookup_retention", "entities", "entities_retention", "max_concurrent", "max_inflight_bytes", "max_queue", "queue_timeout", "listen", "read_timeout", "write_timeout", "idle_timeout", "shutdown_timeout", "tls_cert", "tls_key", "tls_client_ca"}
+
+type optionsKey struct{}
+
+// current returns the current options of the server
+func (s *Server) current() *serverOptions {
+	return s.config.Load().(*serverOptions)
+}
+
+// optionsOf returns the options the request is served with, or the current options outside of requests
+func (s *Server) optionsOf(r *http.Request) *serverOptions {
+	if r != nil {
+		if o, ok := r.Context().Value(optionsKey{}).(*serverOptions); ok {
+			return o
+		}
+	}
+	return s.current()
+}
+
+// Reload swaps the options of the server for the options as merged by LoadConfig, so that requests in progress
+// finish with the previous options and new requests are served with the new ones; the changes are logged,
+// and the previous options are kept when the new ones are invalid
+func (s *Server) Reload(opt Options) error {
+	s.reloading.Lock()
+	defer s.reloading.Unlock()
+	old := s.current()
+	prev := Options{}
+	if old.options != nil {
+		prev = *old.options
+	}
+	_, newLog := opt["log"]
+	for _, key := range []string{"log", "logger"} {
+		if _, exist := opt[key]; !exist && prev[key] != nil {
+			opt[key] = prev[key]
+		}
+	}
+	so, err := NewOptions(&opt)
+	if err != nil {
+		s.Errorf("error reloading configuration, keeping the previous one: %s", err)
+		return err
+	}
+	if !newLog && old.logger != nil {
+		so.logger.out = old.logger.out // so that lines of requests in progress and new ones do not interleave
+	}
+	so.seed, so.namespace, so.seeds, so.routes = old.seed, old.namespace, old.seeds, old.routes
+	so.version, so.versions = old.version, old.versions
+	for _, po := range so.pipes {
+		po.seed, po.namespace, po.seeds, po.routes = old.seed, old.namespace, old.seeds, old.routes
+		po.version, po.versions = old.version, old.versions
+	}
+
+	changed := changedOptions(prev, opt)
+	if len(changed) == 0 {
+		so.logger.Logkv(logINFO, "reloaded configuration without changes")
+	} else {
+		kv := []interface{}{"changed", changed}
+		if names := changedNames(namesOf(old.convert), namesOf(so.convert)); len(names) != 0 {
+			kv = append(kv, "names", names)
+		}
+		so.logger.Logkv(logLIVE, "reloaded configuration", kv...)
+	}
+	for _, key := range changed {
+		for _, restart := range restartOptions {
+			if key == restart {
+				so.logger.Warnf("option '%s' changed, but takes effect after a restart", key)
+			}
+		}
+	}
+	s.config.Store(&so)
+	return nil
+}
+
+// changedOptions returns the sorted keys of the options with different values, without the values
+// since some are secrets
+func changedOptions(old Options, opt Options) []string {
+	changed := []string{}
+	for key, val := range opt {
+		if !reflect.DeepEqual(old[key], val) {
+			changed = append(changed, key)
+		}
+	}
+	for key := range old {
+		if _, exist := opt[key]; !exist {
+			changed = append(changed, key)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// changedNames describes the namespace prefixes added, removed or changed, sorted by prefix
+func changedNames(old map[string]string, names map[string]string) []string {
+	changed := []string{}
+	for prefix, iri := range names {
+		if was, exist := old[prefix]; !exist {
+			changed = append(changed, fmt.Sprintf("%s: added %s", prefix, iri))
+		} else if was != iri {
+			changed = append(changed, fmt.Sprintf("%s: %s changed to %s", prefix, was, iri))
+		}
+	}
+	for prefix, iri := range old {
+		if _, exist := names[prefix]; !exist {
+			changed = append(changed, fmt.Sprintf("%s: removed %s", prefix, iri))
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// ConfigWatch tells when a configuration file, or a file referenced by its options (option 'mapping' and the files
+// or directories of option 'shapes', also of option 'pipes'), has been modified
+type ConfigWatch struct {
+	file  string
+	paths []string             // referenced by the options
+	seen  map[string]time.Time // modification times of the files, and of those within directories
+}
+
+// NewConfigWatch watches the configuration file, which may be empty, and the files referenced by the options
+func NewConfigWatch(file string, opt Options) *ConfigWatch {
+	cw := &ConfigWatch{file: file}
+	cw.Watch(opt)
+	return cw
+}
+
+// Watch replaces the referenced files watched by those of the options, e.g. after reloading them
+func (cw *ConfigWatch) Watch(opt Options) {
+	cw.paths = referencedFiles(opt)
+	if seen := cw.stat(); seen != nil {
+		cw.seen = seen
+	}
+}
+
+// Changed returns the sorted files modified, added or removed since last checked
+func (cw *ConfigWatch) Changed() []string {
+	seen := cw.stat()
+	if seen == nil {
+		return nil // e.g. while being replaced, so trying again later
+	}
+	changed := []string{}
+	for file, modified := range seen {
+		if was, exist := cw.seen[file]; !exist || !was.Equal(modified) {
+			changed = append(changed, file)
+		}
+	}
+	for file := range cw.seen {
+		if _, exist := seen[file]; !exist {
+			changed = append(changed, file)
+		}
+	}
+	cw.seen = seen
+	sort.Strings(changed)
+	return changed
+}
+
+// stat returns the modification times of the watched files and of the files within watched directories,
+// or nil when the configuration file is missing, whereas missing referenced files count as removed
+func (cw *ConfigWatch) stat() map[string]time.Time {
+	seen := map[string]time.Time{}
+	if len(cw.file) != 0 {
+		info, err := os.Stat(cw.file)
+		if err != nil {
+			return nil
+		}
+		seen[cw.file] = info.ModTime()
+	}
+	for _, path := range cw.paths {
+		filepath.Walk(path, func(file string, info os.FileInfo, err error) error {
+			if err == nil {
+				seen[file] = info.ModTime()
+			}
+			return nil
+		})
+	}
+	return seen
+}
+
+// referencedFiles returns the paths of option 'mapping' and option 'shapes' of the options and of their pipes
+func referencedFiles(opt Options) []string {
+	var paths []string
+	for _, o := range append([]Options{opt}, pipeOptionsOf(opt)...) {
+		if mapping, ok := o["mapping"].(string); ok && len(mapping) != 0 {
+			paths = append(paths, mapping)
+		}
+		switch o["shapes"].(type) {
+		case string, []string, []interface{}:
+			shapes, _ := fieldList(o, "shapes")
+			paths = append(paths, shapes...)
+		}
+	}
+	return paths
+}
+
+// pipeOptionsOf returns the options overridden by option 'pipes', ignoring malformed ones
+func pipeOptionsOf(opt Options) []Options {
+	var result []Options
+	pipes, _ := opt["pipes"].(map[string]interface{})
+	for _, val := range pipes {
+		if o, ok := val.(map[string]interface{}); ok {
+			result = append(result, Options(o))
+		}
+	}
+	return result
+}
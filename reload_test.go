@@ -0,0 +1,125 @@
+package main_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "sesam-cimrdf"
+)
+
+var _ = Describe("Microservice configuration reload", func() {
+
+	var (
+		buf    bytes.Buffer
+		server *Server
+	)
+
+	Mint := func() string {
+		response := httptest.NewRecorder()
+		request, _ := http.NewRequest("POST", "/_id", strings.NewReader(`[{"_id": "a", "_updated": 7}]`))
+		server.ServeHTTP(response, request)
+		Expect(response.Code).To(Equal(200))
+		return response.Body.String()
+	}
+
+	BeforeEach(func() {
+		buf.Reset()
+		server = NewTestServer(Options{"log": &buf, "level": "WARN", "seed": "ginkgo", "names": map[string]interface{}{"cim": "http://iec.ch/TC57/2013/CIM-schema-cim16#"}})
+		buf.Reset()
+	})
+
+	It("serves new requests with the new options and logs what changed", func() {
+		Expect(Mint()).NotTo(ContainSubstring("_updated"))
+		buf.Reset()
+		Expect(server.Reload(Options{"level": "WARN", "seed": "ginkgo", "keep": "_updated", "names": map[string]interface{}{"cim": "http://iec.ch/TC57/CIM100#", "eu": "http://iec.ch/TC57/CIM100-European#"}})).To(Succeed())
+		Expect(Mint()).To(ContainSubstring(`"_updated":7`))
+
+		lines := NewLines(&buf)
+		Expect(lines[0]).To(HaveKeyWithValue("msg", "reloaded configuration"))
+		Expect(lines[0]).To(HaveKeyWithValue("changed", []interface{}{"keep", "names"}))
+		Expect(lines[0]).To(HaveKeyWithValue("names", ContainElement("cim: http://iec.ch/TC57/2013/CIM-schema-cim16# changed to http://iec.ch/TC57/CIM100#")))
+		Expect(lines[0]).To(HaveKeyWithValue("names", ContainElement("eu: added http://iec.ch/TC57/CIM100-European#")))
+	})
+
+	It("keeps the previous options when the new ones are invalid", func() {
+		Expect(server.Reload(Options{"level": "WARN", "seed": "ginkgo", "keep": 42})).NotTo(Succeed())
+		Expect(Mint()).NotTo(ContainSubstring("_updated"))
+		Expect(buf.String()).To(ContainSubstring("keeping the previous one"))
+	})
+
+	It("keeps minting with the seed until restarted", func() {
+		before := Mint()
+		Expect(server.Reload(Options{"level": "WARN", "seed": "other"})).To(Succeed())
+		Expect(Mint()).To(Equal(before))
+		Expect(buf.String()).To(ContainSubstring("option 'seed' changed, but takes effect after a restart"))
+	})
+
+	It("keeps the seed versions until restarted", func() {
+		server = NewTestServer(Options{"log": &buf, "level": "WARN", "seed": "ginkgo-2", "seed_version": "v2", "seed_versions": map[string]interface{}{"v1": "ginkgo"}})
+		Migrate := func() string {
+			response := httptest.NewRecorder()
+			request, _ := http.NewRequest("POST", "/migrate", strings.NewReader(`["a"]`))
+			server.ServeHTTP(response, request)
+			Expect(response.Code).To(Equal(200))
+			return response.Body.String()
+		}
+		before := Migrate()
+		Expect(before).To(ContainSubstring(`"version":"v2"`))
+		buf.Reset()
+		Expect(server.Reload(Options{"level": "WARN", "seed": "ginkgo-2", "seed_version": "v3", "seed_versions": map[string]interface{}{"v1": "ginkgo", "v2": "ginkgo-2"}})).To(Succeed())
+		Expect(Migrate()).To(Equal(before))
+		Expect(buf.String()).To(ContainSubstring("option 'seed_version' changed, but takes effect after a restart"))
+		Expect(buf.String()).To(ContainSubstring("option 'seed_versions' changed, but takes effect after a restart"))
+	})
+
+	It("watches the config file and the mapping and shape files it references", func() {
+		dir, _ := ioutil.TempDir("", "watch")
+		defer os.RemoveAll(dir)
+		file, mapping, shapes := filepath.Join(dir, "config.json"), filepath.Join(dir, "mapping.json"), filepath.Join(dir, "shapes")
+		os.Mkdir(shapes, 0755)
+		ioutil.WriteFile(file, []byte(`{}`), 0644)
+		ioutil.WriteFile(mapping, []byte(`{}`), 0644)
+		ioutil.WriteFile(filepath.Join(shapes, "a.ttl"), []byte(shapesTurtle), 0644)
+		Touch := func(path string, age time.Duration) {
+			os.Chtimes(path, time.Now().Add(age), time.Now().Add(age))
+		}
+
+		cw := NewConfigWatch(file, Options{"pipes": map[string]interface{}{"p": map[string]interface{}{"mapping": mapping}}, "shapes": []interface{}{shapes}})
+		Expect(cw.Changed()).To(BeEmpty())
+		Touch(mapping, time.Minute)
+		Expect(cw.Changed()).To(Equal([]string{mapping}))
+		Expect(cw.Changed()).To(BeEmpty())
+		Touch(filepath.Join(shapes, "a.ttl"), time.Minute)
+		Expect(cw.Changed()).To(Equal([]string{filepath.Join(shapes, "a.ttl")}))
+		ioutil.WriteFile(filepath.Join(shapes, "b.ttl"), []byte(shapesTurtle), 0644)
+		Touch(shapes, time.Hour)
+		Expect(cw.Changed()).To(ConsistOf(shapes, filepath.Join(shapes, "b.ttl")))
+		Touch(file, time.Minute)
+		Expect(cw.Changed()).To(Equal([]string{file}))
+
+		cw.Watch(Options{"mapping": mapping})
+		Touch(filepath.Join(shapes, "a.ttl"), time.Hour)
+		Expect(cw.Changed()).To(BeEmpty())
+		os.Remove(mapping)
+		Expect(cw.Changed()).To(Equal([]string{mapping}))
+	})
+
+	It("changes the log level", func() {
+		Expect(server.Reload(Options{"seed": "ginkgo", "level": "OFF"})).To(Succeed())
+		buf.Reset()
+		Expect(server.Reload(Options{"seed": "ginkgo", "level": "LIVE"})).To(Succeed())
+		Expect(buf.String()).To(ContainSubstring("reloaded configuration"))
+		buf.Reset()
+		Expect(server.Reload(Options{"seed": "ginkgo", "level": "OFF"})).To(Succeed())
+		Expect(buf.String()).To(BeEmpty())
+	})
+})
@@ -1,10 +1,54 @@
 package main
 
+import "github.com/julienschmidt/httprouter"
+
+// routeGroups are the groups of routes which can be enabled, and restricted by JWT claims
+var routeGroups = []string{groupMint, groupConvert, groupValidate, groupSource}
+
 // Routes sets up server URL routes with corresponding handlers
 func (s *Server) Routes() {
-	s.router.POST("/", s.HandleDefault)
-	s.router.POST("/:field", s.HandleField)
-	s.router.POST("/:field/:namespace", s.HandleFieldNamespace)
 	s.router.RedirectTrailingSlash = false // enables special route semantic handling below with trailing slash
-	s.router.POST("/:field/", s.HandleFieldNamespace)
+	routes := s.current().routes
+	if routes[groupMint] {
+		s.router.POST("/", s.handle(groupMint, "/", s.HandleDefault))
+		s.router.POST("/:field", s.handle(groupMint, "/:field", s.HandleField))
+		s.router.POST("/:field/:namespace", s.handle(groupMint, "/:field/:namespace", s.HandleFieldNamespace))
+		s.router.POST("/:field/", s.handle(groupMint, "/:field/", s.HandleFieldNamespace))
+	}
+	// disabled service routes are not found, instead of minting field 'migrate', 'uuid', 'convert' or 'validate'
+	if routes[groupMint] {
+		s.service.POST("/migrate", s.handle(groupMint, "/migrate", s.HandleMigrate))
+		s.service.GET("/uuid", s.handle(groupMint, "/uuid", s.HandleUUID))
+		s.service.POST("/uuid", s.handle(groupMint, "/uuid", s.HandleUUID))
+		s.service.GET("/lookup", s.handle(groupMint, "/lookup", s.HandleLookupExport))
+		s.service.GET("/lookup/:uuid", s.handle(groupMint, "/lookup/:uuid", s.HandleLookup))
+	} else {
+		s.service.POST("/migrate", s.HandleNotFound)
+		s.service.GET("/uuid", s.HandleNotFound)
+		s.service.POST("/uuid", s.HandleNotFound)
+	}
+	if routes[groupConvert] {
+		s.service.POST("/convert", s.handle(groupConvert, "/convert", s.HandleConvert))
+	} else {
+		s.service.POST("/convert", s.HandleNotFound)
+	}
+	if routes[groupValidate] {
+		s.service.POST("/validate", s.handle(groupValidate, "/validate", s.HandleValidate))
+	} else {
+		s.service.POST("/validate", s.HandleNotFound)
+	}
+	if routes[groupSource] {
+		s.service.GET("/entities", s.handle(groupSource, "/entities", s.HandleEntities))
+	} else {
+		s.service.GET("/entities", s.HandleNotFound)
+	}
+	s.service.GET("/health", s.HandleHealth)
+	s.service.GET("/ready", s.HandleReady)
+	s.service.GET("/metrics", s.HandleMetrics)
+}
+
+// handle wraps the handle of a route of the group with its metrics, request logging, authorization, admission within
+// the limits of requests in flight and request size limit
+func (s *Server) handle(group string, route string, handle httprouter.Handle) httprouter.Handle {
+	return instrument(route, s.trace(route, s.authorize(group, s.admit(group, route, s.limit(route, handle)))))
 }
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// listenConfig holds the settings of the HTTP server process
+type listenConfig struct {
+	addr            string
+	readTimeout     time.Duration
+	writeTimeout    time.Duration
+	idleTimeout     time.Duration
+	shutdownTimeout time.Duration
+	tlsCert         string
+	tlsKey          string
+	tlsClientCA     string
+}
+
+// tlsWatchInterval is how often the TLS files are checked for modifications
+const tlsWatchInterval = 10 * time.Second
+
+// listenConfigOf returns the HTTP server settings of options 'listen', 'read_timeout', 'write_timeout',
+// 'idle_timeout', 'shutdown_timeout', 'tls_cert', 'tls_key' and 'tls_client_ca'. The write timeout is none by
+// default, as it would cut off long streamed responses of minting, lookup exports and the entity journal.
+func listenConfigOf(opt Options) (listenConfig, error) {
+	cfg := listenConfig{addr: ":5000", readTimeout: time.Minute, idleTimeout: 2 * time.Minute, shutdownTimeout: 30 * time.Second}
+	for key, s := range map[string]*string{"listen": &cfg.addr, "tls_cert": &cfg.tlsCert, "tls_key": &cfg.tlsKey, "tls_client_ca": &cfg.tlsClientCA} {
+		if val, ok := opt[key].(string); ok && len(strings.Trim(val, " ")) != 0 {
+			*s = strings.Trim(val, " ")
+		}
+	}
+	for key, d := range map[string]*time.Duration{"read_timeout": &cfg.readTimeout, "write_timeout": &cfg.writeTimeout, "idle_timeout": &cfg.idleTimeout, "shutdown_timeout": &cfg.shutdownTimeout} {
+		if val, exist := opt[key]; exist && val != nil {
+			var err error
+			if *d, err = durationOf(val); err != nil {
+				return cfg, fmt.Errorf("option '%s' %s", key, err)
+			}
+		}
+	}
+	if (len(cfg.tlsCert) == 0) != (len(cfg.tlsKey) == 0) {
+		return cfg, fmt.Errorf("expected both a TLS certificate and key file, or neither")
+	}
+	if len(cfg.tlsClientCA) != 0 && len(cfg.tlsCert) == 0 {
+		return cfg, fmt.Errorf("expected a TLS certificate and key file with a TLS client CA bundle")
+	}
+	return cfg, nil
+}
+
+// serve runs the microservice HTTP server until SIGTERM or SIGINT, and then shuts it down gracefully
+// by refusing new connections and letting requests in progress finish; with TLS files it serves HTTPS,
+// reloading the files when modified or on SIGHUP, as well as the configuration
+func serve(args []string) error {
+	opt, file, err := loadConfig(args)
+	if err != nil {
+		return err
+	}
+	cfg, err := listenConfigOf(opt)
+	if err != nil {
+		return err
+	}
+	so, err := NewOptions(&opt)
+	if err != nil {
+		return err
+	}
+	s, err := NewServer(so)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	srv := &http.Server{
+		Addr:         cfg.addr,
+		Handler:      s,
+		ReadTimeout:  cfg.readTimeout,
+		WriteTimeout: cfg.writeTimeout,
+		IdleTimeout:  cfg.idleTimeout,
+	}
+
+	var tf *TLSFiles
+	if len(cfg.tlsCert) != 0 {
+		if tf, err = LoadTLSFiles(cfg.tlsCert, cfg.tlsKey, cfg.tlsClientCA); err != nil {
+			return err
+		}
+		srv.TLSConfig = tf.Config()
+		done := make(chan struct{})
+		defer close(done)
+		go tf.Watch(tlsWatchInterval, done, s.reloadedTLS)
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		if tf != nil {
+			s.Logf(logLIVE, "Listening on %s (HTTPS).", cfg.addr)
+			errc <- srv.ListenAndServeTLS("", "")
+			return
+		}
+		s.Logf(logLIVE, "Listening on %s.", cfg.addr)
+		errc <- srv.ListenAndServe()
+	}()
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGTERM, os.Interrupt)
+	defer signal.Stop(stop)
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	cw := NewConfigWatch(file, opt)
+	watch := time.NewTicker(configWatchInterval)
+	defer watch.Stop()
+	reload := func() {
+		if opt, err := LoadConfig(args); err != nil {
+			s.Errorf("error reloading configuration, keeping the previous one: %s", err)
+		} else if s.Reload(opt) == nil {
+			cw.Watch(opt)
+		}
+	}
+
+	for {
+		select {
+		case err = <-errc:
+			return err
+		case <-hup:
+			reload()
+			if tf != nil {
+				s.reloadedTLS(tf.Reload())
+			}
+		case <-watch.C:
+			if files := cw.Changed(); len(files) != 0 {
+				s.current().logger.Logkv(logLIVE, "configuration files changed", "files", files)
+				reload()
+			}
+		case sig := <-stop:
+			s.Logf(logLIVE, "Received %s, shutting down.", sig)
+			s.SetReady(false)
+			ctx, cancel := context.WithTimeout(context.Background(), cfg.shutdownTimeout)
+			defer cancel()
+			if err = srv.Shutdown(ctx); err != nil {
+				return fmt.Errorf("error shutting down: %s", err)
+			}
+			s.Logf(logLIVE, "Stopped.")
+			return nil
+		}
+	}
+}
+
+// reloadedTLS logs the outcome of reloading the TLS files, the previous ones being kept on error
+func (s *Server) reloadedTLS(err error) {
+	if err != nil {
+		s.Errorf("error reloading TLS files, keeping the previous ones: %s", err)
+		return
+	}
+	s.Logf(logLIVE, "Reloaded TLS files.")
+}
+
+// sizeLimits are the maximum request body sizes in bytes by route, where route "*" applies to all other routes
+type sizeLimits map[string]int64
+
+// of returns the maximum request body size of the route, or 0 when unlimited
+func (sl sizeLimits) of(route string) int64 {
+	if max, exist := sl[route]; exist {
+		return max
+	}
+	return sl["*"]
+}
+
+// sizeLimitsOf returns the request size limits of option 'max_request_size', given as a number of bytes,
+// a size such as "32MB", or a comma-separated list of route limits such as "/convert=256MB,*=32MB"
+func sizeLimitsOf(opt Options) (sizeLimits, error) {
+	limits := sizeLimits{}
+	val, exist := opt["max_request_size"]
+	if !exist || val == nil {
+		return limits, nil
+	}
+	switch v := val.(type) {
+	case int:
+		limits["*"] = int64(v)
+	case int64:
+		limits["*"] = v
+	case float64:
+		limits["*"] = int64(v)
+	case string:
+		for _, part := range strings.Split(v, ",") {
+			route, size := "*", strings.Trim(part, " ")
+			if i := strings.LastIndex(size, "="); i >= 0 {
+				route, size = strings.Trim(size[:i], " "), size[i+1:]
+			}
+			if len(size) == 0 {
+				continue
+			}
+			n, err := parseSize(size)
+			if err != nil {
+				return nil, fmt.Errorf("expected option 'max_request_size' of route '%s' to be a size, but got error: %s", route, err)
+			}
+			limits[route] = n
+		}
+	default:
+		return nil, fmt.Errorf("expected option 'max_request_size' to be a size, but got %T", val)
+	}
+	return limits, nil
+}
+
+// parseSize returns the number of bytes of a size such as "512", "64KB" or "32MiB", with binary (1024) multiples
+func parseSize(size string) (int64, error) {
+	size = strings.ToUpper(strings.Trim(size, " "))
+	multiple := int64(1)
+	for _, unit := range []struct {
+		suffix   string
+		multiple int64
+	}{{"GIB", 1 << 30}, {"MIB", 1 << 20}, {"KIB", 1 << 10}, {"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"G", 1 << 30}, {"M", 1 << 20}, {"K", 1 << 10}, {"B", 1}} {
+		if strings.HasSuffix(size, unit.suffix) {
+			size, multiple = strings.Trim(strings.TrimSuffix(size, unit.suffix), " "), unit.multiple
+			break
+		}
+	}
+	n, err := strconv.ParseInt(size, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size '%s'", size)
+	}
+	return n * multiple, nil
+}
+
+// limit wraps the handle of a route to refuse request bodies larger than the configured limit of the route,
+// with 413 Request Entity Too Large when known in advance, or otherwise failing when reading past the limit
+func (s *Server) limit(route string, handle httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		max := s.optionsOf(r).limits.of(route)
+		if max <= 0 {
+			handle(w, r, p)
+			return
+		}
+		if r.ContentLength > max {
+			s.fail(w, r, newProblem(http.StatusRequestEntityTooLarge, problemTooLarge, "request body of %d bytes exceeds the limit of %d bytes of route '%s'", r.ContentLength, max, route))
+			return
+		}
+		if r.Body != nil {
+			r.Body = http.MaxBytesReader(w, r.Body, max)
+		}
+		handle(w, r, p)
+	}
+}
@@ -0,0 +1,53 @@
+package main_test
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "sesam-cimrdf"
+)
+
+var _ = Describe("Microservice request size limits", func() {
+
+	var (
+		server   *Server
+		response *httptest.ResponseRecorder
+	)
+
+	BeforeEach(func() {
+		server = NewTestServer(Options{"log": ioutil.Discard, "seed": "ginkgo", "max_request_size": "/convert=16, 1KB"})
+		response = httptest.NewRecorder()
+	})
+
+	It("accepts requests within the limit", func() {
+		request, _ := http.NewRequest("POST", "/_id", strings.NewReader(`[{"_id": "a"}]`))
+		server.ServeHTTP(response, request)
+		Expect(response.Code).To(Equal(200))
+	})
+
+	It("refuses requests of known length over the route limit", func() {
+		request, _ := http.NewRequest("POST", "/convert", strings.NewReader(`[{"cim:Model.all": []}]`))
+		server.ServeHTTP(response, request)
+		Expect(response.Code).To(Equal(413))
+	})
+
+	It("refuses requests of known length over the default limit", func() {
+		request, _ := http.NewRequest("POST", "/_id", strings.NewReader(`[{"_id": "`+strings.Repeat("a", 1024)+`"}]`))
+		server.ServeHTTP(response, request)
+		Expect(response.Code).To(Equal(413))
+	})
+
+	It("stops reading chunked requests at the limit", func() {
+		body := io.MultiReader(strings.NewReader(`[{"_id": "`), strings.NewReader(strings.Repeat("a", 1024)+`"}]`))
+		request, _ := http.NewRequest("POST", "/_id", body)
+		request.ContentLength = -1
+		server.ServeHTTP(response, request)
+		Expect(response.Code).To(Equal(400))
+	})
+})
@@ -2,76 +2,156 @@ package main
 
 import (
 	"bufio"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"os"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/julienschmidt/httprouter"
 )
 
-func altmain() {
-	if err := run(); err != nil {
-		fmt.Fprintf(os.Stderr, "%s\n", err)
-		os.Exit(1)
-	}
+// defaultNamespaces are the namespaces of CIM models not given a map of namespaces
+var defaultNamespaces = map[string]string{
+	"_":      "https://foo.bar/",
+	"cim":    "http://iec.ch/TC57/2017/CIM-schema-cim100#",
+	"cim15":  "http://iec.ch/TC57/2010/CIM-schema-cim15#",
+	"cim16":  "http://iec.ch/TC57/2013/CIM-schema-cim16#",
+	"cim17":  "http://iec.ch/TC57/2016/CIM-schema-cim17#",
+	"dm":     "http://iec.ch/TC57/61970-552/DifferenceModel/1#",
+	"entsoe": "http://entsoe.eu/CIM/SchemaExtension/3/2#",
+	"iev":    "http://iec.ch/TC1/60050-6xx/Electropedia/1#",
+	"md":     "http://iec.ch/TC57/61970-552/ModelDescription/1#",
+	"nek":    "http://nek.no/NK57/CIM/CIM100-Extension/1/0#",
+	"rdf":    "http://www.w3.org/1999/02/22-rdf-syntax-ns#",
+	"rdfs":   "http://www.w3.org/2000/01/rdf-schema#",
+	"xsd":    "http://www.w3.org/2001/XMLSchema#",
 }
 
 func main() {
-	names := map[string]string{
+	if len(os.Args) > 1 && os.Args[1] == "serve" {
+		if err := serve(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(1)
+		}
+		return
+	}
 
-		"_":      "https://foo.bar/",
-		"cim":    "http://iec.ch/TC57/2017/CIM-schema-cim100#",
-		"cim15":  "http://iec.ch/TC57/2010/CIM-schema-cim15#",
-		"cim16":  "http://iec.ch/TC57/2013/CIM-schema-cim16#",
-		"cim17":  "http://iec.ch/TC57/2016/CIM-schema-cim17#",
-		"dm":     "http://iec.ch/TC57/61970-552/DifferenceModel/1#",
-		"entsoe": "http://entsoe.eu/CIM/SchemaExtension/3/2#",
-		"iev":    "http://iec.ch/TC1/60050-6xx/Electropedia/1#",
-		"md":     "http://iec.ch/TC57/61970-552/ModelDescription/1#",
-		"nek":    "http://nek.no/NK57/CIM/CIM100-Extension/1/0#",
-		"rdf":    "http://www.w3.org/1999/02/22-rdf-syntax-ns#",
-		"rdfs":   "http://www.w3.org/2000/01/rdf-schema#",
-		"xsd":    "http://www.w3.org/2001/XMLSchema#",
+	opt, err := LoadConfig(os.Args[1:])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
 	}
-	var defaults Options = Options{"json": "cim:Model.all", "ns": "names", "names": names}
+	var defaults Options = Options{"json": "cim:Model.all", "ns": "names"}
+	for k, v := range opt {
+		defaults[k] = v
+	}
+	defaults["names"] = namesOf(defaults)
 
 	r := bufio.NewReader(os.Stdin)
 	w := bufio.NewWriter(os.Stdout)
 	rw := bufio.NewReadWriter(r, w)
-	err := Convert(rw, &defaults, 3*1024*1024)
+	err = Convert(rw, &defaults, 3*1024*1024)
 	rw.Flush()
+	if p, ok := err.(*problem); ok {
+		data, _ := json.Marshal(p) // machine-readable, with the index and '_id' of the failing model
+		fmt.Fprintf(os.Stderr, "%s\n", data)
+		os.Exit(1)
+	}
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "%s\n", err)
 		os.Exit(1)
 	}
 }
 
-func run() error {
-	return http.ListenAndServe(":5000", NewServer(NewOptions(nil)))
-}
-
 // Server is a simple microservice
 type Server struct {
-	router  *httprouter.Router
-	options *serverOptions
+	router     *httprouter.Router
+	service    *httprouter.Router // fixed service routes, taking precedence over the wildcard routes of router
+	config     atomic.Value       // *serverOptions, swapped by Reload
+	reloading  sync.Mutex
+	lookup     *lookupStore // of minted UUIDs, if configured
+	journal    *journal     // of minted entities and converted models, if configured
+	throttle   *throttle    // of requests in flight, if limited
+	assembling sync.Mutex
+	assemblies map[string]*assembly // of models spread across the batches of runs by Sesam pipe, see assemble
+	ready      int32                // atomically set when the server is ready to serve requests
 }
 
-// NewServer sets up and returns microservice Server
-func NewServer(opt serverOptions) *Server {
-	s := &Server{router: httprouter.New(), options: &opt}
+// NewServer sets up and returns microservice Server of the options, or an error when they lack a UUID namespace
+// or the lookup store or the entity journal fails to open
+func NewServer(opt serverOptions) (*Server, error) {
+	if opt.seed == uuid.Nil || opt.logger == nil {
+		return nil, fmt.Errorf("missing UUID namespace of options for microservice")
+	}
+	s := &Server{router: httprouter.New(), service: httprouter.New(), assemblies: map[string]*assembly{}}
+	s.config.Store(&opt)
+	if opt.throttle.limited() {
+		s.throttle = newThrottle(opt.throttle)
+	}
+	if len(opt.lookup) != 0 {
+		var err error
+		if s.lookup, err = openLookup(opt.lookup, opt.retention, func(err error) { s.Errorf("%s", err) }); err != nil {
+			return nil, err
+		}
+	}
+	if len(opt.journal) != 0 {
+		var err error
+		if s.journal, err = openJournal(opt.journal, opt.journalRetention, func(err error) { s.Errorf("%s", err) }); err != nil {
+			if s.lookup != nil {
+				s.lookup.close()
+			}
+			return nil, err
+		}
+	}
 	s.Routes()
-	s.Logf(logLIVE, "Started RFC4122 urn:uuid-scheme UUID-v5 microservice with namespace:  %s  (\"%s\").\n", s.options.seed.String(), s.options.namespace)
+	s.Logf(logLIVE, "Started RFC4122 urn:uuid-scheme UUID-v5 microservice with namespace:  %s  (\"%s\").", opt.seed.String(), opt.namespace)
 	var period string
 	if time.Now().Year() > 2019 {
 		period = fmt.Sprintf("%d-%d", 2019, time.Now().Year())
 	} else {
 		period = "2019"
 	}
-	s.Logf(logLIVE, "Copyright Sesam.io %s. All rights reserved.\n", period)
-	return s
+	s.Logf(logLIVE, "Copyright Sesam.io %s. All rights reserved.", period)
+	s.SetReady(true)
+	return s, nil
+}
+
+// Close releases the resources of the server, after serving requests
+func (s *Server) Close() error {
+	var err error
+	if s.journal != nil {
+		err = s.journal.close()
+	}
+	if s.lookup != nil {
+		if e := s.lookup.close(); e != nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// Ready tells whether the server is ready to serve requests, as reported by GET /ready
+func (s *Server) Ready() bool {
+	return atomic.LoadInt32(&s.ready) == 1
+}
+
+// SetReady sets whether the server is ready to serve requests, e.g. cleared while shutting down
+func (s *Server) SetReady(ready bool) {
+	var v int32
+	if ready {
+		v = 1
+	}
+	atomic.StoreInt32(&s.ready, v)
 }
 
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if handle, p, _ := s.service.Lookup(r.Method, r.URL.Path); handle != nil {
+		handle(w, r, p)
+		return
+	}
 	s.router.ServeHTTP(w, r)
 }
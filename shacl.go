@@ -0,0 +1,455 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Shapes are SHACL shapes (https://www.w3.org/TR/shacl/) of a practical SHACL Core subset: node and property shapes
+// with class targets ('sh:targetClass') and the constraints 'sh:minCount', 'sh:maxCount', 'sh:datatype', 'sh:class',
+// 'sh:in' and 'sh:pattern' (with 'sh:flags'), on simple predicate paths
+type Shapes struct {
+	shapes   []*shape
+	subclass map[string][]string // 'rdfs:subClassOf' of the shapes graph, merged with those of the data graph
+}
+
+type shape struct {
+	id         term
+	targets    []string
+	path       string // property shapes only
+	severity   string
+	message    string
+	minCount   int // -1 when absent
+	maxCount   int // -1 when absent
+	datatype   string
+	classes    []string
+	in         []term
+	hasIn      bool
+	pattern    *regexp.Regexp
+	properties []*shape
+}
+
+// ValidationReport is a SHACL-like validation report, with components and severities in the 'sh:' compact form
+type ValidationReport struct {
+	Conforms bool               `json:"conforms"`
+	Results  []ValidationResult `json:"results"`
+}
+
+// ValidationResult is a single constraint violation of a focus node
+type ValidationResult struct {
+	FocusNode                 string `json:"focusNode"`
+	ResultPath                string `json:"resultPath,omitempty"`
+	Value                     string `json:"value,omitempty"`
+	SourceShape               string `json:"sourceShape"`
+	SourceConstraintComponent string `json:"sourceConstraintComponent"`
+	ResultSeverity            string `json:"resultSeverity"`
+	ResultMessage             string `json:"resultMessage,omitempty"`
+}
+
+// index of a graph by subject and predicate IRI
+type index map[term]map[string][]term
+
+func newIndex(triples []triple) index {
+	idx := make(index)
+	for _, t := range triples {
+		if idx[t.s] == nil {
+			idx[t.s] = make(map[string][]term)
+		}
+		idx[t.s][t.p.value] = append(idx[t.s][t.p.value], t.o)
+	}
+	return idx
+}
+
+// list returns the members of an RDF collection
+func (idx index) list(head term) []term {
+	items := []term{}
+	for seen := 0; head.value != nsRDF+"nil" && seen < 100000; seen++ {
+		first := idx[head][nsRDF+"first"]
+		rest := idx[head][nsRDF+"rest"]
+		if len(first) == 0 || len(rest) == 0 {
+			break
+		}
+		items = append(items, first[0])
+		head = rest[0]
+	}
+	return items
+}
+
+// maxNesting is how deep RDF documents nest elements, blank nodes or collections at most, CIM documents nesting only
+// a few levels, so that untrusted documents cannot exhaust the stack
+const maxNesting = 64
+
+// parseRDF returns the triples of an RDF document in the syntax given by its media type or file extension
+func parseRDF(data []byte, syntax string, base string) ([]triple, error) {
+	switch strings.ToLower(syntax) {
+	case "text/turtle", "application/n-triples", ".ttl", ".nt":
+		return parseTurtle(string(data), base)
+	default:
+		return parseRDFXML(bytes.NewReader(data), base)
+	}
+}
+
+// LoadShapes reads SHACL shapes from Turtle (.ttl), N-Triples (.nt) or RDF/XML (.rdf, .xml, .owl) files,
+// where a directory path includes all such files within it
+func LoadShapes(paths ...string) (*Shapes, error) {
+	var files []string
+	for _, path := range paths {
+		err := filepath.Walk(path, func(file string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			switch strings.ToLower(filepath.Ext(file)) {
+			case ".ttl", ".nt", ".rdf", ".xml", ".owl":
+				if !info.IsDir() {
+					files = append(files, file)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("unable to read shapes '%s': %s", path, err)
+		}
+	}
+	sort.Strings(files)
+	var triples []triple
+	for i, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read shapes file '%s': %s", file, err)
+		}
+		parsed, err := parseRDF(data, filepath.Ext(file), "file://"+filepath.ToSlash(file))
+		if err != nil {
+			return nil, fmt.Errorf("invalid shapes file '%s': %s", file, err)
+		}
+		for _, t := range parsed { // keeps blank nodes of different files apart
+			t.s = relabel(t.s, i)
+			t.o = relabel(t.o, i)
+			triples = append(triples, t)
+		}
+	}
+	return newShapes(triples)
+}
+
+func relabel(t term, file int) term {
+	if t.kind == termBlank {
+		t.value = fmt.Sprintf("f%d.%s", file, t.value)
+	}
+	return t
+}
+
+// newShapes returns the shapes of a shapes graph
+func newShapes(triples []triple) (*Shapes, error) {
+	idx := newIndex(triples)
+	s := &Shapes{subclass: make(map[string][]string)}
+	for _, t := range triples {
+		if t.p.value == nsRDFS+"subClassOf" && t.o.kind == termIRI {
+			s.subclass[t.o.value] = append(s.subclass[t.o.value], t.s.value)
+		}
+	}
+	subjects := make([]term, 0, len(idx))
+	for subject := range idx {
+		subjects = append(subjects, subject)
+	}
+	sort.Slice(subjects, func(i, j int) bool { return subjects[i].value < subjects[j].value })
+	for _, subject := range subjects {
+		props := idx[subject]
+		if len(props[nsSH+"targetClass"]) == 0 {
+			continue
+		}
+		sh, err := newShape(idx, subject, 0)
+		if err != nil {
+			return nil, err
+		}
+		if sh != nil {
+			s.shapes = append(s.shapes, sh)
+		}
+	}
+	return s, nil
+}
+
+// newShape returns the shape of the subject, or nil when deactivated
+func newShape(idx index, subject term, depth int) (*shape, error) {
+	if depth > 16 {
+		return nil, fmt.Errorf("shape %s nested too deep", display(subject))
+	}
+	props := idx[subject]
+	if deactivated := props[nsSH+"deactivated"]; len(deactivated) != 0 && deactivated[0].value == "true" {
+		return nil, nil
+	}
+	sh := &shape{id: subject, severity: "sh:Violation", minCount: -1, maxCount: -1}
+	for _, target := range props[nsSH+"targetClass"] {
+		sh.targets = append(sh.targets, target.value)
+	}
+	if path := props[nsSH+"path"]; len(path) != 0 {
+		if path[0].kind != termIRI {
+			return nil, nil // only predicate paths are supported, so other property shapes are skipped
+		}
+		sh.path = path[0].value
+	}
+	if severity := props[nsSH+"severity"]; len(severity) != 0 {
+		sh.severity = "sh:" + strings.TrimPrefix(severity[0].value, nsSH)
+	}
+	if message := props[nsSH+"message"]; len(message) != 0 {
+		sh.message = message[0].value
+	}
+	for _, count := range []struct {
+		name  string
+		value *int
+	}{{"minCount", &sh.minCount}, {"maxCount", &sh.maxCount}} {
+		if val := props[nsSH+count.name]; len(val) != 0 {
+			if _, err := fmt.Sscanf(val[0].value, "%d", count.value); err != nil {
+				return nil, fmt.Errorf("expected 'sh:%s' of shape %s to be an integer, but got '%s'", count.name, display(subject), val[0].value)
+			}
+		}
+	}
+	if datatype := props[nsSH+"datatype"]; len(datatype) != 0 {
+		sh.datatype = datatype[0].value
+	}
+	for _, class := range props[nsSH+"class"] {
+		sh.classes = append(sh.classes, class.value)
+	}
+	if in := props[nsSH+"in"]; len(in) != 0 {
+		sh.in = idx.list(in[0])
+		sh.hasIn = true
+	}
+	if pattern := props[nsSH+"pattern"]; len(pattern) != 0 {
+		expr := pattern[0].value
+		if flags := props[nsSH+"flags"]; len(flags) != 0 && strings.Contains(flags[0].value, "i") {
+			expr = "(?i)" + expr
+		}
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid 'sh:pattern' of shape %s: %s", display(subject), err)
+		}
+		sh.pattern = re
+	}
+	for _, property := range props[nsSH+"property"] {
+		ps, err := newShape(idx, property, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		if ps != nil && ps.path != "" {
+			sh.properties = append(sh.properties, ps)
+		}
+	}
+	return sh, nil
+}
+
+// display returns the term as shown in validation reports
+func display(t term) string {
+	if t.kind == termBlank {
+		return "_:" + t.value
+	}
+	return t.value
+}
+
+// validation holds the state of validating a data graph
+type validation struct {
+	shapes  *Shapes
+	data    index
+	types   map[term]map[string]bool
+	results []ValidationResult
+}
+
+// Validate returns the validation report of the data graph
+func (s *Shapes) Validate(triples []triple) ValidationReport {
+	v := &validation{shapes: s, data: newIndex(triples), types: make(map[term]map[string]bool)}
+	subclass := make(map[string][]string, len(s.subclass))
+	for class, subs := range s.subclass {
+		subclass[class] = append([]string{}, subs...)
+	}
+	for _, t := range triples {
+		if t.p.value == nsRDFS+"subClassOf" && t.o.kind == termIRI {
+			subclass[t.o.value] = append(subclass[t.o.value], t.s.value)
+		}
+	}
+	parents := make(map[string][]string)
+	for parent, children := range subclass {
+		for _, child := range children {
+			parents[child] = append(parents[child], parent)
+		}
+	}
+	for _, t := range triples {
+		if t.p.value == nsRDF+"type" {
+			if v.types[t.s] == nil {
+				v.types[t.s] = make(map[string]bool)
+			}
+			for _, class := range superclasses(t.o.value, parents) {
+				v.types[t.s][class] = true
+			}
+		}
+	}
+	for _, sh := range s.shapes {
+		for _, focus := range v.focusNodes(sh) {
+			v.validate(sh, focus)
+		}
+	}
+	if v.results == nil {
+		v.results = []ValidationResult{}
+	}
+	return ValidationReport{Conforms: len(v.results) == 0, Results: v.results}
+}
+
+// superclasses returns the class and all classes it is a subclass of
+func superclasses(class string, parents map[string][]string) []string {
+	seen := map[string]bool{class: true}
+	result := []string{class}
+	for i := 0; i < len(result); i++ {
+		for _, parent := range parents[result[i]] {
+			if !seen[parent] {
+				seen[parent] = true
+				result = append(result, parent)
+			}
+		}
+	}
+	return result
+}
+
+// focusNodes returns the nodes of the data graph targeted by the shape, in a stable order
+func (v *validation) focusNodes(sh *shape) []term {
+	var nodes []term
+	for node, classes := range v.types {
+		for _, target := range sh.targets {
+			if classes[target] {
+				nodes = append(nodes, node)
+				break
+			}
+		}
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].value < nodes[j].value })
+	return nodes
+}
+
+func (v *validation) report(sh *shape, focus term, value *term, component string) {
+	result := ValidationResult{
+		FocusNode:                 display(focus),
+		SourceShape:               display(sh.id),
+		SourceConstraintComponent: "sh:" + component + "ConstraintComponent",
+		ResultSeverity:            sh.severity,
+		ResultMessage:             sh.message,
+	}
+	if sh.path != "" {
+		result.ResultPath = sh.path
+	}
+	if value != nil {
+		result.Value = display(*value)
+	}
+	v.results = append(v.results, result)
+}
+
+// validate the focus node against the shape, where property shapes validate the values of their path
+func (v *validation) validate(sh *shape, focus term) {
+	values := []term{focus}
+	if sh.path != "" {
+		values = v.data[focus][sh.path]
+		if sh.minCount >= 0 && len(values) < sh.minCount {
+			v.report(sh, focus, nil, "MinCount")
+		}
+		if sh.maxCount >= 0 && len(values) > sh.maxCount {
+			v.report(sh, focus, nil, "MaxCount")
+		}
+	}
+	for i := range values {
+		value := values[i]
+		if sh.datatype != "" && !datatypeOK(value, sh.datatype) {
+			v.report(sh, focus, &value, "Datatype")
+		}
+		for _, class := range sh.classes {
+			if value.kind == termLiteral || !v.types[value][class] {
+				v.report(sh, focus, &value, "Class")
+			}
+		}
+		if sh.hasIn && !member(value, sh.in) {
+			v.report(sh, focus, &value, "In")
+		}
+		if sh.pattern != nil && (value.kind == termBlank || !sh.pattern.MatchString(value.value)) {
+			v.report(sh, focus, &value, "Pattern")
+		}
+	}
+	for _, ps := range sh.properties {
+		v.validate(ps, focus)
+	}
+}
+
+// member reports whether the term is in the list, comparing plain literals by their lexical form only
+func member(t term, list []term) bool {
+	for _, item := range list {
+		if item.kind == t.kind && item.value == t.value {
+			if t.kind != termLiteral || t.datatype == "" || item.datatype == "" || t.datatype == item.datatype {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+var lexical = map[string]*regexp.Regexp{
+	"boolean":            regexp.MustCompile(`^(true|false|1|0)$`),
+	"integer":            regexp.MustCompile(`^[+-]?[0-9]+$`),
+	"int":                regexp.MustCompile(`^[+-]?[0-9]+$`),
+	"long":               regexp.MustCompile(`^[+-]?[0-9]+$`),
+	"short":              regexp.MustCompile(`^[+-]?[0-9]+$`),
+	"nonNegativeInteger": regexp.MustCompile(`^\+?[0-9]+$`),
+	"positiveInteger":    regexp.MustCompile(`^\+?0*[1-9][0-9]*$`),
+	"decimal":            regexp.MustCompile(`^[+-]?([0-9]+(\.[0-9]*)?|\.[0-9]+)$`),
+	"float":              regexp.MustCompile(`^([+-]?([0-9]+(\.[0-9]*)?|\.[0-9]+)([eE][+-]?[0-9]+)?|[+-]?INF|NaN)$`),
+	"double":             regexp.MustCompile(`^([+-]?([0-9]+(\.[0-9]*)?|\.[0-9]+)([eE][+-]?[0-9]+)?|[+-]?INF|NaN)$`),
+	"dateTime":           regexp.MustCompile(`^-?[0-9]{4,}-[0-9]{2}-[0-9]{2}T[0-9]{2}:[0-9]{2}:[0-9]{2}(\.[0-9]+)?(Z|[+-][0-9]{2}:[0-9]{2})?$`),
+	"date":               regexp.MustCompile(`^-?[0-9]{4,}-[0-9]{2}-[0-9]{2}(Z|[+-][0-9]{2}:[0-9]{2})?$`),
+	"gMonthDay":          regexp.MustCompile(`^--[0-9]{2}-[0-9]{2}(Z|[+-][0-9]{2}:[0-9]{2})?$`),
+	"anyURI":             regexp.MustCompile(`^\S*$`),
+	"duration":           regexp.MustCompile(`^-?P([0-9]+Y)?([0-9]+M)?([0-9]+D)?(T([0-9]+H)?([0-9]+M)?([0-9]+(\.[0-9]+)?S)?)?$`),
+}
+
+// datatypeOK reports whether the term is a literal of the datatype, where plain literals (as produced for CIM RDF/XML)
+// conform when their lexical form is valid for the datatype
+func datatypeOK(t term, datatype string) bool {
+	switch {
+	case t.kind != termLiteral:
+		return false
+	case t.lang != "":
+		return datatype == nsRDF+"langString"
+	case t.datatype != "" && t.datatype != nsXSD+"string":
+		return t.datatype == datatype
+	case datatype == nsXSD+"string":
+		return true
+	case t.datatype != "":
+		return false // explicitly typed strings are not of other datatypes
+	}
+	if re, known := lexical[strings.TrimPrefix(datatype, nsXSD)]; known && strings.HasPrefix(datatype, nsXSD) {
+		return re.MatchString(t.value)
+	}
+	return false
+}
+
+// shapesOf returns the shapes from the configuration, loading them when given as a comma-separated string
+// or a list of paths
+func shapesOf(cfg Options) (*Shapes, error) {
+	val, exist := cfg["shapes"]
+	if !exist {
+		return nil, nil
+	}
+	switch s := val.(type) {
+	case nil:
+		return nil, nil
+	case *Shapes:
+		return s, nil
+	case string, []string, []interface{}:
+		paths, err := fieldList(cfg, "shapes")
+		if err != nil {
+			return nil, err
+		}
+		if len(paths) == 0 {
+			return nil, nil
+		}
+		return LoadShapes(paths...)
+	default:
+		return nil, fmt.Errorf("expected option 'shapes' to be shapes or a list of paths, but got %T", val)
+	}
+}
@@ -0,0 +1,227 @@
+package main_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "sesam-cimrdf"
+)
+
+const shapesTurtle string = `
+@prefix sh: <http://www.w3.org/ns/shacl#> .
+@prefix xsd: <http://www.w3.org/2001/XMLSchema#> .
+@prefix cim: <http://iec.ch/TC57/2017/CIM-schema-cim100#> .
+
+# every substation needs exactly one name of at most 8 upper case letters
+cim:SubstationShape a sh:NodeShape ;
+	sh:targetClass cim:Substation ;
+	sh:property [
+		sh:path cim:IdentifiedObject.name ;
+		sh:minCount 1 ;
+		sh:maxCount 1 ;
+		sh:pattern "^[A-Z]{1,8}$" ;
+		sh:message "Substation name"
+	] , [
+		sh:path cim:Substation.Region ;
+		sh:minCount 1 ;
+		sh:class cim:SubGeographicalRegion ;
+		sh:severity sh:Warning
+	] , [
+		sh:path cim:Substation.kind ;
+		sh:in ( "primary" "secondary" ) ;
+	] , [
+		sh:path cim:Substation.count ;
+		sh:datatype xsd:integer ;
+	] .
+`
+
+func NewReport(data []byte) ValidationReport {
+	var report ValidationReport
+	Expect(json.Unmarshal(data, &report)).To(Succeed())
+	return report
+}
+
+func Components(report ValidationReport) []string {
+	components := []string{}
+	for _, result := range report.Results {
+		components = append(components, result.SourceConstraintComponent)
+	}
+	return components
+}
+
+var _ = Describe("Microservice SHACL validation", func() {
+
+	var (
+		dir    string
+		shapes *Shapes
+		err    error
+	)
+
+	BeforeEach(func() {
+		dir, _ = ioutil.TempDir("", "shapes")
+		ioutil.WriteFile(filepath.Join(dir, "substation.ttl"), []byte(shapesTurtle), 0644)
+		shapes, err = LoadShapes(dir)
+		Expect(err).To(BeNil())
+	})
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	Describe("when converting", func() {
+
+		var buf bytes.Buffer
+
+		AfterEach(func() {
+			buf.Reset()
+		})
+
+		It("reports violations per model", func() {
+			input := `[{` + namespaces + `
+				,"json":[
+					{
+						"$ids": ["urn:uuid:00000000-0000-0000-0000-000000000001", "~:Substation:00000000-0000-0000-0000-000000000001"],
+						"_id": "urn:uuid:00000000-0000-0000-0000-000000000001",
+						"cim:IdentifiedObject.name": "lower",
+						"cim:Substation.kind": "tertiary",
+						"cim:Substation.count": 3,
+						"rdf:type": "~:cim:Substation"
+					}
+				]
+			}]`
+			rw := NewInputOutput(input, "", &buf)
+			Expect(Convert(rw, &Options{"json": "json", "shapes": shapes}, 0)).To(Succeed())
+			rw.Flush()
+			var models []map[string]json.RawMessage
+			Expect(json.Unmarshal(buf.Bytes(), &models)).To(Succeed())
+			report := NewReport(models[0]["report"])
+			Expect(report.Conforms).To(BeFalse())
+			Expect(Components(report)).To(ConsistOf(
+				"sh:PatternConstraintComponent",
+				"sh:MinCountConstraintComponent",
+				"sh:InConstraintComponent",
+			))
+			Expect(report.Results[0].FocusNode).To(Equal("urn:uuid:00000000-0000-0000-0000-000000000001"))
+		})
+
+		It("conforms for valid models", func() {
+			input := `[{` + namespaces + `
+				,"json":[
+					{
+						"$ids": ["urn:uuid:00000000-0000-0000-0000-000000000001", "~:Substation:00000000-0000-0000-0000-000000000001"],
+						"_id": "urn:uuid:00000000-0000-0000-0000-000000000001",
+						"cim:IdentifiedObject.name": "UPPER",
+						"cim:Substation.kind": "primary",
+						"cim:Substation.Region": "~:SubGeographicalRegion:00000000-0000-0000-0000-000000000002",
+						"rdf:type": "~:cim:Substation"
+					},
+					{
+						"$ids": ["urn:uuid:00000000-0000-0000-0000-000000000002", "~:SubGeographicalRegion:00000000-0000-0000-0000-000000000002"],
+						"_id": "urn:uuid:00000000-0000-0000-0000-000000000002",
+						"rdf:type": "~:cim:SubGeographicalRegion"
+					}
+				]
+			}]`
+			rw := NewInputOutput(input, "", &buf)
+			Expect(Convert(rw, &Options{"json": "json", "shapes": shapes}, 0)).To(Succeed())
+			rw.Flush()
+			var models []map[string]json.RawMessage
+			Expect(json.Unmarshal(buf.Bytes(), &models)).To(Succeed())
+			Expect(NewReport(models[0]["report"]).Conforms).To(BeTrue())
+		})
+	})
+
+	Describe("when POST to /validate", func() {
+
+		var (
+			server   *Server
+			response *httptest.ResponseRecorder
+		)
+
+		BeforeEach(func() {
+			server = NewTestServer(Options{"log": ioutil.Discard, "seed": "ginkgo", "shapes": shapes})
+			response = httptest.NewRecorder()
+		})
+
+		It("validates RDF/XML", func() {
+			input := `<?xml version="1.0" encoding="UTF-8"?>
+<rdf:RDF xmlns:cim="http://iec.ch/TC57/2017/CIM-schema-cim100#" xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+  <cim:Substation rdf:ID="_00000000-0000-0000-0000-000000000001">
+    <cim:IdentifiedObject.name>ONE</cim:IdentifiedObject.name>
+    <cim:IdentifiedObject.name>TWO</cim:IdentifiedObject.name>
+    <cim:Substation.Region rdf:resource="#_00000000-0000-0000-0000-000000000002"/>
+    <cim:Substation.count>many</cim:Substation.count>
+  </cim:Substation>
+  <cim:Substation rdf:about="_00000000-0000-0000-0000-000000000002">
+    <cim:IdentifiedObject.name>REGION</cim:IdentifiedObject.name>
+    <cim:Substation.Region rdf:resource="#_00000000-0000-0000-0000-000000000002"/>
+  </cim:Substation>
+</rdf:RDF>`
+			request, _ := http.NewRequest("POST", "/validate", strings.NewReader(input))
+			request.Header.Set("Content-Type", "application/rdf+xml")
+			server.ServeHTTP(response, request)
+			Expect(response.Code).To(Equal(200))
+			report := NewReport(response.Body.Bytes())
+			Expect(report.Conforms).To(BeFalse())
+			Expect(Components(report)).To(ConsistOf(
+				"sh:MaxCountConstraintComponent",
+				"sh:ClassConstraintComponent",
+				"sh:ClassConstraintComponent",
+				"sh:DatatypeConstraintComponent",
+			))
+		})
+
+		It("validates Turtle", func() {
+			input := `
+				@prefix cim: <http://iec.ch/TC57/2017/CIM-schema-cim100#> .
+				<urn:uuid:1> a cim:Substation ; cim:IdentifiedObject.name "ONE" ; cim:Substation.Region <urn:uuid:2> .
+				<urn:uuid:2> a cim:SubGeographicalRegion .
+			`
+			request, _ := http.NewRequest("POST", "/validate", strings.NewReader(input))
+			request.Header.Set("Content-Type", "text/turtle")
+			server.ServeHTTP(response, request)
+			Expect(response.Code).To(Equal(200))
+			Expect(NewReport(response.Body.Bytes()).Conforms).To(BeTrue())
+		})
+
+		It("rejects malformed RDF", func() {
+			request, _ := http.NewRequest("POST", "/validate", strings.NewReader(`<rdf:RDF`))
+			server.ServeHTTP(response, request)
+			Expect(response.Code).To(Equal(400))
+		})
+
+		It("rejects deeply nested RDF instead of exhausting the stack", func() {
+			for _, input := range []struct{ media, body string }{
+				{"application/rdf+xml", `<rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#" xmlns:cim="http://iec.ch/TC57/2017/CIM-schema-cim100#">` +
+					strings.Repeat(`<cim:Substation><cim:Substation.Region>`, 1000000)},
+				{"application/rdf+xml", `<rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#" xmlns:cim="http://iec.ch/TC57/2017/CIM-schema-cim100#"><cim:Substation>` +
+					strings.Repeat(`<cim:Substation.Region rdf:parseType="Resource">`, 1000000)},
+				{"text/turtle", `<urn:uuid:1> <urn:p> ` + strings.Repeat(`[ <urn:p> `, 1000000)},
+				{"text/turtle", `<urn:uuid:1> <urn:p> ` + strings.Repeat(`( `, 1000000)},
+			} {
+				response = httptest.NewRecorder()
+				request, _ := http.NewRequest("POST", "/validate", strings.NewReader(input.body))
+				request.Header.Set("Content-Type", input.media)
+				server.ServeHTTP(response, request)
+				Expect(response.Code).To(Equal(400))
+				Expect(response.Body.String()).To(ContainSubstring("nested deeper than 64"))
+			}
+		})
+
+		It("rejects unsupported media types", func() {
+			request, _ := http.NewRequest("POST", "/validate", strings.NewReader(`{}`))
+			request.Header.Set("Content-Type", "application/json")
+			server.ServeHTTP(response, request)
+			Expect(response.Code).To(Equal(415))
+		})
+	})
+
+})
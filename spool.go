@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// spoolMemory is how many bytes a spool holds in memory before moving them to a temporary file
+const spoolMemory = 3 * 1024 * 1024 // 3MB
+
+// spool holds written data until read back, in memory up to spoolMemory bytes and beyond that in a temporary file,
+// so that large batches need not fit in memory
+type spool struct {
+	buf  bytes.Buffer
+	file *os.File
+}
+
+func (sp *spool) Write(p []byte) (int, error) {
+	if sp.file == nil && sp.buf.Len()+len(p) > spoolMemory {
+		f, err := ioutil.TempFile("", "sesam-cimrdf-spool")
+		if err != nil {
+			return 0, err
+		}
+		if _, err = f.Write(sp.buf.Bytes()); err != nil {
+			f.Close()
+			os.Remove(f.Name())
+			return 0, err
+		}
+		sp.file = f
+		sp.buf = bytes.Buffer{}
+	}
+	if sp.file != nil {
+		return sp.file.Write(p)
+	}
+	return sp.buf.Write(p)
+}
+
+// reader returns a reader of the data written so far
+func (sp *spool) reader() (io.Reader, error) {
+	if sp.file == nil {
+		return bytes.NewReader(sp.buf.Bytes()), nil
+	}
+	if _, err := sp.file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return sp.file, nil
+}
+
+// close discards the data, removing the temporary file, if any
+func (sp *spool) close() error {
+	sp.buf = bytes.Buffer{}
+	if sp.file == nil {
+		return nil
+	}
+	err := sp.file.Close()
+	os.Remove(sp.file.Name())
+	sp.file = nil
+	return err
+}
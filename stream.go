@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+)
+
+// trailerError is the HTTP trailer reporting errors after the response of a stream has started
+const trailerError string = "X-Error"
+
+// stream writes a JSON array (or NDJSON lines) to the client element by element, flushing each element, so that
+// the response starts before the request body has been read completely
+type stream struct {
+	w        http.ResponseWriter
+	flusher  http.Flusher
+	started  bool
+	n        int
+	ndjson   bool
+	trailers []string // announced besides the error trailers
+}
+
+func newStream(w http.ResponseWriter) *stream {
+	// HTTP/1.1 servers otherwise discard the unread request body when the response starts
+	if duplex, ok := w.(interface{ EnableFullDuplex() error }); ok {
+		duplex.EnableFullDuplex()
+	}
+	flusher, _ := w.(http.Flusher)
+	return &stream{w: w, flusher: flusher}
+}
+
+// start commits the response with the HTTP status 200 OK and opens the JSON array
+func (st *stream) start() error {
+	if st.started {
+		return nil
+	}
+	st.started = true
+	if st.ndjson {
+		st.w.Header().Set("Content-Type", mediaNDJSON)
+	} else {
+		st.w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	}
+	st.w.Header().Add("Trailer", trailerError)
+	st.w.Header().Add("Trailer", trailerProblem)
+	for _, trailer := range st.trailers {
+		st.w.Header().Add("Trailer", trailer)
+	}
+	st.w.WriteHeader(http.StatusOK)
+	if st.ndjson {
+		return nil
+	}
+	_, err := st.w.Write([]byte{'['})
+	return err
+}
+
+// write adds an element to the JSON array, or a line
+func (st *stream) write(data []byte) error {
+	if err := st.start(); err != nil {
+		return err
+	}
+	if st.n != 0 && !st.ndjson {
+		if _, err := st.w.Write([]byte{','}); err != nil {
+			return err
+		}
+	}
+	st.n++
+	if _, err := st.w.Write(data); err != nil {
+		return err
+	}
+	if st.ndjson {
+		if _, err := st.w.Write([]byte{'\n'}); err != nil {
+			return err
+		}
+	}
+	if st.flusher != nil {
+		st.flusher.Flush()
+	}
+	return nil
+}
+
+// close ends the JSON array
+func (st *stream) close() error {
+	if err := st.start(); err != nil || st.ndjson {
+		return err
+	}
+	_, err := st.w.Write([]byte{']'})
+	return err
+}
+
+// fail responds with the problem when nothing has been written yet, or otherwise reports the problem in the trailers,
+// leaving the JSON array unterminated so that a truncated response does not parse as a complete one
+func (st *stream) fail(p *problem) {
+	if !st.started {
+		writeProblem(st.w, p)
+		return
+	}
+	p.RequestID = st.w.Header().Get(headerRequestID)
+	data, _ := json.Marshal(p)
+	st.w.Header().Set(trailerError, p.Detail)
+	st.w.Header().Set(trailerProblem, string(data))
+}
+
+// spooledResponse holds a response until sent, so that its headers can still be set after its body has been written;
+// the trailers announced meanwhile are sent as headers
+type spooledResponse struct {
+	http.ResponseWriter
+	status int
+	body   spool
+}
+
+func (sr *spooledResponse) WriteHeader(status int) {
+	if sr.status == 0 {
+		sr.status = status
+	}
+}
+
+func (sr *spooledResponse) Write(data []byte) (int, error) {
+	sr.WriteHeader(http.StatusOK)
+	return sr.body.Write(data)
+}
+
+// send writes the response held
+func (sr *spooledResponse) send() error {
+	defer sr.body.close()
+	sr.Header().Del("Trailer")
+	body, err := sr.body.reader()
+	if err != nil {
+		return err
+	}
+	sr.ResponseWriter.WriteHeader(sr.status)
+	_, err = io.Copy(sr.ResponseWriter, body)
+	return err
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// unknownSize is the size counted in flight of a request body of unknown length (chunked) when its route has no size
+// limit, so that such requests are not free of the limit of bytes in flight
+const unknownSize int64 = 32 << 20
+
+// throttleLimits are the limits of requests in flight: the number of concurrent requests by route (keys starting with
+// '/') or route group (where group "*" applies to each other group, and 0 is unlimited), the total bytes of their
+// bodies, and how many requests may wait for how long in the queue for their turn
+type throttleLimits struct {
+	concurrent map[string]int
+	bytes      int64
+	queue      int
+	timeout    time.Duration
+}
+
+// limited reports whether any request limit is configured
+func (tl throttleLimits) limited() bool {
+	return len(tl.concurrent) != 0 || tl.bytes > 0
+}
+
+// throttleLimitsOf returns the limits of options 'max_concurrent' (a number, or a comma-separated list of route and
+// route group limits such as "/uuid=4,convert=2,*=8"), 'max_inflight_bytes' (a size such as "1GB"), 'max_queue' (a number, default
+// 100) and 'queue_timeout' (a duration, default 30s)
+func throttleLimitsOf(opt Options) (throttleLimits, error) {
+	tl := throttleLimits{concurrent: map[string]int{}, queue: 100, timeout: 30 * time.Second}
+	switch v := opt["max_concurrent"].(type) {
+	case nil:
+	case int:
+		tl.concurrent["*"] = v
+	case float64:
+		tl.concurrent["*"] = int(v)
+	case string:
+		for _, part := range strings.Split(v, ",") {
+			group, count := "*", strings.Trim(part, " ")
+			if i := strings.LastIndex(count, "="); i >= 0 {
+				group, count = strings.Trim(count[:i], " "), strings.Trim(count[i+1:], " ")
+			}
+			if len(count) == 0 {
+				continue
+			}
+			known := group == "*" || strings.HasPrefix(group, "/")
+			for _, g := range routeGroups {
+				known = known || g == group
+			}
+			if !known {
+				return tl, fmt.Errorf("expected option 'max_concurrent' to limit routes such as '/convert', route groups %s or '*', but got '%s'", strings.Join(routeGroups, ", "), group)
+			}
+			n, err := strconv.Atoi(count)
+			if err != nil || n < 0 {
+				return tl, fmt.Errorf("expected option 'max_concurrent' of %s to be a count, but got '%s'", limitName(group), count)
+			}
+			tl.concurrent[group] = n
+		}
+	default:
+		return tl, fmt.Errorf("expected option 'max_concurrent' to be a count, but got %T", v)
+	}
+	switch v := opt["max_inflight_bytes"].(type) {
+	case nil:
+	case int:
+		tl.bytes = int64(v)
+	case int64:
+		tl.bytes = v
+	case float64:
+		tl.bytes = int64(v)
+	case string:
+		if len(strings.Trim(v, " ")) != 0 {
+			var err error
+			if tl.bytes, err = parseSize(v); err != nil {
+				return tl, fmt.Errorf("expected option 'max_inflight_bytes' to be a size, but got error: %s", err)
+			}
+		}
+	default:
+		return tl, fmt.Errorf("expected option 'max_inflight_bytes' to be a size, but got %T", v)
+	}
+	switch v := opt["max_queue"].(type) {
+	case nil:
+	case int:
+		tl.queue = v
+	case float64:
+		tl.queue = int(v)
+	case string:
+		n, err := strconv.Atoi(strings.Trim(v, " "))
+		if err != nil || n < 0 {
+			return tl, fmt.Errorf("expected option 'max_queue' to be a count, but got '%s'", v)
+		}
+		tl.queue = n
+	default:
+		return tl, fmt.Errorf("expected option 'max_queue' to be a count, but got %T", v)
+	}
+	for group, n := range tl.concurrent {
+		if n < 0 {
+			return tl, fmt.Errorf("expected option 'max_concurrent' of %s to be a count, but got %d", limitName(group), n)
+		}
+	}
+	if tl.bytes < 0 {
+		return tl, fmt.Errorf("expected option 'max_inflight_bytes' to be a size, but got %d", tl.bytes)
+	}
+	if tl.queue < 0 {
+		return tl, fmt.Errorf("expected option 'max_queue' to be a count, but got %d", tl.queue)
+	}
+	if val, exist := opt["queue_timeout"]; exist && val != nil {
+		var err error
+		if tl.timeout, err = durationOf(val); err != nil {
+			return tl, fmt.Errorf("option 'queue_timeout' %s", err)
+		}
+	}
+	return tl, nil
+}
+
+// limitOf returns the key of the limit of concurrent requests of the route of the group: the route when it has a
+// limit of its own, which takes precedence, or else the group
+func (tl throttleLimits) limitOf(group string, route string) string {
+	if _, exist := tl.concurrent[route]; exist {
+		return route
+	}
+	return group
+}
+
+// limitName names the route or route group of the key of a limit of concurrent requests
+func limitName(key string) string {
+	if strings.HasPrefix(key, "/") {
+		return fmt.Sprintf("route '%s'", key)
+	}
+	return fmt.Sprintf("route group '%s'", key)
+}
+
+// throttle admits requests within the limits, queueing the others until their turn or timeout
+type throttle struct {
+	mu       sync.Mutex
+	limits   throttleLimits
+	counts   map[string]int // requests in flight by the key of their limit, a route or route group
+	bytes    int64          // of the bodies of the requests in flight
+	inflight int
+	queued   int
+	released chan struct{} // closed and replaced when a request leaves
+}
+
+func newThrottle(limits throttleLimits) *throttle {
+	return &throttle{limits: limits, counts: map[string]int{}, released: make(chan struct{})}
+}
+
+// admissible reports whether a request of the key of its limit (a route or route group) and size is within the
+// limits; a request larger than the limit of bytes in flight is admitted only on its own
+func (t *throttle) admissible(limit string, size int64) bool {
+	max, exist := t.limits.concurrent[limit]
+	if !exist {
+		max = t.limits.concurrent["*"]
+	}
+	if max > 0 && t.counts[limit] >= max {
+		return false
+	}
+	return t.limits.bytes <= 0 || t.bytes+size <= t.limits.bytes || t.inflight == 0
+}
+
+// acquire waits until the request is admitted, returning a problem when the queue is full or the wait times out
+// or is cancelled
+func (t *throttle) acquire(r *http.Request, limit string, size int64) *problem {
+	var deadline <-chan time.Time
+	t.mu.Lock()
+	for !t.admissible(limit, size) {
+		if deadline == nil {
+			if t.queued >= t.limits.queue {
+				t.mu.Unlock()
+				return newProblem(http.StatusTooManyRequests, problemQueueFull, "too many requests waiting for %s", limitName(limit))
+			}
+			timer := time.NewTimer(t.limits.timeout)
+			defer timer.Stop()
+			deadline = timer.C
+			t.queued++
+			defer func() {
+				t.mu.Lock()
+				t.queued--
+				t.mu.Unlock()
+			}()
+		}
+		released := t.released
+		t.mu.Unlock()
+		select {
+		case <-released:
+		case <-deadline:
+			return newProblem(http.StatusServiceUnavailable, problemQueueTimeout, "request waited longer than %s for %s", t.limits.timeout, limitName(limit))
+		case <-r.Context().Done():
+			return newProblem(http.StatusServiceUnavailable, problemQueueTimeout, "request cancelled while waiting for %s", limitName(limit))
+		}
+		t.mu.Lock()
+	}
+	t.counts[limit]++
+	t.bytes += size
+	t.inflight++
+	t.mu.Unlock()
+	return nil
+}
+
+// release lets the request leave, waking the queued requests
+func (t *throttle) release(limit string, size int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.counts[limit]--
+	t.bytes -= size
+	t.inflight--
+	close(t.released)
+	t.released = make(chan struct{})
+}
+
+// admit wraps the handle of a route of the group to serve requests within the limits of concurrent requests of the
+// route, or else of the group, and bytes in flight, queueing the others, and responding with 429 Too Many Requests
+// when the queue is full or 503 Service Unavailable when the wait times out, both with Retry-After. Bodies of unknown
+// length count as the size limit of the route, or unknownSize without one.
+func (s *Server) admit(group string, route string, handle httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		if s.throttle == nil {
+			handle(w, r, p)
+			return
+		}
+		size := r.ContentLength
+		if size < 0 {
+			if size = s.optionsOf(r).limits.of(route); size <= 0 {
+				size = unknownSize
+			}
+		}
+		limit := s.throttle.limits.limitOf(group, route)
+		if failure := s.throttle.acquire(r, limit, size); failure != nil {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(math.Max(s.throttle.limits.timeout.Seconds(), 1)))))
+			s.fail(w, r, failure)
+			return
+		}
+		defer s.throttle.release(limit, size)
+		handle(w, r, p)
+	}
+}
@@ -0,0 +1,131 @@
+package main_test
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "sesam-cimrdf"
+)
+
+var _ = Describe("Microservice request throttling", func() {
+
+	var (
+		server *Server
+		pw     *io.PipeWriter
+		done   chan *httptest.ResponseRecorder
+	)
+
+	// Block starts a request of the body length which is in flight until Unblock
+	Block := func(length int64) {
+		var pr *io.PipeReader
+		pr, pw = io.Pipe()
+		request, _ := http.NewRequest("POST", "/_id", pr)
+		request.ContentLength = length
+		done = make(chan *httptest.ResponseRecorder, 1)
+		go func() {
+			response := httptest.NewRecorder()
+			server.ServeHTTP(response, request)
+			done <- response
+		}()
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	Unblock := func() {
+		pw.Write([]byte(`[{"_id": "a"}]`))
+		pw.Close()
+		Expect((<-done).Code).To(Equal(200))
+	}
+
+	Post := func(url string, body string) *httptest.ResponseRecorder {
+		response := httptest.NewRecorder()
+		request, _ := http.NewRequest("POST", url, strings.NewReader(body))
+		server.ServeHTTP(response, request)
+		return response
+	}
+
+	It("times out requests waiting for their turn of the route group", func() {
+		server = NewTestServer(Options{"log": ioutil.Discard, "seed": "ginkgo", "max_concurrent": "mint=1", "queue_timeout": "50ms"})
+		Block(14)
+		response := Post("/_id", `[{"_id": "b"}]`)
+		Expect(response.Code).To(Equal(503))
+		Expect(response.Header().Get("Retry-After")).To(Equal("1"))
+		Expect(response.Body.String()).To(ContainSubstring("queue_timeout"))
+		Expect(Post("/_id/cim:Substation", `[{"_id": "b"}]`).Code).To(Equal(503))
+		Expect(Post("/convert", `[]`).Code).To(Equal(200))
+		Unblock()
+		Expect(Post("/_id", `[{"_id": "b"}]`).Code).To(Equal(200))
+	})
+
+	It("limits routes on their own before their route group", func() {
+		server = NewTestServer(Options{"log": ioutil.Discard, "seed": "ginkgo", "max_concurrent": "/:field=1,mint=1", "queue_timeout": "50ms"})
+		Block(14)
+		response := Post("/_id", `[{"_id": "b"}]`)
+		Expect(response.Code).To(Equal(503))
+		Expect(response.Body.String()).To(ContainSubstring("route '/:field'"))
+		Expect(Post("/_id/cim:Substation", `[{"_id": "b"}]`).Code).To(Equal(200))
+		Unblock()
+		Expect(Post("/_id", `[{"_id": "b"}]`).Code).To(Equal(200))
+	})
+
+	It("serves queued requests in turn", func() {
+		server = NewTestServer(Options{"log": ioutil.Discard, "seed": "ginkgo", "max_concurrent": 1, "queue_timeout": "5s"})
+		Block(14)
+		queued := make(chan *httptest.ResponseRecorder, 1)
+		go func() { queued <- Post("/_id", `[{"_id": "b"}]`) }()
+		time.Sleep(20 * time.Millisecond)
+		Unblock()
+		Expect((<-queued).Code).To(Equal(200))
+	})
+
+	It("refuses requests beyond the queue", func() {
+		server = NewTestServer(Options{"log": ioutil.Discard, "seed": "ginkgo", "max_concurrent": 1, "max_queue": 0})
+		Block(14)
+		response := Post("/_id", `[{"_id": "b"}]`)
+		Expect(response.Code).To(Equal(429))
+		Expect(response.Header().Get("Retry-After")).To(Equal("30"))
+		Expect(response.Body.String()).To(ContainSubstring("queue_full"))
+		Unblock()
+	})
+
+	It("limits the bytes in flight", func() {
+		server = NewTestServer(Options{"log": ioutil.Discard, "seed": "ginkgo", "max_inflight_bytes": "20B", "queue_timeout": "50ms"})
+		Block(14)
+		Expect(Post("/_id", `[{"_id": "b"}]`).Code).To(Equal(503))
+		Expect(Post("/_id", `[]`).Code).To(Equal(200))
+		Unblock()
+		Expect(Post("/_id", `[{"_id": "b", "name": "a long body"}]`).Code).To(Equal(200))
+	})
+
+	It("counts chunked bodies in flight", func() {
+		server = NewTestServer(Options{"log": ioutil.Discard, "seed": "ginkgo", "max_inflight_bytes": "1MB", "queue_timeout": "50ms"})
+		Block(-1)
+		Expect(Post("/_id", `[{"_id": "b"}]`).Code).To(Equal(503))
+		Unblock()
+
+		server = NewTestServer(Options{"log": ioutil.Discard, "seed": "ginkgo", "max_inflight_bytes": "1MB", "max_request_size": "512KB", "queue_timeout": "50ms"})
+		Block(-1)
+		Expect(Post("/_id", `[{"_id": "b"}]`).Code).To(Equal(200))
+		Unblock()
+	})
+
+	It("refuses invalid limits", func() {
+		for _, o := range []Options{
+			{"seed": "ginkgo", "max_concurrent": "convert=many"},
+			{"seed": "ginkgo", "max_concurrent": "/convert=two"},
+			{"seed": "ginkgo", "max_concurrent": "uuid=2"},
+			{"seed": "ginkgo", "max_inflight_bytes": "lots"},
+			{"seed": "ginkgo", "max_queue": -1.5},
+			{"seed": "ginkgo", "queue_timeout": "soon"},
+		} {
+			_, err := NewOptions(&o)
+			Expect(err).NotTo(BeNil(), "%v", o)
+		}
+	})
+})
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+// TLSFiles holds the server certificate and, for mutual TLS, the CA bundle of client certificates, as loaded
+// from PEM files, and serves them to new TLS connections so that they can be reloaded without a restart
+type TLSFiles struct {
+	certFile string
+	keyFile  string
+	caFile   string
+
+	mu       sync.RWMutex
+	cert     *tls.Certificate
+	clientCA *x509.CertPool
+	modified time.Time // latest modification time of the files when loaded
+}
+
+// LoadTLSFiles loads the certificate and private key of the server, and the CA bundle verifying client
+// certificates unless caFile is empty
+func LoadTLSFiles(certFile string, keyFile string, caFile string) (*TLSFiles, error) {
+	tf := &TLSFiles{certFile: certFile, keyFile: keyFile, caFile: caFile}
+	if err := tf.Reload(); err != nil {
+		return nil, err
+	}
+	return tf, nil
+}
+
+// Reload loads the files again, keeping the previous certificates when any of them fails
+func (tf *TLSFiles) Reload() error {
+	modified, err := tf.lastModified()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(tf.certFile, tf.keyFile)
+	if err != nil {
+		return fmt.Errorf("error loading TLS certificate '%s' and key '%s': %s", tf.certFile, tf.keyFile, err)
+	}
+	var pool *x509.CertPool
+	if len(tf.caFile) != 0 {
+		data, err := ioutil.ReadFile(tf.caFile)
+		if err != nil {
+			return fmt.Errorf("error loading TLS client CA bundle: %s", err)
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return fmt.Errorf("expected PEM certificates in TLS client CA bundle '%s'", tf.caFile)
+		}
+	}
+	tf.mu.Lock()
+	defer tf.mu.Unlock()
+	tf.cert, tf.clientCA, tf.modified = &cert, pool, modified
+	return nil
+}
+
+func (tf *TLSFiles) lastModified() (time.Time, error) {
+	var latest time.Time
+	for _, path := range []string{tf.certFile, tf.keyFile, tf.caFile} {
+		if len(path) == 0 {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return latest, fmt.Errorf("error loading TLS file: %s", err)
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// Changed tells whether any of the files has been modified since loaded
+func (tf *TLSFiles) Changed() bool {
+	modified, err := tf.lastModified()
+	if err != nil {
+		return false // e.g. while being replaced, so trying again later
+	}
+	tf.mu.RLock()
+	defer tf.mu.RUnlock()
+	return modified.After(tf.modified)
+}
+
+// Watch reloads the files when modified, checking every interval until stop is closed;
+// the outcome of each reload is reported to the callback (nil when successful), which may be nil
+func (tf *TLSFiles) Watch(interval time.Duration, stop <-chan struct{}, report func(error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if !tf.Changed() {
+				continue
+			}
+			err := tf.Reload()
+			if report != nil {
+				report(err)
+			}
+		}
+	}
+}
+
+// Config returns the TLS configuration of a server, requiring and verifying client certificates
+// when a client CA bundle is given
+func (tf *TLSFiles) Config() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			tf.mu.RLock()
+			defer tf.mu.RUnlock()
+			return tf.cert, nil
+		},
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			tf.mu.RLock()
+			defer tf.mu.RUnlock()
+			cfg := &tls.Config{MinVersion: tls.VersionTLS12, Certificates: []tls.Certificate{*tf.cert}}
+			if tf.clientCA != nil {
+				cfg.ClientCAs = tf.clientCA
+				cfg.ClientAuth = tls.RequireAndVerifyClientCert
+			}
+			return cfg, nil
+		},
+	}
+}
@@ -0,0 +1,126 @@
+package main_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "sesam-cimrdf"
+)
+
+// NewCertificate returns a certificate and its key signed by the parent, or self-signed without parent
+func NewCertificate(name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey, []byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).To(BeNil())
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	if parent == nil {
+		template.IsCA, template.BasicConstraintsValid = true, true
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	Expect(err).To(BeNil())
+	cert, _ := x509.ParseCertificate(der)
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+	return cert, key, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+var _ = Describe("Microservice TLS", func() {
+
+	var (
+		dir       string
+		ca        *x509.Certificate
+		caKey     *ecdsa.PrivateKey
+		caPEM     []byte
+		files     *TLSFiles
+		ts        *httptest.Server
+		certFile  string
+		keyFile   string
+		clientCrt tls.Certificate
+	)
+
+	BeforeEach(func() {
+		dir, _ = ioutil.TempDir("", "tls")
+		ca, caKey, caPEM, _ = NewCertificate("ca", nil, nil)
+		_, _, serverPEM, serverKey := NewCertificate("server", ca, caKey)
+		_, _, clientPEM, clientKey := NewCertificate("client", ca, caKey)
+		certFile, keyFile = filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key")
+		ioutil.WriteFile(certFile, serverPEM, 0600)
+		ioutil.WriteFile(keyFile, serverKey, 0600)
+		ioutil.WriteFile(filepath.Join(dir, "ca.crt"), caPEM, 0600)
+		clientCrt, _ = tls.X509KeyPair(clientPEM, clientKey)
+
+		var err error
+		files, err = LoadTLSFiles(certFile, keyFile, filepath.Join(dir, "ca.crt"))
+		Expect(err).To(BeNil())
+		ts = httptest.NewUnstartedServer(NewTestServer(Options{"log": ioutil.Discard, "seed": "ginkgo"}))
+		ts.TLS = files.Config()
+		ts.StartTLS()
+	})
+	AfterEach(func() {
+		ts.Close()
+		os.RemoveAll(dir)
+	})
+
+	Client := func(certificates ...tls.Certificate) *http.Client {
+		pool := x509.NewCertPool()
+		pool.AddCert(ca)
+		return &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool, Certificates: certificates}}}
+	}
+
+	It("serves clients with a certificate of the CA", func() {
+		resp, err := Client(clientCrt).Get(ts.URL + "/health")
+		Expect(err).To(BeNil())
+		Expect(resp.StatusCode).To(Equal(200))
+	})
+
+	It("refuses clients without a certificate", func() {
+		_, err := Client().Get(ts.URL + "/health")
+		Expect(err).NotTo(BeNil())
+	})
+
+	It("reloads modified certificates", func() {
+		Expect(files.Changed()).To(BeFalse())
+		renewed, _, renewedPEM, renewedKey := NewCertificate("renewed", ca, caKey)
+		ioutil.WriteFile(certFile, renewedPEM, 0600)
+		ioutil.WriteFile(keyFile, renewedKey, 0600)
+		later := time.Now().Add(time.Second)
+		os.Chtimes(certFile, later, later)
+		Expect(files.Changed()).To(BeTrue())
+		Expect(files.Reload()).To(Succeed())
+
+		resp, err := Client(clientCrt).Get(ts.URL + "/health")
+		Expect(err).To(BeNil())
+		Expect(resp.TLS.PeerCertificates[0].Subject.CommonName).To(Equal(renewed.Subject.CommonName))
+	})
+
+	It("keeps the certificates when reloading fails", func() {
+		ioutil.WriteFile(keyFile, []byte("garbage"), 0600)
+		Expect(files.Reload()).NotTo(Succeed())
+		_, err := Client(clientCrt).Get(ts.URL + "/health")
+		Expect(err).To(BeNil())
+	})
+})
@@ -0,0 +1,544 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	termIRI = iota
+	termBlank
+	termLiteral
+)
+
+const (
+	nsRDF  string = "http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+	nsRDFS string = "http://www.w3.org/2000/01/rdf-schema#"
+	nsXSD  string = "http://www.w3.org/2001/XMLSchema#"
+	nsSH   string = "http://www.w3.org/ns/shacl#"
+)
+
+// term is an RDF term: an IRI, a blank node label or a literal
+type term struct {
+	kind     int
+	value    string
+	datatype string // literals only; empty for plain literals
+	lang     string // literals only
+}
+
+// triple is an RDF statement of terms, as parsed from Turtle or RDF/XML
+type triple struct {
+	s, p, o term
+}
+
+func iri(value string) term {
+	return term{kind: termIRI, value: value}
+}
+
+func literal(value string, datatype string) term {
+	return term{kind: termLiteral, value: value, datatype: datatype}
+}
+
+// String returns the term in Turtle/N-Triples syntax
+func (t term) String() string {
+	switch t.kind {
+	case termIRI:
+		return "<" + t.value + ">"
+	case termBlank:
+		return "_:" + t.value
+	}
+	s := strconv.Quote(t.value)
+	if t.lang != "" {
+		return s + "@" + t.lang
+	}
+	if t.datatype != "" {
+		return s + "^^<" + t.datatype + ">"
+	}
+	return s
+}
+
+// turtle is a parser of the Turtle RDF syntax (https://www.w3.org/TR/turtle/), which includes N-Triples
+type turtle struct {
+	src      string
+	pos      int
+	line     int
+	base     string
+	prefixes map[string]string
+	blanks   int
+	depth    int // of the blank node property lists and collections being parsed
+	triples  []triple
+}
+
+// parseTurtle returns the triples of a Turtle document, with relative IRIs resolved against base
+func parseTurtle(src string, base string) (triples []triple, err error) {
+	p := &turtle{src: src, line: 1, base: base, prefixes: make(map[string]string)}
+	defer func() {
+		if r := recover(); r != nil {
+			if perr, ok := r.(turtleError); ok {
+				err = perr
+				return
+			}
+			panic(r)
+		}
+	}()
+	for p.skip(); p.pos < len(p.src); p.skip() {
+		p.statement()
+	}
+	return p.triples, nil
+}
+
+type turtleError struct {
+	line int
+	msg  string
+}
+
+func (e turtleError) Error() string {
+	return fmt.Sprintf("turtle syntax error on line %d: %s", e.line, e.msg)
+}
+
+func (p *turtle) fail(format string, args ...interface{}) {
+	panic(turtleError{line: p.line, msg: fmt.Sprintf(format, args...)})
+}
+
+// skip advances past whitespace and comments
+func (p *turtle) skip() {
+	for p.pos < len(p.src) {
+		switch c := p.src[p.pos]; {
+		case c == '\n':
+			p.line++
+			p.pos++
+		case c == ' ' || c == '\t' || c == '\r':
+			p.pos++
+		case c == '#':
+			for p.pos < len(p.src) && p.src[p.pos] != '\n' {
+				p.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (p *turtle) peek() byte {
+	if p.pos < len(p.src) {
+		return p.src[p.pos]
+	}
+	return 0
+}
+
+func (p *turtle) expect(c byte) {
+	p.skip()
+	if p.peek() != c {
+		p.fail("expected '%c'", c)
+	}
+	p.pos++
+}
+
+// keyword reports and consumes a case-insensitive keyword followed by whitespace
+func (p *turtle) keyword(word string) bool {
+	end := p.pos + len(word)
+	if end < len(p.src) && strings.EqualFold(p.src[p.pos:end], word) && unicode.IsSpace(rune(p.src[end])) {
+		p.pos = end
+		return true
+	}
+	return false
+}
+
+func (p *turtle) statement() {
+	switch {
+	case p.keyword("@prefix"):
+		p.prefix()
+		p.expect('.')
+	case p.keyword("@base"):
+		p.skip()
+		p.base = p.iriref()
+		p.expect('.')
+	case p.keyword("PREFIX"):
+		p.prefix()
+	case p.keyword("BASE"):
+		p.skip()
+		p.base = p.iriref()
+	default:
+		p.triplesBlock()
+		p.expect('.')
+	}
+}
+
+func (p *turtle) prefix() {
+	p.skip()
+	start := p.pos
+	for p.pos < len(p.src) && p.src[p.pos] != ':' {
+		p.pos++
+	}
+	name := strings.TrimSpace(p.src[start:p.pos])
+	p.expect(':')
+	p.skip()
+	p.prefixes[name] = p.iriref()
+}
+
+func (p *turtle) triplesBlock() {
+	p.skip()
+	var subject term
+	if p.peek() == '[' {
+		subject = p.blankNodePropertyList()
+		p.skip()
+		if p.peek() == '.' {
+			return
+		}
+	} else {
+		subject = p.subject()
+	}
+	p.predicateObjectList(subject)
+}
+
+func (p *turtle) subject() term {
+	switch p.peek() {
+	case '(':
+		return p.collection()
+	case '_':
+		return p.blankNode()
+	}
+	return iri(p.iri())
+}
+
+func (p *turtle) predicateObjectList(subject term) {
+	for {
+		p.skip()
+		var predicate term
+		if p.peek() == 'a' && p.pos+1 < len(p.src) && (unicode.IsSpace(rune(p.src[p.pos+1])) || p.src[p.pos+1] == '<' || p.src[p.pos+1] == '"') {
+			p.pos++
+			predicate = iri(nsRDF + "type")
+		} else {
+			predicate = iri(p.iri())
+		}
+		for {
+			p.skip()
+			object := p.object()
+			p.triples = append(p.triples, triple{subject, predicate, object})
+			p.skip()
+			if p.peek() != ',' {
+				break
+			}
+			p.pos++
+		}
+		if p.peek() != ';' {
+			return
+		}
+		for p.peek() == ';' { // repeated semicolons are allowed
+			p.pos++
+			p.skip()
+		}
+		if c := p.peek(); c == '.' || c == ']' || c == 0 {
+			return
+		}
+	}
+}
+
+func (p *turtle) object() term {
+	switch c := p.peek(); {
+	case c == '<':
+		return iri(p.iriref())
+	case c == '_':
+		return p.blankNode()
+	case c == '[':
+		return p.blankNodePropertyList()
+	case c == '(':
+		return p.collection()
+	case c == '"' || c == '\'':
+		return p.literal()
+	case c == '+' || c == '-' || c == '.' || (c >= '0' && c <= '9'):
+		return p.numeric()
+	case strings.HasPrefix(p.src[p.pos:], "true") && p.boolean("true"):
+		return literal("true", nsXSD+"boolean")
+	case strings.HasPrefix(p.src[p.pos:], "false") && p.boolean("false"):
+		return literal("false", nsXSD+"boolean")
+	}
+	return iri(p.iri())
+}
+
+// boolean consumes a boolean keyword directly followed by a delimiter
+func (p *turtle) boolean(word string) bool {
+	end := p.pos + len(word)
+	if end == len(p.src) || strings.IndexByte(" \t\r\n,;.])#", p.src[end]) >= 0 {
+		p.pos = end
+		return true
+	}
+	return false
+}
+
+// enter counts a blank node property list or collection nested in those being parsed, failing beyond maxNesting,
+// to be undone by leave
+func (p *turtle) enter() {
+	p.depth++
+	if p.depth > maxNesting {
+		p.fail("blank nodes and collections nested deeper than %d", maxNesting)
+	}
+}
+
+func (p *turtle) leave() {
+	p.depth--
+}
+
+func (p *turtle) newBlank() term {
+	p.blanks++
+	return term{kind: termBlank, value: fmt.Sprintf("b%d", p.blanks)}
+}
+
+func (p *turtle) blankNode() term {
+	if !strings.HasPrefix(p.src[p.pos:], "_:") {
+		p.fail("expected blank node label")
+	}
+	p.pos += 2
+	start := p.pos
+	p.name()
+	return term{kind: termBlank, value: "x" + p.src[start:p.pos]} // distinct from generated labels
+}
+
+func (p *turtle) blankNodePropertyList() term {
+	p.expect('[')
+	p.enter()
+	defer p.leave()
+	node := p.newBlank()
+	p.skip()
+	if p.peek() != ']' {
+		p.predicateObjectList(node)
+	}
+	p.expect(']')
+	return node
+}
+
+func (p *turtle) collection() term {
+	p.expect('(')
+	p.enter()
+	defer p.leave()
+	var items []term
+	for p.skip(); p.peek() != ')'; p.skip() {
+		if p.pos >= len(p.src) {
+			p.fail("unterminated collection")
+		}
+		items = append(items, p.object())
+	}
+	p.pos++
+	head := iri(nsRDF + "nil")
+	for i := len(items) - 1; i >= 0; i-- {
+		node := p.newBlank()
+		p.triples = append(p.triples, triple{node, iri(nsRDF + "first"), items[i]}, triple{node, iri(nsRDF + "rest"), head})
+		head = node
+	}
+	return head
+}
+
+// iri parses an IRI reference or a prefixed name and returns the absolute IRI
+func (p *turtle) iri() string {
+	if p.peek() == '<' {
+		return p.iriref()
+	}
+	start := p.pos
+	for p.pos < len(p.src) && p.src[p.pos] != ':' && isNameChar(p.src[p.pos]) {
+		p.pos++
+	}
+	if p.peek() != ':' {
+		p.fail("expected IRI or prefixed name, but found '%s'", p.src[start:min(p.pos+1, len(p.src))])
+	}
+	prefix := p.src[start:p.pos]
+	ns, exist := p.prefixes[prefix]
+	if !exist {
+		p.fail("undefined prefix '%s'", prefix)
+	}
+	p.pos++
+	start = p.pos
+	p.name()
+	return ns + strings.Replace(p.src[start:p.pos], "\\", "", -1)
+}
+
+// name advances past the local part of a prefixed name or blank node label, not including a final '.'
+func (p *turtle) name() {
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		if c == '\\' && p.pos+1 < len(p.src) {
+			p.pos += 2
+			continue
+		}
+		if c == '.' && (p.pos+1 == len(p.src) || !isNameChar(p.src[p.pos+1]) || p.src[p.pos+1] == '.') {
+			return
+		}
+		if !isNameChar(c) && c != ':' {
+			return
+		}
+		p.pos++
+	}
+}
+
+func isNameChar(c byte) bool {
+	return c >= 0x80 || c == '_' || c == '-' || c == '.' || c == '%' || (c >= '0' && c <= '9') || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func (p *turtle) iriref() string {
+	if p.peek() != '<' {
+		p.fail("expected '<'")
+	}
+	end := strings.IndexByte(p.src[p.pos:], '>')
+	if end < 0 {
+		p.fail("unterminated IRI")
+	}
+	value := unescape(p.src[p.pos+1 : p.pos+end])
+	p.pos += end + 1
+	return resolve(p.base, value)
+}
+
+// resolve returns the IRI resolved against base when relative, sufficient for fragment and simple relative references
+func resolve(base string, ref string) string {
+	if base == "" || strings.Contains(ref, ":") {
+		return ref
+	}
+	if strings.HasPrefix(ref, "#") {
+		if i := strings.IndexByte(base, '#'); i >= 0 {
+			base = base[:i]
+		}
+		return base + ref
+	}
+	if i := strings.LastIndexAny(base, "/#"); i >= 0 {
+		return base[:i+1] + ref
+	}
+	return base + ref
+}
+
+func (p *turtle) literal() term {
+	quote := p.src[p.pos]
+	long := strings.Repeat(string(quote), 3)
+	var raw string
+	if strings.HasPrefix(p.src[p.pos:], long) {
+		end := strings.Index(p.src[p.pos+3:], long)
+		for end >= 0 && escaped(p.src[p.pos+3:], end) {
+			next := strings.Index(p.src[p.pos+3+end+1:], long)
+			if next < 0 {
+				end = -1
+				break
+			}
+			end += next + 1
+		}
+		if end < 0 {
+			p.fail("unterminated long string")
+		}
+		raw = p.src[p.pos+3 : p.pos+3+end]
+		p.line += strings.Count(raw, "\n")
+		p.pos += 3 + end + 3
+	} else {
+		end := p.pos + 1
+		for ; end < len(p.src) && p.src[end] != quote; end++ {
+			if p.src[end] == '\\' {
+				end++
+			} else if p.src[end] == '\n' {
+				p.fail("unterminated string")
+			}
+		}
+		if end >= len(p.src) {
+			p.fail("unterminated string")
+		}
+		raw = p.src[p.pos+1 : end]
+		p.pos = end + 1
+	}
+	lit := term{kind: termLiteral, value: unescape(raw)}
+	if p.peek() == '@' {
+		p.pos++
+		start := p.pos
+		for p.pos < len(p.src) && (isNameChar(p.src[p.pos]) && p.src[p.pos] != '.') {
+			p.pos++
+		}
+		lit.lang = p.src[start:p.pos]
+	} else if strings.HasPrefix(p.src[p.pos:], "^^") {
+		p.pos += 2
+		lit.datatype = p.iri()
+	}
+	return lit
+}
+
+// escaped reports whether the character at position i of s is preceded by an odd number of backslashes
+func escaped(s string, i int) bool {
+	n := 0
+	for i--; i >= 0 && s[i] == '\\'; i-- {
+		n++
+	}
+	return n%2 == 1
+}
+
+// unescape replaces string and numeric escapes of Turtle strings and IRIs
+func unescape(s string) string {
+	if !strings.Contains(s, "\\") {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case 't':
+			b.WriteByte('\t')
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 'b':
+			b.WriteByte('\b')
+		case 'f':
+			b.WriteByte('\f')
+		case 'u', 'U':
+			n := 4
+			if s[i] == 'U' {
+				n = 8
+			}
+			if i+n < len(s) {
+				if code, err := strconv.ParseUint(s[i+1:i+1+n], 16, 32); err == nil && utf8.ValidRune(rune(code)) {
+					b.WriteRune(rune(code))
+					i += n
+					continue
+				}
+			}
+			b.WriteByte(s[i])
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
+
+func (p *turtle) numeric() term {
+	start := p.pos
+	if c := p.peek(); c == '+' || c == '-' {
+		p.pos++
+	}
+	datatype := nsXSD + "integer"
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		switch {
+		case c >= '0' && c <= '9':
+		case c == '.' && p.pos+1 < len(p.src) && p.src[p.pos+1] >= '0' && p.src[p.pos+1] <= '9':
+			datatype = nsXSD + "decimal"
+		case c == 'e' || c == 'E':
+			datatype = nsXSD + "double"
+			if p.pos+1 < len(p.src) && (p.src[p.pos+1] == '+' || p.src[p.pos+1] == '-') {
+				p.pos++
+			}
+		default:
+			if p.pos == start {
+				p.fail("expected numeric literal")
+			}
+			return literal(p.src[start:p.pos], datatype)
+		}
+		p.pos++
+	}
+	return literal(p.src[start:p.pos], datatype)
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}